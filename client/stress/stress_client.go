@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/gorilla/websocket"
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// NOTE: Stress client, runs many concurrent sessions ending every way a
+// session can end, then checks the controller released them all. Run it
+// against a controller built with the race detector and the mock workers:
+// go build -race -o bello . && GORACE=halt_on_error=1 ./bello -c config
+// go run stt_consumer.go -sub voice-0
+// go run stress_client.go -url ws://localhost:8080/ -n 300
+
+const (
+	// 20ms of 16 bit mono audio at 16kHz
+	frameSize     = 640
+	frameInterval = 20 * time.Millisecond
+	sampleRate    = 16000
+)
+
+// scenarios are the ways a session is driven and ended
+var scenarios = map[string]func(*client) error{
+	// start, talk, stop and close the connection properly
+	"talk": func(c *client) error {
+		if err := c.start(); err != nil {
+			return err
+		}
+		c.audio(50, frameInterval)
+		c.action(entity.Action{Action: entity.ActionStop})
+		return c.close()
+	},
+	// go away in the middle of the audio without a close frame
+	"drop": func(c *client) error {
+		if err := c.start(); err != nil {
+			return err
+		}
+		c.audio(rand.Intn(50), frameInterval)
+		return c.conn.UnderlyingConn().Close()
+	},
+	// start again right after stopping, several times
+	"restart": func(c *client) error {
+		for i := 0; i < 3; i++ {
+			if err := c.start(); err != nil {
+				return err
+			}
+			c.audio(10, frameInterval)
+			c.action(entity.Action{Action: entity.ActionStop})
+		}
+		return c.close()
+	},
+	// play a prompt and key digits while talking
+	"speak": func(c *client) error {
+		if err := c.start(); err != nil {
+			return err
+		}
+		c.action(entity.Action{Action: entity.ActionSpeak, Text: "stress", BargeIn: true})
+		c.audio(10, frameInterval)
+		c.action(entity.Action{Action: entity.ActionDTMF, Digit: "1"})
+		c.audio(10, frameInterval)
+		return c.close()
+	},
+	// send audio faster than it can be published
+	"flood": func(c *client) error {
+		if err := c.start(); err != nil {
+			return err
+		}
+		c.audio(2000, 0)
+		return c.close()
+	},
+	// have the session closed by an operator while audio flows
+	"kill": func(c *client) error {
+		if err := c.start(); err != nil {
+			return err
+		}
+		done := make(chan struct{})
+		go func() {
+			c.audio(100, frameInterval)
+			close(done)
+		}()
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Millisecond)
+		err := c.kill()
+		<-done
+		return err
+	},
+}
+
+// errRefused is a session the controller had no room for
+type errRefused struct {
+	msg string
+}
+
+func (e errRefused) Error() string {
+	return "refused: " + e.msg
+}
+
+type client struct {
+	conn  *websocket.Conn
+	id    string
+	admin string
+	token string
+	mu    sync.Mutex
+}
+
+func dial(url string) (*client, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{conn: conn}
+	//the greeting carries the session id
+	resp := entity.Response{}
+	if err := conn.ReadJSON(&resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.ErrCode != entity.ErrOK {
+		conn.Close()
+		return nil, errRefused{resp.ErrMsg}
+	}
+	c.id = resp.SessionID
+	go c.read()
+	return c, nil
+}
+
+// read drains what the controller sends until the connection is gone
+func (c *client) read() {
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *client) write(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+func (c *client) action(a entity.Action) error {
+	data, err := ffjson.Marshal(&a)
+	if err != nil {
+		return err
+	}
+	return c.write(websocket.TextMessage, data)
+}
+
+// start needs no answer, the controller holds audio back until the
+// start action was handled
+func (c *client) start() error {
+	return c.action(entity.Action{
+		Action:     entity.ActionStart,
+		UID:        "stress-" + c.id,
+		Domain:     "stress",
+		SampleRate: sampleRate,
+	})
+}
+
+// audio sends n frames, interval apart
+func (c *client) audio(n int, interval time.Duration) {
+	frame := make([]byte, frameSize)
+	for i := 0; i < n; i++ {
+		if c.write(websocket.BinaryMessage, frame) != nil {
+			return
+		}
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+}
+
+func (c *client) close() error {
+	c.mu.Lock()
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+func (c *client) kill() error {
+	req, err := http.NewRequest(http.MethodDelete, c.admin+"/sessions/"+c.id+"?reason=stress", nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	c.conn.Close()
+	//the session may have ended already
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("session %s: close answered %d", c.id, resp.StatusCode)
+	}
+	return nil
+}
+
+// activeSessions reads bello_sessions_active from the metrics endpoint
+func activeSessions(url string) (float64, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "bello_sessions_active ") {
+			return strconv.ParseFloat(strings.TrimPrefix(line, "bello_sessions_active "), 64)
+		}
+	}
+	return 0, fmt.Errorf("bello_sessions_active not found at %s", url)
+}
+
+func usage() {
+	log.Printf("Usage: stress_client [-url ws url] [-admin url] [-token token] [-metrics url] [-n sessions] [-rounds n] [-settle duration]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var url = flag.String("url", "ws://localhost:8080/", "The controller WebSocket URL")
+	var admin = flag.String("admin", "http://localhost:8080/admin", "The admin API URL")
+	var token = flag.String("token", "", "Bearer token of an operator")
+	var metricsURL = flag.String("metrics", "http://localhost:8080/metrics", "The metrics URL")
+	var sessions = flag.Int("n", 200, "Concurrent sessions per round")
+	var rounds = flag.Int("rounds", 3, "Rounds to run")
+	var settle = flag.Duration("settle", 30*time.Second, "How long the controller may take to release the sessions")
+	var showHelp = flag.Bool("h", false, "Show help message")
+
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+
+	if *showHelp {
+		usage()
+		os.Exit(0)
+	}
+
+	names := make([]string, 0, len(scenarios))
+	for name := range scenarios {
+		names = append(names, name)
+	}
+	failed := 0
+	for round := 1; round <= *rounds; round++ {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		counts := map[string]int{}
+		errs, refused := 0, 0
+		for i := 0; i < *sessions; i++ {
+			name := names[rand.Intn(len(names))]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, err := dial(*url)
+				if err == nil {
+					c.admin, c.token = *admin, *token
+					err = scenarios[name](c)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				counts[name]++
+				if _, ok := err.(errRefused); ok {
+					refused++
+					return
+				}
+				if err != nil {
+					errs++
+					log.Printf("%s: %s", name, err)
+				}
+			}()
+		}
+		wg.Wait()
+		//too many refusals mean the controller has fewer subjects than -n
+		log.Printf("round %d: %v, %d refused, %d errors", round, counts, refused, errs)
+		failed += errs
+	}
+
+	//every session has to be released once the clients are gone
+	deadline := time.Now().Add(*settle)
+	for {
+		active, err := activeSessions(*metricsURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if active == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			log.Fatalf("%v sessions still active %s after the last client left", active, *settle)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if failed > 0 {
+		log.Fatalf("%d sessions failed", failed)
+	}
+	log.Printf("all sessions released")
+}
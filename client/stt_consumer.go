@@ -14,6 +14,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
@@ -21,15 +22,17 @@ import (
 	"time"
 
 	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/tracing"
 	"github.com/nats-io/nats.go"
 	"github.com/pquerna/ffjson/ffjson"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // NOTE: Can test with demo servers.
 // go run stt_consumer.go -sub voice-0
 
 func usage() {
-	log.Printf("Usage: stt_consumer [-nats server] [-sub subject] [-t]\n")
+	log.Printf("Usage: stt_consumer [-nats server] [-sub subject] [-t] [-trace otlp|stdout] [-otlp host:port] [-health subject]\n")
 	flag.PrintDefaults()
 }
 
@@ -49,6 +52,10 @@ func main() {
 	var showTime = flag.Bool("t", false, "Display timestamps")
 	var queueGroup = flag.String("q", "UASG1184", "Queue Group Name")
 	var showHelp = flag.Bool("h", false, "Show help message")
+	var result = flag.String("result", "good job", "Recognition result to answer with")
+	var exporter = flag.String("trace", "", "Export spans to otlp or stdout")
+	var endpoint = flag.String("otlp", "localhost:4318", "OTLP/HTTP collector")
+	var health = flag.String("health", "stt.health", "Subject the controller checks workers are alive on")
 
 	log.SetFlags(0)
 	flag.Usage = usage
@@ -58,6 +65,18 @@ func main() {
 		showUsageAndExit(0)
 	}
 
+	//spans continue the trace of the controller when NATS carries headers
+	err := tracing.Setup(tracing.Config{
+		Exporter:    *exporter,
+		Endpoint:    *endpoint,
+		Insecure:    true,
+		ServiceName: "stt-consumer",
+		SampleRatio: 1,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Connect Options.
 	opts := []nats.Option{nats.Name("NATS Sample Responder")}
 	opts = setupConnOptions(opts)
@@ -77,18 +96,44 @@ func main() {
 	mockResult := &entity.Response{
 		ErrCode:     0,
 		State:       "result",
-		RecogResult: "good job",
+		RecogResult: *result,
+		IsFinish:    true,
 	}
 
+	//recognition setup sent on start, grammars and hints arrive here. The
+	//controller holds the audio back until the setup is acknowledged.
+	nc.QueueSubscribe(*sub+".setup", *queueGroup, func(msg *nats.Msg) {
+		printMsg(msg, 0)
+		_, span := tracing.Tracer().Start(tracing.Extract(context.Background(), msg), "stt.setup",
+			trace.WithSpanKind(trace.SpanKindConsumer))
+		msg.Respond(nil)
+		span.End()
+	})
+
+	//an utterance lasts from its first frame to the result answering it
 	i := 1
+	var recognize trace.Span
+	var recognizeCtx context.Context
 	nc.QueueSubscribe(*sub, *queueGroup, func(msg *nats.Msg) {
 		printMsg(msg, i)
+		if recognize == nil {
+			recognizeCtx, recognize = tracing.Tracer().Start(tracing.Extract(context.Background(), msg), "stt.recognize",
+				trace.WithSpanKind(trace.SpanKindConsumer))
+		}
 		if i%5 == 0 {
 			result, _ := ffjson.Marshal(mockResult)
-			msg.Respond(result)
+			msg.RespondMsg(tracing.NewMsg(recognizeCtx, nc, msg.Reply, "", result))
+			recognize.End()
+			recognize = nil
 		}
 		i++
 	})
+	//readiness probes of the controller, every worker answers
+	if *health != "" {
+		nc.Subscribe(*health, func(msg *nats.Msg) {
+			msg.Respond([]byte("ok"))
+		})
+	}
 	nc.Flush()
 
 	if err := nc.LastError(); err != nil {
@@ -107,6 +152,7 @@ func main() {
 	log.Println()
 	log.Printf("Draining...")
 	nc.Drain()
+	tracing.Shutdown(context.Background())
 	log.Fatalf("Exiting")
 }
 
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"time"
+	"unicode/utf8"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/nats-io/nats.go"
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// NOTE: Mock TTS worker, answers every request with a sine tone whose
+// length depends on the text.
+// go run tts_consumer.go -sub tts
+
+const (
+	defaultSampleRate = 16000
+	// 100ms of 16 bit mono audio per chunk at the default rate
+	chunkDuration = 100 * time.Millisecond
+	// rough speaking rate used to size the tone
+	perRune    = 150 * time.Millisecond
+	minSpeech  = 500 * time.Millisecond
+	amplitude  = 0.3 * math.MaxInt16
+	defaultHz  = 440.0
+	defaultSub = "tts"
+)
+
+func usage() {
+	log.Printf("Usage: tts_consumer [-nats server] [-sub subject] [-hz frequency] [-realtime]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var urls = flag.String("nats", "localhost:4222", "The nats server URLs (separated by comma)")
+	var sub = flag.String("sub", defaultSub, "subscribe taget")
+	var queueGroup = flag.String("q", "TTSG1184", "Queue Group Name")
+	var freq = flag.Float64("hz", defaultHz, "Tone frequency")
+	var realtime = flag.Bool("realtime", false, "Pace chunks at playback speed")
+	var showHelp = flag.Bool("h", false, "Show help message")
+
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+
+	if *showHelp {
+		usage()
+		os.Exit(0)
+	}
+
+	nc, err := nats.Connect(*urls, nats.Name("NATS Sample TTS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nc.QueueSubscribe(*sub, *queueGroup, func(msg *nats.Msg) {
+		req := entity.SpeakRequest{}
+		if err := ffjson.Unmarshal(msg.Data, &req); err != nil {
+			log.Printf("bad request: %s", err)
+			nc.Publish(msg.Reply, nil)
+			return
+		}
+		log.Printf("Synthesize on [%s]: '%s'\n", msg.Subject, req.Text)
+		go synthesize(nc, msg.Reply, &req, *freq, *realtime)
+	})
+	nc.Flush()
+
+	if err := nc.LastError(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Listening on [%s]", *sub)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+	log.Println()
+	log.Printf("Draining...")
+	nc.Drain()
+	log.Fatalf("Exiting")
+}
+
+// synthesize streams 16 bit little endian PCM chunks to reply and ends the
+// stream with an empty message
+func synthesize(nc *nats.Conn, reply string, req *entity.SpeakRequest, freq float64, realtime bool) {
+	rate := req.SampleRate
+	if rate <= 0 {
+		rate = defaultSampleRate
+	}
+	duration := time.Duration(utf8.RuneCountInString(req.Text)) * perRune
+	if duration < minSpeech {
+		duration = minSpeech
+	}
+
+	total := int(duration.Seconds() * float64(rate))
+	perChunk := int(chunkDuration.Seconds() * float64(rate))
+	for start := 0; start < total; start += perChunk {
+		n := perChunk
+		if start+n > total {
+			n = total - start
+		}
+		buf := make([]byte, 2*n)
+		for i := 0; i < n; i++ {
+			t := float64(start+i) / float64(rate)
+			sample := int16(amplitude * math.Sin(2*math.Pi*freq*t))
+			binary.LittleEndian.PutUint16(buf[2*i:], uint16(sample))
+		}
+		if err := nc.Publish(reply, buf); err != nil {
+			log.Printf("publish failed: %s", err)
+			return
+		}
+		if realtime {
+			time.Sleep(chunkDuration)
+		}
+	}
+	nc.Publish(reply, nil)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/4406arthur/bello/pkg/audit"
+	"github.com/4406arthur/bello/pkg/auth"
+	"github.com/4406arthur/bello/pkg/metrics"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// Admin roles, a caller may hold several
+const (
+	//RoleViewer reads sessions, rules and limits
+	RoleViewer = "viewer"
+	//RoleOperator closes sessions and reads the configuration
+	RoleOperator = "operator"
+	//RoleRuleEditor changes call flows, grammars and client limits
+	RoleRuleEditor = "rule-editor"
+)
+
+// principalKey holds the admin caller in the gin context
+const principalKey = "admin_principal"
+
+//AdminGuard authenticates admin calls and checks their roles
+type AdminGuard struct {
+	//nil refuses every call
+	auth   auth.Authenticator
+	logger logger.Logger
+}
+
+//NewAdminGuard ...
+func NewAdminGuard(a auth.Authenticator, log logger.Logger) *AdminGuard {
+	return &AdminGuard{
+		auth:   a,
+		logger: log,
+	}
+}
+
+//Authenticate requires a bearer credential on every admin call
+func (g *AdminGuard) Authenticate() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if g.auth == nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		p, err := g.auth.Authenticate(auth.BearerToken(ctx.Request))
+		if err != nil {
+			method, reason := "", auth.ReasonMalformed
+			if aerr, ok := err.(*auth.Error); ok {
+				method, reason = aerr.Method, aerr.Reason
+			}
+			metrics.AdminAuthFailures.WithLabelValues(method, reason).Inc()
+			g.logger.Info("N", logger.BuildLogInfo(ctx), "admin "+err.Error())
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		ctx.Set(principalKey, p)
+		ctx.Next()
+	}
+}
+
+//Require lets a call through when the caller holds any of roles
+func (g *AdminGuard) Require(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if p := adminPrincipal(ctx); p != nil {
+			for _, role := range roles {
+				if p.HasRole(role) {
+					ctx.Next()
+					return
+				}
+			}
+		}
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	}
+}
+
+//Audit records every mutating call, refused ones included, once it has been
+//answered
+func (g *AdminGuard) Audit(log *audit.Log) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		record := audit.AdminRecord{
+			Time:     audit.Now(),
+			Kind:     audit.KindAdmin,
+			Actor:    "anonymous",
+			Method:   ctx.Request.Method,
+			Path:     ctx.Request.URL.Path,
+			Status:   ctx.Writer.Status(),
+			ClientIP: ctx.ClientIP(),
+		}
+		if p := adminPrincipal(ctx); p != nil {
+			record.Actor = p.Subject
+			record.AuthMethod = p.Method
+			record.Roles = p.Roles
+		}
+		if err := log.Write(&record); err != nil {
+			g.logger.Error("N", logger.Trace(), "cannot write audit record: "+err.Error())
+		}
+	}
+}
+
+func adminPrincipal(ctx *gin.Context) *auth.Principal {
+	v, ok := ctx.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	p, _ := v.(*auth.Principal)
+	return p
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/auth"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminGuard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := auth.Chain{auth.NewAPIKeys([]auth.APIKey{
+		{Name: "ops", Key: "ops-key", Roles: []string{RoleOperator}},
+		{Name: "ro", Key: "ro-key", Roles: []string{RoleViewer}},
+	})}
+	tests := []struct {
+		name  string
+		auth  auth.Authenticator
+		token string
+		want  int
+	}{
+		{"no authenticator", nil, "", http.StatusUnauthorized},
+		{"no authenticator with a token", nil, "ops-key", http.StatusUnauthorized},
+		{"no token", keys, "", http.StatusUnauthorized},
+		{"unknown key", keys, "guess", http.StatusUnauthorized},
+		{"missing role", keys, "ro-key", http.StatusForbidden},
+		{"granted", keys, "ops-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := NewAdminGuard(tt.auth, log)
+			r := gin.New()
+			r.Use(guard.Authenticate())
+			r.GET("/admin/config", guard.Require(RoleOperator), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/metrics"
+	"github.com/nats-io/nats.go"
+)
+
+// Session queues
+const (
+	//QueueAudioIn holds caller audio waiting to be published to NATS
+	QueueAudioIn = "audio_in"
+	//QueueResultsOut holds STT messages waiting to be written to the client
+	QueueResultsOut = "results_out"
+)
+
+// What happens to caller audio once its queue is full
+const (
+	OverflowDropOldest = "drop_oldest"
+	OverflowAbort      = "abort"
+)
+
+var (
+	errAudioOverflow = errors.New("audio is not published to the STT worker fast enough")
+	errSlowClient    = errors.New("client does not read STT results fast enough")
+)
+
+//Backpressure bounds what a session holds while NATS or the client falls
+//behind, zero values keep the defaults
+type Backpressure struct {
+	//AudioBuffer frames wait to be published, Overflow says what happens to
+	//the next one when they are all taken
+	AudioBuffer int
+	Overflow    string
+	//ResultBuffer STT messages wait for the client, a client keeping them
+	//all taken for SlowClient is closed
+	ResultBuffer int
+	SlowClient   time.Duration
+}
+
+var defaultBackpressure = Backpressure{
+	AudioBuffer:  30,
+	Overflow:     OverflowDropOldest,
+	ResultBuffer: 30,
+	SlowClient:   5 * time.Second,
+}
+
+//WithBackpressure ...
+func WithBackpressure(b Backpressure) StreamOption {
+	return func(s *StreamHandler) {
+		if b.AudioBuffer <= 0 {
+			b.AudioBuffer = defaultBackpressure.AudioBuffer
+		}
+		if b.Overflow == "" {
+			b.Overflow = defaultBackpressure.Overflow
+		}
+		if b.ResultBuffer <= 0 {
+			b.ResultBuffer = defaultBackpressure.ResultBuffer
+		}
+		if b.SlowClient <= 0 {
+			b.SlowClient = defaultBackpressure.SlowClient
+		}
+		s.backpressure = b
+	}
+}
+
+//audioQueue carries caller audio from the WebSocket reader to the NATS
+//publisher without ever blocking the reader
+type audioQueue struct {
+	frames   chan []byte
+	overflow string
+}
+
+func newAudioQueue(b Backpressure) *audioQueue {
+	return &audioQueue{frames: make(chan []byte, b.AudioBuffer), overflow: b.Overflow}
+}
+
+//push queues frame. A full queue loses its oldest frame, or fails with
+//errAudioOverflow under the abort policy.
+func (q *audioQueue) push(frame []byte) error {
+	for {
+		select {
+		case q.frames <- frame:
+			return nil
+		default:
+		}
+		if q.overflow == OverflowAbort {
+			metrics.FramesDropped.WithLabelValues(QueueAudioIn, "overflow").Inc()
+			return errAudioOverflow
+		}
+		select {
+		case <-q.frames:
+			metrics.FramesDropped.WithLabelValues(QueueAudioIn, "overflow").Inc()
+		default:
+			//the publisher took one meanwhile
+		}
+	}
+}
+
+//deliver hands an STT message to the session loop. The loop stops taking
+//them while a write to the client hangs, a client holding it up longer
+//than SlowClient fails with errSlowClient.
+func (s *StreamHandler) deliver(ctx context.Context, ch chan<- *nats.Msg, msg *nats.Msg) error {
+	select {
+	case ch <- msg:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(s.backpressure.SlowClient)
+	defer timer.Stop()
+	select {
+	case ch <- msg:
+		return nil
+	case <-timer.C:
+		metrics.FramesDropped.WithLabelValues(QueueResultsOut, "slow_client").Inc()
+		return errSlowClient
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/metrics"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAudioQueue(t *testing.T) {
+	dropped := func() float64 {
+		return testutil.ToFloat64(metrics.FramesDropped.WithLabelValues(QueueAudioIn, "overflow"))
+	}
+
+	//the oldest frames give way to the newest
+	before := dropped()
+	q := newAudioQueue(Backpressure{AudioBuffer: 3, Overflow: OverflowDropOldest})
+	for i := 0; i < 5; i++ {
+		if err := q.push([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for want := 2; want < 5; want++ {
+		if frame := <-q.frames; frame[0] != byte(want) {
+			t.Fatalf("got frame %d, want %d", frame[0], want)
+		}
+	}
+	if got := dropped() - before; got != 2 {
+		t.Fatalf("%v drops counted", got)
+	}
+
+	//or the session gives up
+	before = dropped()
+	q = newAudioQueue(Backpressure{AudioBuffer: 2, Overflow: OverflowAbort})
+	q.push([]byte{0})
+	q.push([]byte{1})
+	if err := q.push([]byte{2}); err != errAudioOverflow {
+		t.Fatalf("got %v", err)
+	}
+	if len(q.frames) != 2 || dropped()-before != 1 {
+		t.Fatalf("%d frames queued, %v drops counted", len(q.frames), dropped()-before)
+	}
+	if errorCause(errAudioOverflow) != CloseAudioOverflow {
+		t.Fatal("an overflow is not its own close cause")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	s := &StreamHandler{}
+	WithBackpressure(Backpressure{SlowClient: 100 * time.Millisecond})(s)
+	if s.backpressure.AudioBuffer != defaultBackpressure.AudioBuffer || s.backpressure.Overflow != OverflowDropOldest {
+		t.Fatalf("defaults not applied: %+v", s.backpressure)
+	}
+	msg := &nats.Msg{Data: []byte("result")}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		full bool
+		//the loop takes a message after this long, never when negative
+		takes time.Duration
+		want  error
+	}{
+		{"room left", context.Background(), false, -1, nil},
+		{"loop catches up", context.Background(), true, 30 * time.Millisecond, nil},
+		{"slow client", context.Background(), true, -1, errSlowClient},
+		{"session ended", cancelled, true, -1, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan *nats.Msg, 1)
+			if tt.full {
+				ch <- msg
+			}
+			if tt.takes >= 0 {
+				time.AfterFunc(tt.takes, func() { <-ch })
+			}
+			if err := s.deliver(tt.ctx, ch, msg); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if errorCause(errSlowClient) != CloseSlowClient {
+		t.Fatal("a slow client is not its own close cause")
+	}
+}
+
+func TestQueueDepths(t *testing.T) {
+	r := NewSessionRegistry()
+	for i, depths := range []map[string]int{
+		{QueueAudioIn: 3, QueueResultsOut: 0},
+		{QueueAudioIn: 5, QueueResultsOut: 2},
+	} {
+		depths := depths
+		r.add(SessionInfo{ID: strconv.Itoa(i)}, func() map[string]int { return depths })
+	}
+	got := r.QueueDepths()
+	if got[QueueAudioIn] != (metrics.QueueDepth{Total: 8, Max: 5}) || got[QueueResultsOut] != (metrics.QueueDepth{Total: 2, Max: 2}) {
+		t.Fatalf("got %+v", got)
+	}
+	r.remove("1")
+	r.remove("0")
+	//idle queues are still reported
+	if got := r.QueueDepths(); len(got) != 2 || got[QueueAudioIn] != (metrics.QueueDepth{}) {
+		t.Fatalf("got %+v", got)
+	}
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/4406arthur/bello/pkg/callflow"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+)
+
+//CallFlowHandler serves the call flow admin API
+type CallFlowHandler struct {
+	service *callflow.Service
+	logger  logger.Logger
+}
+
+//NewCallFlowHandler ...
+func NewCallFlowHandler(svc *callflow.Service, log logger.Logger) *CallFlowHandler {
+	return &CallFlowHandler{
+		service: svc,
+		logger:  log,
+	}
+}
+
+//Upload stores a new version of a flow, the format comes from ?format= or Content-Type
+func (h *CallFlowHandler) Upload(ctx *gin.Context) {
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f, err := h.service.Upload(body, flowFormat(ctx))
+	if err != nil {
+		if verr, ok := err.(*callflow.ValidationError); ok {
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid call flow", "problems": verr.Problems})
+			return
+		}
+		//other replicas kept storing versions ahead of this one
+		if err == callflow.ErrVersionTaken {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		//storage errors may name hosts and collections, callers only learn
+		//that the upload failed
+		h.logger.Error("N", logger.Trace(), "cannot store call flow: "+err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "cannot store call flow"})
+		return
+	}
+	ctx.JSON(http.StatusCreated, f)
+}
+
+//List returns every domain with its active version
+func (h *CallFlowHandler) List(ctx *gin.Context) {
+	domains, err := h.service.Domains()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out := make([]gin.H, 0, len(domains))
+	for _, d := range domains {
+		item := gin.H{"domain": d}
+		if f, err := h.service.Active(d); err == nil {
+			item["active_version"] = f.Version
+		}
+		out = append(out, item)
+	}
+	ctx.JSON(http.StatusOK, out)
+}
+
+//Active returns the active flow of a domain
+func (h *CallFlowHandler) Active(ctx *gin.Context) {
+	f, err := h.service.Active(ctx.Param("domain"))
+	h.reply(ctx, f, err)
+}
+
+//Versions lists all versions of a domain
+func (h *CallFlowHandler) Versions(ctx *gin.Context) {
+	flows, err := h.service.Versions(ctx.Param("domain"))
+	if err == nil && len(flows) == 0 {
+		err = callflow.ErrNotFound
+	}
+	h.reply(ctx, flows, err)
+}
+
+//Version returns a single version of a domain
+func (h *CallFlowHandler) Version(ctx *gin.Context) {
+	version, err := strconv.Atoi(ctx.Param("version"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "version must be a number"})
+		return
+	}
+	f, err := h.service.Get(ctx.Param("domain"), version)
+	h.reply(ctx, f, err)
+}
+
+//Activate rolls a domain forward or back to an uploaded version
+func (h *CallFlowHandler) Activate(ctx *gin.Context) {
+	version, err := strconv.Atoi(ctx.Param("version"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "version must be a number"})
+		return
+	}
+	if err := h.service.Activate(ctx.Param("domain"), version); err != nil {
+		h.reply(ctx, nil, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"domain": ctx.Param("domain"), "active_version": version})
+}
+
+func (h *CallFlowHandler) reply(ctx *gin.Context, obj interface{}, err error) {
+	switch {
+	case err == callflow.ErrNotFound:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		h.logger.Error("N", logger.Trace(), err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusOK, obj)
+	}
+}
+
+func flowFormat(ctx *gin.Context) string {
+	if f := ctx.Query("format"); f != "" {
+		return f
+	}
+	if strings.Contains(ctx.ContentType(), "yaml") {
+		return callflow.FormatYAML
+	}
+	return callflow.FormatJSON
+}
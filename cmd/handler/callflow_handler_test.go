@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/callflow"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+)
+
+type failingFlows struct {
+	callflow.Repository
+	err error
+}
+
+func (r failingFlows) Store(*callflow.Flow) error {
+	return r.err
+}
+
+func TestCallFlowUploadStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const flow = "domain: d\nstart: a\nnodes:\n  - id: a\n    type: hangup\n"
+	tests := []struct {
+		name string
+		repo callflow.Repository
+		body string
+		want int
+	}{
+		{"stored", callflow.NewMemoryRepository(), flow, http.StatusCreated},
+		{"invalid", callflow.NewMemoryRepository(), "domain: d\nstart: b\n", http.StatusUnprocessableEntity},
+		{"malformed", callflow.NewMemoryRepository(), "domain: [", http.StatusUnprocessableEntity},
+		{"version taken", failingFlows{callflow.NewMemoryRepository(), callflow.ErrVersionTaken}, flow, http.StatusConflict},
+		{"storage failure", failingFlows{callflow.NewMemoryRepository(), errors.New("mongo-1:27017 unreachable")}, flow, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewCallFlowHandler(callflow.NewService(tt.repo, log), log)
+			r := gin.New()
+			r.POST("/callflows", h.Upload)
+			req := httptest.NewRequest(http.MethodPost, "/callflows?format=yaml", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if strings.Contains(w.Body.String(), "mongo") {
+				t.Fatalf("storage details sent to the caller: %s", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/4406arthur/bello/pkg/config"
+	"github.com/gin-gonic/gin"
+)
+
+//ConfigHandler serves the configuration admin API
+type ConfigHandler struct {
+	config *config.Live
+}
+
+//NewConfigHandler ...
+func NewConfigHandler(cfg *config.Live) *ConfigHandler {
+	return &ConfigHandler{config: cfg}
+}
+
+//Get returns the effective configuration with credentials masked
+func (h *ConfigHandler) Get(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.config.Get().Settings())
+}
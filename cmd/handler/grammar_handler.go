@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/grammar"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+)
+
+//GrammarHandler serves the grammar admin API
+type GrammarHandler struct {
+	service *grammar.Service
+	logger  logger.Logger
+}
+
+//NewGrammarHandler ...
+func NewGrammarHandler(svc *grammar.Service, log logger.Logger) *GrammarHandler {
+	return &GrammarHandler{
+		service: svc,
+		logger:  log,
+	}
+}
+
+//Put stores a grammar under :id, the type comes from ?type= or Content-Type
+//(XML for SRGS, JSON for phrase lists)
+func (h *GrammarHandler) Put(ctx *gin.Context) {
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	g, err := h.service.Put(ctx.Param("id"), grammarType(ctx), body)
+	if _, ok := err.(*grammar.ValidationError); ok {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("N", logger.Trace(), err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, g)
+}
+
+//List ...
+func (h *GrammarHandler) List(ctx *gin.Context) {
+	grammars, err := h.service.List()
+	if err != nil {
+		h.logger.Error("N", logger.Trace(), err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, grammars)
+}
+
+//Get ...
+func (h *GrammarHandler) Get(ctx *gin.Context) {
+	g, err := h.service.Get(ctx.Param("id"))
+	switch {
+	case err == grammar.ErrNotFound:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		h.logger.Error("N", logger.Trace(), err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusOK, g)
+	}
+}
+
+//Delete ...
+func (h *GrammarHandler) Delete(ctx *gin.Context) {
+	err := h.service.Delete(ctx.Param("id"))
+	switch {
+	case err == grammar.ErrNotFound:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		h.logger.Error("N", logger.Trace(), err.Error())
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+func grammarType(ctx *gin.Context) string {
+	if t := ctx.Query("type"); t != "" {
+		return t
+	}
+	if strings.Contains(ctx.ContentType(), "xml") {
+		return entity.GrammarSRGS
+	}
+	return entity.GrammarPhrases
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/grammar"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+)
+
+type failingGrammars struct {
+	grammar.Repository
+}
+
+func (failingGrammars) Put(*grammar.Grammar) error {
+	return errors.New("storage down")
+}
+
+func TestGrammarPutStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const srgs = `<grammar version="1.0"><rule id="a">yes</rule></grammar>`
+	tests := []struct {
+		name string
+		repo grammar.Repository
+		body string
+		want int
+	}{
+		{"stored", grammar.NewMemoryRepository(), srgs, http.StatusOK},
+		{"invalid", grammar.NewMemoryRepository(), "<grammar", http.StatusUnprocessableEntity},
+		{"storage failure", failingGrammars{grammar.NewMemoryRepository()}, srgs, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewGrammarHandler(grammar.NewService(tt.repo, log), log)
+			r := gin.New()
+			r.PUT("/grammars/:id", h.Put)
+			req := httptest.NewRequest(http.MethodPut, "/grammars/g?type=srgs", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
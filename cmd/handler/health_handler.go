@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/health"
+	"github.com/gin-gonic/gin"
+)
+
+//readyTimeout bounds every readiness check, probes give up soon after
+const readyTimeout = 2 * time.Second
+
+//HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	readiness *health.Readiness
+}
+
+//NewHealthHandler ...
+func NewHealthHandler(r *health.Readiness) *HealthHandler {
+	return &HealthHandler{readiness: r}
+}
+
+//Livez answers as long as the process serves HTTP, a failing dependency
+//is no reason to restart it
+func (h *HealthHandler) Livez(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+}
+
+//Readyz runs the readiness checks and reports each of them, it fails with
+//503 when one does or while shutting down
+func (h *HealthHandler) Readyz(ctx *gin.Context) {
+	c, cancel := context.WithTimeout(ctx.Request.Context(), readyTimeout)
+	defer cancel()
+	report := h.readiness.Run(c)
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, report)
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/health"
+	"github.com/gin-gonic/gin"
+)
+
+func TestHealthProbes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	readiness := health.NewReadiness()
+	var natsErr error
+	readiness.Add("nats", func(ctx context.Context) error { return natsErr })
+	h := NewHealthHandler(readiness)
+	r := gin.New()
+	r.GET("/livez", h.Livez)
+	r.GET("/readyz", h.Readyz)
+	get := func(path string) (int, health.Report) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		report := health.Report{}
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		drain bool
+		ready int
+		state string
+	}{
+		{"ready", nil, false, http.StatusOK, health.StatusReady},
+		{"dependency down", errors.New("connection refused"), false, http.StatusServiceUnavailable, health.StatusNotReady},
+		{"draining", nil, true, http.StatusServiceUnavailable, health.StatusDraining},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			natsErr = tt.err
+			if tt.drain {
+				readiness.Drain()
+			}
+			code, report := get("/readyz")
+			if code != tt.ready || report.Status != tt.state {
+				t.Fatalf("readyz %d %+v", code, report)
+			}
+			if failed := report.Checks["nats"].Status == health.StatusFailed; failed != (tt.err != nil) {
+				t.Fatalf("nats check %+v", report.Checks["nats"])
+			}
+			//a failing dependency or a shutdown is no reason to restart
+			if code, _ := get("/livez"); code != http.StatusOK {
+				t.Fatalf("livez %d", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gorilla/websocket"
+)
+
+//keepalive makes reads fail once neither a pong nor a frame came within
+//the ping interval and the pong timeout, so a client gone without closing
+//its TCP connection is noticed. It has to be called before the connection
+//is read.
+func (s *StreamHandler) keepalive(ws *websocket.Conn) {
+	s.alive(ws)
+	ws.SetPongHandler(func(string) error {
+		s.alive(ws)
+		return nil
+	})
+}
+
+//ping pings the client until ctx is done
+func (s *StreamHandler) ping(ctx context.Context, ws *websocket.Conn) error {
+	ticker := time.NewTicker(s.Timeouts().Ping)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			//a failed ping shows up as a failed read soon enough
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.Timeouts().Write)); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+//alive pushes the read deadline back after the client showed up
+func (s *StreamHandler) alive(ws *websocket.Conn) {
+	t := s.Timeouts()
+	ws.SetReadDeadline(time.Now().Add(t.Ping + t.Pong))
+}
+
+//unresponsive ends a session whose client stopped reading
+func (s *StreamHandler) unresponsive(sess *session, err error) {
+	sess.log.Error("N", logger.Trace(), "cannot write to client: "+err.Error())
+	sess.trail.Error(err.Error())
+	sess.close(CloseUnresponsive, err.Error())
+}
+
+//writeJSON sends v to the client, giving up after timeout
+func writeJSON(ws *websocket.Conn, v interface{}, timeout time.Duration) error {
+	ws.SetWriteDeadline(time.Now().Add(timeout))
+	return ws.WriteJSON(v)
+}
+
+//writeMessage sends a frame to the client, giving up after timeout
+func writeMessage(ws *websocket.Conn, messageType int, data []byte, timeout time.Duration) error {
+	ws.SetWriteDeadline(time.Now().Add(timeout))
+	return ws.WriteMessage(messageType, data)
+}
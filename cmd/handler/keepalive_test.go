@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/audit"
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestKeepalive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := runNATS(t)
+	defer ns.Shutdown()
+	worker := runWorker(t, ns.ClientURL(), 2)
+	defer worker.Close()
+	pool, err := stream.NewPool(ns.ClientURL(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	sink := &memorySink{}
+	auditLog, err := audit.NewLog(sink, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewStreamHandler(pool, stream.NewManager("stt", 2, log), log,
+		WithAudit(auditLog),
+		WithTimeouts(Timeouts{Idle: 10 * time.Second, Ping: 50 * time.Millisecond, Pong: 100 * time.Millisecond}),
+	)
+	r := gin.New()
+	r.GET("/", h.Flow)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	//reading answers the pings, the client is kept although it sends nothing
+	answering, answeringID := dial(t, url)
+	if answering == nil {
+		return
+	}
+	defer answering.Close()
+	pings := make(chan struct{}, 100)
+	answering.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return answering.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go readUntilClosed(answering)
+
+	//a client stuck without reading never answers
+	stuck, stuckID := dial(t, url)
+	if stuck == nil {
+		return
+	}
+	defer stuck.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	if len(pings) < 3 {
+		t.Fatalf("%d pings in 500ms", len(pings))
+	}
+	live := h.Sessions().List()
+	if len(live) != 1 || live[0].ID != answeringID {
+		t.Fatalf("live sessions %+v, want only %s", live, answeringID)
+	}
+	if err := h.DrainTrails(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if recs := sink.sessions()[stuckID]; len(recs) != 1 {
+		t.Fatalf("stuck session has %d audit records", len(recs))
+	}
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Session debug rules last defaultDebugTTL unless asked otherwise, never
+// more than maxDebugTTL
+const (
+	defaultDebugTTL = 15 * time.Minute
+	maxDebugTTL     = 24 * time.Hour
+)
+
+//LogHandler serves the logging admin API: the level of the logger and the
+//sessions logged at debug level whatever that level
+type LogHandler struct {
+	rules  *logger.DebugRules
+	logger logger.Logger
+}
+
+//NewLogHandler ...
+func NewLogHandler(rules *logger.DebugRules, log logger.Logger) *LogHandler {
+	return &LogHandler{
+		rules:  rules,
+		logger: log,
+	}
+}
+
+type levelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+type debugRequest struct {
+	UID      string `json:"uid"`
+	ClientIP string `json:"client_ip"`
+	Platform string `json:"platform"`
+	//a duration such as 30m, 15m when empty
+	TTL string `json:"ttl"`
+}
+
+//Get returns the level and the active debug rules
+func (h *LogHandler) Get(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"level": h.logger.GetLogger().GetLevel().String(),
+		"debug": h.rules.List(),
+	})
+}
+
+//SetLevel changes the level until the next restart, or until log_level is
+//changed in the configuration
+func (h *LogHandler) SetLevel(ctx *gin.Context) {
+	req := levelRequest{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.GetLogger().SetLevel(level)
+	h.logger.Info("N", logger.Trace(), "log level changed to "+level.String())
+	ctx.JSON(http.StatusOK, gin.H{"level": level.String()})
+}
+
+//AddDebug logs the sessions matching every criterion given at debug level
+//until the rule expires
+func (h *LogHandler) AddDebug(ctx *gin.Context) {
+	req := debugRequest{}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ttl := defaultDebugTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ttl = d
+	}
+	if ttl <= 0 || ttl > maxDebugTTL {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "ttl must be positive and at most " + maxDebugTTL.String()})
+		return
+	}
+	rule, err := h.rules.Add(logger.DebugRule{
+		UID:      req.UID,
+		ClientIP: req.ClientIP,
+		Platform: req.Platform,
+	}, ttl)
+	if err == logger.ErrEmptyRule {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.Info("N", logger.Trace(), "session debug rule "+rule.ID+" added for "+ttl.String())
+	ctx.JSON(http.StatusCreated, rule)
+}
+
+//RemoveDebug drops the debug rule :id before it expires
+func (h *LogHandler) RemoveDebug(ctx *gin.Context) {
+	if !h.rules.Remove(ctx.Param("id")) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no debug rule " + ctx.Param("id")})
+		return
+	}
+	h.logger.Info("N", logger.Trace(), "session debug rule "+ctx.Param("id")+" removed")
+	ctx.Status(http.StatusNoContent)
+}
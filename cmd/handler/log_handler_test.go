@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := logger.NewDebugRules()
+	h := NewLogHandler(rules, log)
+	r := gin.New()
+	r.GET("/log", h.Get)
+	r.PUT("/log/level", h.SetLevel)
+	r.POST("/log/debug", h.AddDebug)
+	r.DELETE("/log/debug/:id", h.RemoveDebug)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"level", http.MethodPut, "/log/level", `{"level":"warn"}`, http.StatusOK},
+		{"unknown level", http.MethodPut, "/log/level", `{"level":"loud"}`, http.StatusUnprocessableEntity},
+		{"no level", http.MethodPut, "/log/level", `{}`, http.StatusBadRequest},
+		{"rule without criteria", http.MethodPost, "/log/debug", `{"ttl":"1m"}`, http.StatusUnprocessableEntity},
+		{"bad ttl", http.MethodPost, "/log/debug", `{"uid":"u1","ttl":"soon"}`, http.StatusBadRequest},
+		{"ttl too long", http.MethodPost, "/log/debug", `{"uid":"u1","ttl":"25h"}`, http.StatusUnprocessableEntity},
+		{"negative ttl", http.MethodPost, "/log/debug", `{"uid":"u1","ttl":"-1m"}`, http.StatusUnprocessableEntity},
+		{"unknown rule", http.MethodDelete, "/log/debug/nope", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(tt.method, tt.path, tt.body); w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+	if log.Log.GetLevel() != logrus.WarnLevel {
+		t.Fatalf("level is %s", log.Log.GetLevel())
+	}
+	if len(rules.List()) != 0 {
+		t.Fatalf("refused rules were added: %+v", rules.List())
+	}
+
+	w := serve(http.MethodPost, "/log/debug", `{"uid":"u1"}`)
+	rule := logger.DebugRule{}
+	if err := json.Unmarshal(w.Body.Bytes(), &rule); w.Code != http.StatusCreated || err != nil {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	if ttl := time.Until(rule.ExpiresAt); ttl <= defaultDebugTTL-time.Minute || ttl > defaultDebugTTL {
+		t.Fatalf("rule expires in %v", ttl)
+	}
+	got := struct {
+		Level string             `json:"level"`
+		Debug []logger.DebugRule `json:"debug"`
+	}{}
+	w = serve(http.MethodGet, "/log", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Level != "warning" || len(got.Debug) != 1 || got.Debug[0].ID != rule.ID {
+		t.Fatalf("got %s", w.Body.String())
+	}
+	if w := serve(http.MethodDelete, "/log/debug/"+rule.ID, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("got %d removing the rule", w.Code)
+	}
+	if rules.Match(logger.Fields{logger.FieldUID: "u1"}) {
+		t.Fatal("removed rule still matches")
+	}
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/4406arthur/bello/pkg/quota"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+)
+
+//QuotaHandler serves the client limits admin API
+type QuotaHandler struct {
+	limiter *quota.Limiter
+	logger  logger.Logger
+}
+
+//NewQuotaHandler ...
+func NewQuotaHandler(l *quota.Limiter, log logger.Logger) *QuotaHandler {
+	return &QuotaHandler{
+		limiter: l,
+		logger:  log,
+	}
+}
+
+//List returns the default limits and every client rule
+func (h *QuotaHandler) List(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		quota.DefaultKey: h.limiter.Defaults(),
+		"rules":          h.limiter.Rules(),
+	})
+}
+
+//Get returns the limits applying to :key and its current usage, keys look
+//like client:<name> or ip:<address>
+func (h *QuotaHandler) Get(ctx *gin.Context) {
+	limits, usage := h.limiter.Limits(ctx.Param("key"))
+	ctx.JSON(http.StatusOK, gin.H{
+		"key":    ctx.Param("key"),
+		"limits": limits,
+		"usage":  usage,
+	})
+}
+
+//Put sets the limits of :key, "default" changes the defaults
+func (h *QuotaHandler) Put(ctx *gin.Context) {
+	limits := quota.Limits{}
+	if err := ctx.ShouldBindJSON(&limits); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if limits.SessionsPerMinute < 0 || limits.SessionBurst < 0 || limits.MaxConcurrent < 0 || limits.AudioSecondsPerDay < 0 {
+		ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "limits must not be negative"})
+		return
+	}
+	h.limiter.Set(ctx.Param("key"), limits)
+	h.logger.Info("N", logger.Trace(), "quota of "+ctx.Param("key")+" changed")
+	ctx.JSON(http.StatusOK, limits)
+}
+
+//Delete drops the rule of :key
+func (h *QuotaHandler) Delete(ctx *gin.Context) {
+	if !h.limiter.Delete(ctx.Param("key")) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no rule for " + ctx.Param("key")})
+		return
+	}
+	h.logger.Info("N", logger.Trace(), "quota of "+ctx.Param("key")+" removed")
+	ctx.Status(http.StatusNoContent)
+}
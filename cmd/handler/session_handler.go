@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+)
+
+//SessionHandler serves the live session admin API
+type SessionHandler struct {
+	registry *SessionRegistry
+	logger   logger.Logger
+}
+
+//NewSessionHandler ...
+func NewSessionHandler(r *SessionRegistry, log logger.Logger) *SessionHandler {
+	return &SessionHandler{
+		registry: r,
+		logger:   log,
+	}
+}
+
+//List ...
+func (h *SessionHandler) List(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, h.registry.List())
+}
+
+//Close ends session :id, the client receives ?reason= with ErrCanNotUse
+func (h *SessionHandler) Close(ctx *gin.Context) {
+	reason := ctx.Query("reason")
+	if reason == "" {
+		reason = "session closed by operator"
+	}
+	if !h.registry.Close(ctx.Param("id"), reason) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no session " + ctx.Param("id")})
+		return
+	}
+	h.logger.Info("N", logger.Trace(), "session "+ctx.Param("id")+" closed: "+reason)
+	ctx.Status(http.StatusAccepted)
+}
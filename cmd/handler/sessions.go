@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/metrics"
+)
+
+//SessionInfo is what the admin API shows of a live session
+type SessionInfo struct {
+	ID string `json:"id"`
+	//Client is the authenticated subject, empty for anonymous clients
+	Client    string    `json:"client,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	UID       string    `json:"uid,omitempty"`
+	Domain    string    `json:"domain,omitempty"`
+	Platform  string    `json:"platform,omitempty"`
+	Subject   string    `json:"subject"`
+	StartedAt time.Time `json:"started_at"`
+	//Queues are the frames waiting in each queue of the session
+	Queues map[string]int `json:"queues,omitempty"`
+}
+
+type liveSession struct {
+	mu   sync.Mutex
+	info SessionInfo
+	//receives why the session is closed by an operator or a shutdown
+	kill chan closeRequest
+	//measures the queues of the session
+	queues func() map[string]int
+}
+
+//closeRequest ends a session from outside, cause is a Close constant and
+//message what the client is told
+type closeRequest struct {
+	cause   string
+	reason  string
+	message string
+}
+
+//started records who the caller is once the start action came in
+func (l *liveSession) started(action *entity.Action) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.info.UID = action.UID
+	l.info.Domain = action.Domain
+	l.info.Platform = action.Platform
+}
+
+//SessionRegistry tracks the live sessions of this instance
+type SessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]*liveSession
+}
+
+//NewSessionRegistry ...
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: make(map[string]*liveSession)}
+}
+
+func (r *SessionRegistry) add(info SessionInfo, queues func() map[string]int) *liveSession {
+	l := &liveSession{info: info, kill: make(chan closeRequest, 1), queues: queues}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[info.ID] = l
+	return l
+}
+
+func (r *SessionRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+}
+
+//List returns the live sessions, oldest first
+func (r *SessionRegistry) List() []SessionInfo {
+	r.mu.RLock()
+	list := make([]SessionInfo, 0, len(r.sessions))
+	for _, l := range r.sessions {
+		l.mu.Lock()
+		info := l.info
+		l.mu.Unlock()
+		info.Queues = l.queues()
+		list = append(list, info)
+	}
+	r.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
+
+//Close asks the session to end, it returns false for an unknown id
+func (r *SessionRegistry) Close(id, reason string) bool {
+	r.mu.RLock()
+	l, ok := r.sessions[id]
+	r.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	select {
+	case l.kill <- closeRequest{cause: CloseOperator, reason: "closed by operator: " + reason, message: reason}:
+	default:
+		//already asked
+	}
+	return true
+}
+
+//Len is the number of live sessions
+func (r *SessionRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.sessions)
+}
+
+//Shutdown asks every live session to end as the controller stops
+func (r *SessionRegistry) Shutdown() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, l := range r.sessions {
+		select {
+		case l.kill <- closeRequest{cause: CloseShutdown, reason: "server shutting down", message: "server shutting down"}:
+		default:
+		}
+	}
+}
+
+//QueueDepths sums the queues of the live sessions
+func (r *SessionRegistry) QueueDepths() map[string]metrics.QueueDepth {
+	out := map[string]metrics.QueueDepth{QueueAudioIn: {}, QueueResultsOut: {}}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, l := range r.sessions {
+		for queue, n := range l.queues() {
+			d := out[queue]
+			d.Total += n
+			if n > d.Max {
+				d.Max = n
+			}
+			out[queue] = d
+		}
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/pkg/tracing"
+	"github.com/nats-io/nats.go"
+)
+
+// speechChunk is either a slice of synthesized audio or, with end set, the
+// outcome of the prompt being played
+type speechChunk struct {
+	audio []byte
+	end   bool
+	err   error
+}
+
+// speechPlayer plays prompts one after another for a single session. It is
+// owned by the session loop, only the synthesis goroutine runs concurrently.
+type speechPlayer struct {
+	ctx     context.Context
+	nc      *nats.Conn
+	subject string
+	// chunkTimeout is how long the player waits between two audio chunks
+	chunkTimeout time.Duration
+
+	queue   []*entity.SpeakRequest
+	current *entity.SpeakRequest
+	chunks  chan speechChunk
+	cancel  context.CancelFunc
+}
+
+func newSpeechPlayer(ctx context.Context, nc *nats.Conn, subject string, chunkTimeout time.Duration) *speechPlayer {
+	return &speechPlayer{
+		ctx:          ctx,
+		nc:           nc,
+		subject:      subject,
+		chunkTimeout: chunkTimeout,
+	}
+}
+
+// Enqueue schedules a prompt, it starts right away when nothing is playing
+func (p *speechPlayer) Enqueue(req *entity.SpeakRequest) {
+	p.queue = append(p.queue, req)
+	if p.current == nil {
+		p.next()
+	}
+}
+
+// Chunks is nil while idle so the session loop can select on it directly
+func (p *speechPlayer) Chunks() <-chan speechChunk {
+	return p.chunks
+}
+
+// Current returns the prompt being played
+func (p *speechPlayer) Current() *entity.SpeakRequest {
+	return p.current
+}
+
+// Done must be called once the end chunk of the current prompt was handled
+func (p *speechPlayer) Done() {
+	p.release()
+	p.next()
+}
+
+// Stop cancels the current prompt and drops everything queued
+func (p *speechPlayer) Stop() {
+	p.queue = nil
+	p.release()
+}
+
+func (p *speechPlayer) release() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.current, p.chunks, p.cancel = nil, nil, nil
+}
+
+func (p *speechPlayer) next() {
+	if len(p.queue) == 0 {
+		return
+	}
+	req := p.queue[0]
+	p.queue = p.queue[1:]
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	chunks := make(chan speechChunk, 8)
+	p.current, p.chunks, p.cancel = req, chunks, cancel
+
+	go func() {
+		audio := make(chan []byte)
+		result := make(chan error, 1)
+		go func() {
+			ctx, span := tracing.Tracer().Start(ctx, "tts.speak")
+			err := stream.Speak(ctx, p.nc, p.subject, req, audio, p.chunkTimeout)
+			endSpan(span, err)
+			result <- err
+		}()
+		for {
+			select {
+			case data := <-audio:
+				select {
+				case chunks <- speechChunk{audio: data}:
+				case <-ctx.Done():
+					return
+				}
+			case err := <-result:
+				select {
+				case chunks <- speechChunk{end: true, err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+	}()
+}
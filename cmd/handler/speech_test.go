@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/nats-io/nats.go"
+)
+
+//runTTS answers every synthesis request with its text as the only chunk,
+//except "stall" which never hears back
+func runTTS(t *testing.T, url string) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = nc.Subscribe("tts", func(msg *nats.Msg) {
+		req := entity.SpeakRequest{}
+		json.Unmarshal(msg.Data, &req)
+		if req.Text == "stall" {
+			return
+		}
+		nc.Publish(msg.Reply, []byte(req.Text))
+		nc.Publish(msg.Reply, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.Flush()
+	return nc
+}
+
+func nextChunk(t *testing.T, p *speechPlayer) speechChunk {
+	t.Helper()
+	select {
+	case chunk := <-p.Chunks():
+		return chunk
+	case <-time.After(5 * time.Second):
+		t.Fatal("no chunk")
+	}
+	return speechChunk{}
+}
+
+func TestSpeechPlayer(t *testing.T) {
+	ns := runNATS(t)
+	defer ns.Shutdown()
+	worker := runTTS(t, ns.ClientURL())
+	defer worker.Close()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	p := newSpeechPlayer(context.Background(), nc, "tts", 200*time.Millisecond)
+	if p.Chunks() != nil || p.Current() != nil {
+		t.Fatal("an idle player has a prompt")
+	}
+
+	//prompts play in order, one at a time
+	p.Enqueue(&entity.SpeakRequest{Text: "one"})
+	p.Enqueue(&entity.SpeakRequest{Text: "two"})
+	for _, text := range []string{"one", "two"} {
+		if p.Current().Text != text {
+			t.Fatalf("playing %q, want %q", p.Current().Text, text)
+		}
+		if chunk := nextChunk(t, p); string(chunk.audio) != text {
+			t.Fatalf("got %+v", chunk)
+		}
+		if chunk := nextChunk(t, p); !chunk.end || chunk.err != nil {
+			t.Fatalf("got %+v", chunk)
+		}
+		p.Done()
+	}
+	if p.Chunks() != nil || p.Current() != nil {
+		t.Fatal("player not idle after the last prompt")
+	}
+
+	//a stalled worker ends the prompt with an error
+	p.Enqueue(&entity.SpeakRequest{Text: "stall"})
+	if chunk := nextChunk(t, p); !chunk.end || chunk.err != stream.ErrSpeakTimeout {
+		t.Fatalf("got %+v", chunk)
+	}
+	p.Done()
+
+	//Stop drops the queue along with the prompt playing
+	p.Enqueue(&entity.SpeakRequest{Text: "stall"})
+	p.Enqueue(&entity.SpeakRequest{Text: "three"})
+	p.Stop()
+	if p.Chunks() != nil || p.Current() != nil {
+		t.Fatal("player not idle after Stop")
+	}
+}
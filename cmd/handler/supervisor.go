@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"sync"
+)
+
+//supervisor runs the goroutines of a session. The first error one of them
+//returns cancels the others and is kept, errors coming once the session is
+//stopping are dropped. Nothing a goroutine uses may be released before Wait
+//returns.
+type supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+	failed chan struct{}
+}
+
+func newSupervisor(parent context.Context) *supervisor {
+	ctx, cancel := context.WithCancel(parent)
+	return &supervisor{ctx: ctx, cancel: cancel, failed: make(chan struct{})}
+}
+
+//Context is done once the session stops or one of its goroutines failed
+func (g *supervisor) Context() context.Context {
+	return g.ctx
+}
+
+//Go runs f in a goroutine of the session
+func (g *supervisor) Go(f func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := f(g.ctx); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+func (g *supervisor) fail(err error) {
+	g.once.Do(func() {
+		if g.ctx.Err() != nil {
+			return
+		}
+		g.err = err
+		close(g.failed)
+		g.cancel()
+	})
+}
+
+//Failed is closed once a goroutine failed, Err tells why
+func (g *supervisor) Failed() <-chan struct{} {
+	return g.failed
+}
+
+//Err is the error of the first goroutine that failed
+func (g *supervisor) Err() error {
+	select {
+	case <-g.failed:
+		return g.err
+	default:
+		return nil
+	}
+}
+
+//Cancel asks the goroutines to return, calls they are blocked in have to
+//be interrupted by the caller, such as by closing the connection
+func (g *supervisor) Cancel() {
+	g.cancel()
+}
+
+//Wait returns once every goroutine returned
+func (g *supervisor) Wait() {
+	g.wg.Wait()
+}
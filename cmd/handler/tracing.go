@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/tracing"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//sessionTrace holds the spans of a session: the session itself, the FSM
+//state it is in and the utterance being recognized. The FSM moves and the
+//audio is published from the reader and relay goroutines, so it is locked.
+type sessionTrace struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	session  trace.Span
+	state    trace.Span
+	recog    trace.Span
+	recogCtx context.Context
+	domain   string
+	//the first audio of the utterance went out
+	published bool
+}
+
+//startSessionTrace starts the session span, a child of the trace of the
+//upgrade request when the client sent one
+func startSessionTrace(r *http.Request, id, state string) *sessionTrace {
+	parent := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracing.Tracer().Start(parent, "session",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("session.id", id)))
+	t := &sessionTrace{ctx: ctx, session: span}
+	_, t.state = tracing.Tracer().Start(ctx, "fsm."+state)
+	return t
+}
+
+//traceID is the trace of the session, empty when it is not recorded
+func (t *sessionTrace) traceID() string {
+	sc := t.session.SpanContext()
+	if !sc.IsSampled() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+//bind returns parent carrying the session span
+func (t *sessionTrace) bind(parent context.Context) context.Context {
+	return trace.ContextWithSpan(parent, t.session)
+}
+
+//span starts a child of the session span
+func (t *sessionTrace) span(name string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(t.ctx, name)
+}
+
+//endSpan ends span, marking it failed when err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+//transition ends the span of the state left and starts the one entered
+func (t *sessionTrace) transition(src, dst string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.End()
+	_, t.state = tracing.Tracer().Start(t.ctx, "fsm."+dst,
+		trace.WithAttributes(attribute.String("fsm.from", src)))
+}
+
+//startRecognition starts the span of an utterance, it lasts until its final
+//result
+func (t *sessionTrace) startRecognition(domain string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.recog != nil {
+		t.recog.End()
+	}
+	t.domain = domain
+	t.nextRecognition()
+}
+
+//nextRecognition starts an utterance span, t.mu must be held
+func (t *sessionTrace) nextRecognition() {
+	t.recogCtx, t.recog = tracing.Tracer().Start(t.ctx, "recognition",
+		trace.WithAttributes(attribute.String("recognition.domain", t.domain)))
+	t.published = false
+}
+
+//stopRecognition ends the utterance span, if any
+func (t *sessionTrace) stopRecognition() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.recog != nil {
+		t.recog.End()
+		t.recog, t.recogCtx = nil, nil
+	}
+}
+
+//publish sends a frame of audio to the STT worker. The first frame of an
+//utterance gets a span of its own, every frame carries the trace context.
+func (t *sessionTrace) publish(nc *nats.Conn, subject, replyTo string, data []byte) error {
+	t.mu.Lock()
+	ctx := t.recogCtx
+	if ctx == nil {
+		ctx = t.ctx
+	}
+	first := t.recog != nil && !t.published
+	t.published = true
+	t.mu.Unlock()
+
+	if !first {
+		return nc.PublishMsg(tracing.NewMsg(ctx, nc, subject, replyTo, data))
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "stt.first_audio_published",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.Int("audio.bytes", len(data))))
+	err := nc.PublishMsg(tracing.NewMsg(ctx, nc, subject, replyTo, data))
+	endSpan(span, err)
+	return err
+}
+
+//resultReceived records a message of the STT worker, linked to the span of
+//the worker when it sent one. A final result ends the utterance and starts
+//the next.
+func (t *sessionTrace) resultReceived(msg *nats.Msg, result *entity.Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ctx := t.recogCtx
+	if ctx == nil {
+		ctx = t.ctx
+	}
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("stt.state", result.State),
+			attribute.Bool("stt.is_finish", result.IsFinish),
+			attribute.Int("stt.err_code", result.ErrCode),
+		),
+	}
+	if worker := trace.SpanContextFromContext(tracing.Extract(context.Background(), msg)); worker.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: worker}))
+	}
+	_, span := tracing.Tracer().Start(ctx, "stt.result_received", opts...)
+	if result.ErrCode != entity.ErrOK {
+		span.SetStatus(codes.Error, result.ErrMsg)
+	}
+	span.End()
+
+	if t.recog != nil && result.State == entity.StateResult && result.IsFinish {
+		t.recog.End()
+		t.nextRecognition()
+	}
+}
+
+//end closes every open span of the session
+func (t *sessionTrace) end(cause, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.recog != nil {
+		t.recog.End()
+	}
+	t.state.End()
+	t.session.SetAttributes(
+		attribute.String("session.close_cause", cause),
+		attribute.String("session.close_reason", reason),
+	)
+	if cause == CloseError {
+		t.session.SetStatus(codes.Error, reason)
+	}
+	t.session.End()
+}
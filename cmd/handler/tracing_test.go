@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//recordSpans installs a tracer provider keeping every span in memory until
+//the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	prev, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return rec
+}
+
+//ended returns the spans of trace id that ended, by name
+func ended(rec *tracetest.SpanRecorder, id trace.TraceID) map[string][]sdktrace.ReadOnlySpan {
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID() == id {
+			spans[s.Name()] = append(spans[s.Name()], s)
+		}
+	}
+	return spans
+}
+
+func attr(s sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestSessionTrace(t *testing.T) {
+	rec := recordSpans(t)
+	caller := "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("traceparent", "00-"+caller+"-00f067aa0ba902b7-01")
+	tr := startSessionTrace(r, "s1", "idle")
+	//the session joins the trace of the caller
+	if tr.traceID() != caller {
+		t.Fatalf("trace %q, want %q", tr.traceID(), caller)
+	}
+
+	tr.transition("idle", "streaming")
+	tr.startRecognition("banking")
+	workerMsg := &nats.Msg{Header: nats.Header{"traceparent": {"00-" + caller + "-b7ad6b7169203331-01"}}}
+	tr.resultReceived(workerMsg, &entity.Response{State: entity.StateResult})
+	tr.resultReceived(&nats.Msg{}, &entity.Response{State: entity.StateResult, IsFinish: true})
+	tr.resultReceived(&nats.Msg{}, &entity.Response{ErrCode: entity.ErrServerFails, ErrMsg: "worker failed"})
+	tr.end(CloseError, "worker failed")
+
+	id := tr.session.SpanContext().TraceID()
+	spans := ended(rec, id)
+	session := spans["session"]
+	if len(session) != 1 || session[0].Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("session spans %v", session)
+	}
+	if session[0].Status().Code != codes.Error || attr(session[0], "session.close_cause").AsString() != CloseError {
+		t.Fatalf("session ended with %+v, %v", session[0].Status(), session[0].Attributes())
+	}
+	if s := spans["fsm.streaming"]; len(s) != 1 || attr(s[0], "fsm.from").AsString() != "idle" || len(spans["fsm.idle"]) != 1 {
+		t.Fatalf("state spans %v", spans)
+	}
+	//the final result ends the utterance and the next one starts right away
+	recog := spans["recognition"]
+	if len(recog) != 2 || attr(recog[0], "recognition.domain").AsString() != "banking" {
+		t.Fatalf("recognition spans %v", recog)
+	}
+	results := spans["stt.result_received"]
+	if len(results) != 3 {
+		t.Fatalf("%d result spans", len(results))
+	}
+	if links := results[0].Links(); len(links) != 1 || links[0].SpanContext.SpanID().String() != "b7ad6b7169203331" {
+		t.Fatalf("result not linked to the worker span: %v", links)
+	}
+	if results[0].Parent().SpanID() != recog[0].SpanContext().SpanID() || results[2].Parent().SpanID() != recog[1].SpanContext().SpanID() {
+		t.Fatal("results are not children of their utterance")
+	}
+	if results[2].Status().Code != codes.Error {
+		t.Fatalf("failed result has status %+v", results[2].Status())
+	}
+	for _, s := range rec.Started() {
+		if s.SpanContext().TraceID() == id && s.EndTime().IsZero() {
+			t.Fatalf("span %s left open", s.Name())
+		}
+	}
+}
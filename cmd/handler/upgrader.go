@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+//defaultMaxMessageSize bounds frames when server_config sets no limit, far
+//above the few KB an audio chunk or action takes
+const defaultMaxMessageSize = 1 << 20
+
+//UpgraderConfig holds the WebSocket handshake and framing settings
+type UpgraderConfig struct {
+	//AllowedOrigins lists the scheme://host[:port] browsers may connect from,
+	//a leading *. in the host matches any subdomain. Empty or "*" allows all.
+	AllowedOrigins []string
+	//Subprotocols are offered in order of preference, e.g. bello.v1
+	Subprotocols    []string
+	ReadBufferSize  int
+	WriteBufferSize int
+	//Compression negotiates permessage-deflate with clients supporting it
+	Compression bool
+	//MaxMessageSize bounds a single message, a larger one closes the
+	//connection with 1009 (message too big)
+	MaxMessageSize int64
+}
+
+func newUpgrader(cfg UpgraderConfig) websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:    cfg.ReadBufferSize,
+		WriteBufferSize:   cfg.WriteBufferSize,
+		Subprotocols:      cfg.Subprotocols,
+		EnableCompression: cfg.Compression,
+		CheckOrigin:       checkOrigin(cfg.AllowedOrigins),
+	}
+}
+
+//checkOrigin matches the Origin header against the allow-list. Requests
+//without one come from non-browser clients and are accepted.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	for _, o := range allowed {
+		if o == "*" {
+			allowed = nil
+			break
+		}
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if len(allowed) == 0 || origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		for _, o := range allowed {
+			if originMatches(o, u) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func originMatches(pattern string, origin *url.URL) bool {
+	p, err := url.Parse(pattern)
+	if err != nil || !strings.EqualFold(p.Scheme, origin.Scheme) {
+		return false
+	}
+	if strings.HasPrefix(p.Host, "*.") {
+		return strings.HasSuffix(strings.ToLower(origin.Host), strings.ToLower(p.Host[1:]))
+	}
+	return strings.EqualFold(p.Host, origin.Host)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	allowed := []string{"https://app.example.com", "https://*.calls.example.com:8443"}
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no allow-list", nil, "https://evil.example.org", true},
+		{"wildcard", []string{"*"}, "https://evil.example.org", true},
+		{"no origin", allowed, "", true},
+		{"listed", allowed, "https://app.example.com", true},
+		{"host case", allowed, "https://APP.example.com", true},
+		{"other scheme", allowed, "http://app.example.com", false},
+		{"other host", allowed, "https://evil.example.org", false},
+		{"subdomain", allowed, "https://eu.calls.example.com:8443", true},
+		{"subdomain on another port", allowed, "https://eu.calls.example.com", false},
+		{"suffix without dot", allowed, "https://evilcalls.example.com:8443", false},
+		{"not a URL", allowed, "app.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := checkOrigin(tt.allowed)(r); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgraderLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := runNATS(t)
+	defer ns.Shutdown()
+	worker := runWorker(t, ns.ClientURL(), 1)
+	defer worker.Close()
+	pool, err := stream.NewPool(ns.ClientURL(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	h := NewStreamHandler(pool, stream.NewManager("stt", 1, log), log, WithUpgrader(UpgraderConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		Subprotocols:   []string{"bello.v2", "bello.v1"},
+		MaxMessageSize: 1024,
+	}))
+	r := gin.New()
+	r.GET("/", h.Flow)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	//a browser page of another site is refused before the upgrade
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example.org"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("foreign origin got %v, %v", resp, err)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{"bello.v1", "bello.v2"}}
+	ws, _, err := dialer.Dial(url, http.Header{"Origin": {"https://app.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	//the server preference wins over the order the client offers
+	if ws.Subprotocol() != "bello.v2" {
+		t.Fatalf("negotiated %q", ws.Subprotocol())
+	}
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	greeting := entity.Response{}
+	if err := ws.ReadJSON(&greeting); err != nil || greeting.SessionID == "" {
+		t.Fatalf("no greeting: %+v, %v", greeting, err)
+	}
+
+	if err := ws.WriteMessage(websocket.BinaryMessage, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, make([]byte, 1025)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, _, err := ws.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+			t.Fatalf("closed with %v, want message too big", err)
+		}
+		break
+	}
+}
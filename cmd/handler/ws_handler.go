@@ -3,12 +3,28 @@ package handler
 import (
 	"context"
 	"encoding/binary"
+	"errors"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/4406arthur/bello/pkg/audio"
+	"github.com/4406arthur/bello/pkg/audit"
+	"github.com/4406arthur/bello/pkg/auth"
+	"github.com/4406arthur/bello/pkg/callflow"
+	"github.com/4406arthur/bello/pkg/dtmf"
 	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/grammar"
+	"github.com/4406arthur/bello/pkg/metrics"
+	"github.com/4406arthur/bello/pkg/quota"
+	"github.com/4406arthur/bello/pkg/recording"
 	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/pkg/tracing"
 	"github.com/4406arthur/bello/utils/logger"
+	"github.com/4406arthur/bello/utils/rand"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/looplab/fsm"
@@ -18,33 +34,448 @@ import (
 
 //StreamHandler ...
 type StreamHandler struct {
-	pool    *stream.Pool
-	manager *stream.Manager
-	logger  logger.Logger
+	pool       *stream.Pool
+	manager    *stream.Manager
+	flows      *callflow.Service
+	grammars   *grammar.Service
+	ttsSubject string
+	//handshake settings, frames above maxMessageSize close the connection
+	upgrader       websocket.Upgrader
+	maxMessageSize int64
+	//nil when clients are not authenticated
+	auth auth.Authenticator
+	//nil when client certificates authenticate no one
+	clientCerts *auth.ClientCerts
+	//holds Timeouts, replaced while sessions run
+	timeouts atomic.Value
+	//nil when clients are not limited
+	quotas   *quota.Limiter
+	sessions *SessionRegistry
+	//nil when sessions are not audited
+	audit *audit.Log
+	//trails counts the sessions whose recording and audit record are still
+	//being stored, stopTrails aborts their uploads
+	trails     sync.WaitGroup
+	trailCtx   context.Context
+	stopTrails context.CancelFunc
+	//nil when sessions are not recorded, recordOnRequest limits recording
+	//to start actions asking for it
+	recorder        *recording.Recorder
+	recordOnRequest bool
+	//energy detector settings used to spot callers talking over a prompt
+	speechThreshold float64
+	speechFrames    int
+	//nil when sessions log at the logger level only
+	debugRules *logger.DebugRules
+	//how much a session queues while NATS or the client falls behind
+	backpressure Backpressure
+	logger       logger.Logger
 }
 
-var upGrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+//StreamOption configures optional StreamHandler features
+type StreamOption func(*StreamHandler)
+
+//WithCallFlows lets start actions pick a call flow by domain
+func WithCallFlows(svc *callflow.Service) StreamOption {
+	return func(s *StreamHandler) {
+		s.flows = svc
+	}
+}
+
+//WithTTSSubject sets the subject TTS workers listen on
+func WithTTSSubject(subject string) StreamOption {
+	return func(s *StreamHandler) {
+		if subject != "" {
+			s.ttsSubject = subject
+		}
+	}
+}
+
+//WithGrammars lets start actions reference stored grammars
+func WithGrammars(svc *grammar.Service) StreamOption {
+	return func(s *StreamHandler) {
+		s.grammars = svc
+	}
+}
+
+//WithAuth requires clients to authenticate, either with a bearer credential
+//on the upgrade request or with the token of their first start action
+func WithAuth(a auth.Authenticator) StreamOption {
+	return func(s *StreamHandler) {
+		s.auth = a
+	}
+}
+
+//WithClientCerts lets clients presenting an allowed certificate in without
+//a token, it only applies along with WithAuth
+func WithClientCerts(c *auth.ClientCerts) StreamOption {
+	return func(s *StreamHandler) {
+		s.clientCerts = c
+	}
+}
+
+//Timeouts bound how long a session waits, zero values keep the defaults
+type Timeouts struct {
+	//Auth is the wait for the first start action of an unauthenticated
+	//connection
+	Auth time.Duration
+	//Idle closes sessions without traffic from the client or the workers
+	Idle time.Duration
+	//SpeakChunk gives up on a prompt when the TTS worker stalls
+	SpeakChunk time.Duration
+	//Setup is the wait for an STT worker to acknowledge a start
+	Setup time.Duration
+	//the client is pinged every Ping and closed when neither a pong nor a
+	//frame comes within Ping+Pong, or when a frame to it waits longer than
+	//Write
+	Ping  time.Duration
+	Pong  time.Duration
+	Write time.Duration
+}
+
+var defaultTimeouts = Timeouts{
+	Auth:       10 * time.Second,
+	Idle:       60 * time.Second,
+	SpeakChunk: 5 * time.Second,
+	Setup:      5 * time.Second,
+	Ping:       20 * time.Second,
+	Pong:       10 * time.Second,
+	Write:      10 * time.Second,
+}
+
+//WithTimeouts ...
+func WithTimeouts(t Timeouts) StreamOption {
+	return func(s *StreamHandler) {
+		s.SetTimeouts(t)
+	}
+}
+
+//SetTimeouts changes the timeouts while sessions run, waits already started
+//keep the old value
+func (s *StreamHandler) SetTimeouts(t Timeouts) {
+	if t.Auth <= 0 {
+		t.Auth = defaultTimeouts.Auth
+	}
+	if t.Idle <= 0 {
+		t.Idle = defaultTimeouts.Idle
+	}
+	if t.SpeakChunk <= 0 {
+		t.SpeakChunk = defaultTimeouts.SpeakChunk
+	}
+	if t.Setup <= 0 {
+		t.Setup = defaultTimeouts.Setup
+	}
+	if t.Ping <= 0 {
+		t.Ping = defaultTimeouts.Ping
+	}
+	if t.Pong <= 0 {
+		t.Pong = defaultTimeouts.Pong
+	}
+	if t.Write <= 0 {
+		t.Write = defaultTimeouts.Write
+	}
+	s.timeouts.Store(t)
+}
+
+//Timeouts returns the timeouts in effect
+func (s *StreamHandler) Timeouts() Timeouts {
+	return s.timeouts.Load().(Timeouts)
+}
+
+//WithUpgrader replaces the default handshake settings, which accept any
+//origin and no subprotocol
+func WithUpgrader(cfg UpgraderConfig) StreamOption {
+	return func(s *StreamHandler) {
+		s.upgrader = newUpgrader(cfg)
+		s.maxMessageSize = cfg.MaxMessageSize
+		if s.maxMessageSize <= 0 {
+			s.maxMessageSize = defaultMaxMessageSize
+		}
+	}
+}
+
+//WithQuotas limits sessions and audio per client
+func WithQuotas(l *quota.Limiter) StreamOption {
+	return func(s *StreamHandler) {
+		s.quotas = l
+	}
+}
+
+//WithAudit writes an audit record of every session to log
+func WithAudit(log *audit.Log) StreamOption {
+	return func(s *StreamHandler) {
+		s.audit = log
+	}
+}
+
+//WithRecording records the caller audio of sessions, all of them or only
+//those whose start action sets record
+func WithRecording(r *recording.Recorder, onRequest bool) StreamOption {
+	return func(s *StreamHandler) {
+		s.recorder = r
+		s.recordOnRequest = onRequest
+	}
+}
+
+//WithBargeIn tunes the speech detector used for barge-in
+func WithBargeIn(threshold float64, frames int) StreamOption {
+	return func(s *StreamHandler) {
+		s.speechThreshold = threshold
+		s.speechFrames = frames
+	}
+}
+
+//WithDebugRules writes the debug lines of the sessions matching rules
+//whatever the logger level
+func WithDebugRules(rules *logger.DebugRules) StreamOption {
+	return func(s *StreamHandler) {
+		s.debugRules = rules
+	}
+}
+
+//session is the per connection state owned by the Flow loop
+type session struct {
+	id        string
+	ws        *websocket.Conn
+	principal *auth.Principal
+	live      *liveSession
+	trail     *audit.Trail
+	trace     *sessionTrace
+	log       *logger.FieldLogger
+	recording *recording.Recording
+	nc        *nats.Conn
+	//how long a frame to the client may wait
+	writeTimeout time.Duration
+	subject      string
+	replyTo      string
+	runner       *callflow.Runner
+	noInput      <-chan time.Time
+	player       *speechPlayer
+	//a transfer or hangup was reached, close once prompts are played
+	closing bool
+	//keypad input, the grammar of the start action applies unless the
+	//current listen node brings its own
+	dtmfGrammar *entity.DTMFGrammar
+	dtmf        *dtmf.Collector
+	dtmfTimeout <-chan time.Time
+	//why the session ended, a fixed cause for metrics and a reason for the
+	//audit record
+	closeCause  string
+	closeReason string
+	//recognition latency, measured from the accepted start or the previous
+	//final result
+	recogStart time.Time
+	gotResult  bool
+}
+
+// Session close causes
+const (
+	CloseClient        = "client"
+	CloseOperator      = "operator"
+	CloseIdleTimeout   = "idle_timeout"
+	CloseRequestDone   = "request_done"
+	CloseCallFlow      = "call_flow"
+	CloseCallFlowError = "call_flow_error"
+	CloseQuota         = "quota"
+	CloseShutdown      = "shutdown"
+	CloseUnresponsive  = "unresponsive"
+	CloseAudioOverflow = "audio_overflow"
+	CloseSlowClient    = "slow_client"
+	CloseError         = "error"
+)
+
+//close records why the session ends, the first cause recorded stands
+func (sess *session) close(cause, reason string) {
+	if sess.closeCause != "" {
+		return
+	}
+	sess.closeCause = cause
+	sess.closeReason = reason
+}
+
+//errorCause is the close cause of a session ended by err
+func errorCause(err error) string {
+	if _, ok := err.(*websocket.CloseError); ok {
+		return CloseClient
+	}
+	switch err {
+	case quota.ErrAudio:
+		return CloseQuota
+	case errAudioOverflow:
+		return CloseAudioOverflow
+	case errSlowClient:
+		return CloseSlowClient
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return CloseUnresponsive
+	}
+	return CloseError
+}
+
+//startAck answers a start action, audio flows once it is accepted
+type startAck struct {
+	accepted bool
+	//receives a copy of the caller audio, nil when not recording
+	recording *recording.Recording
+}
+
+//reply sends a response to the client and keeps it in the audit trail
+func (sess *session) reply(resp entity.Response) error {
+	sess.trail.Response(&resp)
+	return writeJSON(sess.ws, resp, sess.writeTimeout)
+}
+
+//flowInput hands caller input to the call flow and keeps the intents it was
+//matched to in the audit trail
+func (sess *session) flowInput(input string) ([]callflow.Step, error) {
+	steps, err := sess.runner.Input(input)
+	for _, m := range sess.runner.Matches() {
+		sess.trail.Intent(input, m.NodeID, m.Intent)
+	}
+	return steps, err
 }
 
 //NewStreamHandler ...
-func NewStreamHandler(p *stream.Pool, m *stream.Manager, log logger.Logger) *StreamHandler {
-	return &StreamHandler{
-		pool:    p,
-		manager: m,
-		logger:  log,
+func NewStreamHandler(p *stream.Pool, m *stream.Manager, log logger.Logger, opts ...StreamOption) *StreamHandler {
+	s := &StreamHandler{
+		pool:       p,
+		manager:    m,
+		ttsSubject: "tts",
+		sessions:   NewSessionRegistry(),
+		logger:     log,
+	}
+	s.trailCtx, s.stopTrails = context.WithCancel(context.Background())
+	s.SetTimeouts(defaultTimeouts)
+	WithUpgrader(UpgraderConfig{})(s)
+	WithBackpressure(Backpressure{})(s)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+//Sessions returns the live sessions of this handler
+func (s *StreamHandler) Sessions() *SessionRegistry {
+	return s.sessions
+}
+
+//DrainTrails waits until the recordings and audit records of the ended
+//sessions are stored. When ctx is done first the uploads still running are
+//aborted, the audit records are written all the same.
+func (s *StreamHandler) DrainTrails(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.trails.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.stopTrails()
+		<-done
+		return ctx.Err()
 	}
 }
 
 //Flow ...
 func (s *StreamHandler) Flow(ctx *gin.Context) {
 
-	ws, err := upGrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	//a credential in the upgrade request is checked before any resource is
+	//spent, otherwise the first start action has to carry a token
+	var principal *auth.Principal
+	var lease *quota.Lease
+	defer func() {
+		lease.Release()
+	}()
+	//browsers cannot set headers on an upgrade, the query token is dropped
+	//from the request whether or not it is needed
+	token := auth.BearerToken(ctx.Request)
+	if query := auth.QueryToken(ctx.Request); token == "" {
+		token = query
+	}
+	if s.auth != nil {
+		//gateways with an allowed client certificate need no token, others
+		//may still present one
+		p, err := s.clientCerts.Authenticate(ctx.Request)
+		if err != nil {
+			metrics.AuthFailures.WithLabelValues(auth.MethodMTLS, auth.ReasonUnknown).Inc()
+			s.logger.Info("N", logger.Trace(), err.Error())
+		}
+		if p != nil {
+			metrics.AuthSuccesses.WithLabelValues(p.Method).Inc()
+			principal = p
+		} else if token != "" {
+			p, err := s.authenticate(token)
+			if err != nil {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, entity.Response{
+					ErrCode: entity.ErrCanNotUse,
+					ErrMsg:  "unauthorized",
+				})
+				return
+			}
+			principal = p
+		}
+	}
+	//anonymous or already authenticated clients are limited before the
+	//upgrade, the others once their start action told who they are
+	if s.quotas != nil && (s.auth == nil || principal != nil) {
+		l, err := s.acquire(ctx, principal)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, entity.Response{
+				ErrCode: entity.ErrCanNotUse,
+				ErrMsg:  err.Error(),
+			})
+			return
+		}
+		lease = l
+	}
+
+	ws, err := s.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
 		return
 	}
+	ws.SetReadLimit(s.maxMessageSize)
+
+	var firstAction []byte
+	if s.auth != nil && principal == nil {
+		firstAction, principal, err = s.authenticateStart(ws)
+		if err != nil {
+			writeJSON(ws, entity.Response{
+				ErrCode: entity.ErrCanNotUse,
+				ErrMsg:  "unauthorized",
+			}, s.Timeouts().Write)
+			ws.Close()
+			return
+		}
+		if s.quotas != nil {
+			l, err := s.acquire(ctx, principal)
+			if err != nil {
+				writeJSON(ws, entity.Response{
+					ErrCode: entity.ErrCanNotUse,
+					ErrMsg:  err.Error(),
+				}, s.Timeouts().Write)
+				ws.Close()
+				return
+			}
+			lease = l
+		}
+	}
+
+	sessionID, _ := rand.GenerateRandomString(16)
+	client := ""
+	if principal != nil {
+		client = principal.Subject
+	}
+	trail := audit.NewTrail(sessionID, client, ctx.ClientIP())
+	tr := startSessionTrace(ctx.Request, sessionID, "open")
+	//every line logged for the session carries who and where it is
+	log := logger.With(s.logger, logger.Fields{
+		"session":  sessionID,
+		"client":   client,
+		"clientIP": ctx.ClientIP(),
+		"trace":    tr.traceID(),
+	}).DebugWhen(s.debugRules)
 
 	//define mrcp websocket fsm
 	FSM := fsm.NewFSM(
@@ -55,179 +486,805 @@ func (s *StreamHandler) Flow(ctx *gin.Context) {
 		},
 		fsm.Callbacks{
 			"enter_state": func(e *fsm.Event) {
-				s.logger.Info("N", logger.Trace(), "enter: "+e.Dst+" from: "+e.Src)
+				log.Info("N", logger.Trace(), "enter: "+e.Dst+" from: "+e.Src)
+				trail.Transition(e.Src, e.Dst)
+				tr.transition(e.Src, e.Dst)
 			},
 		},
 	)
 
+	_, checkout := tr.span("stream.checkout")
 	subName, err := s.manager.Checkout()
 	if err != nil {
-		ctx.AbortWithStatus(429)
+		endSpan(checkout, err)
+		tr.end(CloseError, err.Error())
+		//the connection is upgraded already, tell the client over it
+		writeJSON(ws, entity.Response{
+			ErrCode: entity.ErrCanNotUse,
+			ErrMsg:  err.Error(),
+		}, s.Timeouts().Write)
+		ws.Close()
+		return
+	}
+	log.Set("subject", subName)
+	nc, err := s.pool.Get()
+	endSpan(checkout, err)
+	if err != nil {
+		log.Error("N", logger.Trace(), "cannot get a NATS connection: "+err.Error())
+		s.manager.Checkin(subName)
+		tr.end(CloseError, err.Error())
+		writeJSON(ws, entity.Response{
+			ErrCode: entity.ErrServerFails,
+			ErrMsg:  "cannot reach STT",
+		}, s.Timeouts().Write)
+		ws.Close()
 		return
 	}
-	nc, _ := s.pool.Get()
+	metrics.SessionsActive.Inc()
+	audioIn := newAudioQueue(s.backpressure)
+	streamIn := audioIn.frames
+	streamOut := make(chan *nats.Msg, s.backpressure.ResultBuffer)
+	live := s.sessions.add(SessionInfo{
+		ID:        sessionID,
+		Client:    client,
+		ClientIP:  ctx.ClientIP(),
+		Subject:   subName,
+		StartedAt: time.Now().UTC(),
+	}, func() map[string]int {
+		return map[string]int{QueueAudioIn: len(streamIn), QueueResultsOut: len(streamOut)}
+	})
 	// Create a unique subject name for replies.
 	uniqueReplyTo := nats.NewInbox()
 	// Listen for response
 	mailbox, err := nc.SubscribeSync(uniqueReplyTo)
 	if err != nil {
-		s.logger.Error("N", logger.Trace(), err.Error())
+		log.Error("N", logger.Trace(), err.Error())
 	}
-	c, cancel := context.WithCancel(ctx)
-	streamIn := make(chan []byte, 30)
-	streamOut := make(chan []byte, 30)
-	errChan := make(chan error, 3)
+	group := newSupervisor(tr.bind(ctx))
+	c := group.Context()
+	actionChan := make(chan entity.Action, 3)
+	speechChan := make(chan struct{}, 1)
+	startChan := make(chan startAck, 1)
 
-	//resource release
+	sess := &session{
+		id:           sessionID,
+		ws:           ws,
+		principal:    principal,
+		live:         live,
+		trail:        trail,
+		trace:        tr,
+		log:          log,
+		nc:           nc,
+		writeTimeout: s.Timeouts().Write,
+		subject:      subName,
+		replyTo:      uniqueReplyTo,
+		player:       newSpeechPlayer(c, nc, s.ttsSubject, s.Timeouts().SpeakChunk),
+		dtmf:         dtmf.NewCollector(nil),
+	}
+	//teardown runs in order: the goroutines are stopped, unblocking the
+	//calls they may wait in, before anything they use is released. None of
+	//the channels is closed, the goroutines are gone once Wait returns.
 	defer func() {
-		// time.Sleep(100 * time.Millisecond)
-		cancel()
+		group.Cancel()
 		ws.Close()
+		mailbox.Unsubscribe()
+		group.Wait()
+		s.writeTrail(sess)
+		s.sessions.remove(sessionID)
+		metrics.SessionsActive.Dec()
 		s.pool.Put(nc)
 		s.manager.Checkin(subName)
-		mailbox.Unsubscribe()
-		close(streamIn)
-		close(streamOut)
-		close(errChan)
 	}()
 
-	//send a listening cmd to MRCP
+	//send a listening cmd to MRCP, the first one tells the client the id to
+	//quote when asking about the session
 	ListenAction := entity.Response{
 		ErrCode: 0,
 		State:   "listening",
 	}
-	ws.WriteJSON(ListenAction)
+	greeting := ListenAction
+	greeting.SessionID = sessionID
+	sess.reply(greeting)
 
-	go s.streamFromWS(c, FSM, ws, streamIn, errChan)
-	go s.streamRelay(c, nc, streamIn, subName, uniqueReplyTo, errChan)
-	go s.streamFromMailbox(c, mailbox, streamOut, errChan)
+	s.keepalive(ws)
+	group.Go(func(ctx context.Context) error {
+		return s.ping(ctx, ws)
+	})
+	group.Go(func(ctx context.Context) error {
+		return s.streamFromWS(ctx, log, FSM, ws, firstAction, lease, audioIn, actionChan, startChan, speechChan)
+	})
+	group.Go(func(ctx context.Context) error {
+		return s.streamRelay(ctx, log, nc, tr, streamIn, subName, uniqueReplyTo)
+	})
+	group.Go(func(ctx context.Context) error {
+		return s.streamFromMailbox(ctx, log, mailbox, streamOut)
+	})
 
 	for {
 		select {
-		case messageFromSTT := <-streamOut:
-			s.logger.Debug("N", logger.Trace(), "get message form STT: "+string(messageFromSTT))
-			//TODO NLU logic
-			ws.WriteMessage(1, messageFromSTT)
-		case err := <-errChan:
-			s.logger.Error("N", logger.Trace(), "catch error"+err.Error())
+		case msg := <-streamOut:
+			messageFromSTT := msg.Data
+			log.Debug("N", logger.Trace(), "get message form STT: "+string(messageFromSTT))
+			result := entity.Response{}
+			if err := ffjson.Unmarshal(messageFromSTT, &result); err != nil {
+				log.Error("N", logger.Trace(), "cannot decode STT message: "+err.Error())
+			}
+			trail.Result(&result)
+			tr.resultReceived(msg, &result)
+			s.observeResult(sess, &result)
+			//a partial or final result proves the caller is talking
+			if result.State == entity.StateResult && s.bargeIn(sess, "result") {
+				return
+			}
+			if err := writeMessage(ws, websocket.TextMessage, messageFromSTT, sess.writeTimeout); err != nil {
+				s.unresponsive(sess, err)
+				return
+			}
+			if sess.runner == nil || !sess.runner.Waiting() {
+				break
+			}
+			if result.State != entity.StateResult || !result.IsFinish {
+				break
+			}
+			steps, err := sess.flowInput(result.RecogResult)
+			if s.playSteps(sess, steps, err) {
+				return
+			}
+		case <-sess.noInput:
+			steps, err := sess.runner.NoInput()
+			if s.playSteps(sess, steps, err) {
+				return
+			}
+		case <-sess.dtmfTimeout:
+			if s.dtmfDone(sess, sess.dtmf.Timeout()) {
+				return
+			}
+		case <-speechChan:
+			if s.bargeIn(sess, "speech") {
+				return
+			}
+		case chunk := <-sess.player.Chunks():
+			if !chunk.end {
+				if err := writeMessage(ws, websocket.BinaryMessage, chunk.audio, sess.writeTimeout); err != nil {
+					s.unresponsive(sess, err)
+					return
+				}
+				countAudio("out", chunk.audio, sess.player.Current().SampleRate)
+				break
+			}
+			s.speakFinished(sess, chunk.err)
+			if sess.closing && sess.player.Current() == nil {
+				return
+			}
+		case action := <-actionChan:
+			switch action.Action {
+			case entity.ActionStart:
+				accepted := s.setupRecognition(sess, &action)
+				if accepted {
+					log.Set(logger.FieldUID, action.UID)
+					log.Set(logger.FieldPlatform, action.Platform)
+					s.startRecording(sess, &action)
+					tr.startRecognition(action.Domain)
+				}
+				startChan <- startAck{accepted: accepted, recording: sess.recording}
+				if !accepted {
+					break
+				}
+				sess.live.started(&action)
+				sess.recogStart, sess.gotResult = time.Now(), false
+				trail.Started(&action)
+				sess.dtmfGrammar = action.DTMF
+				sess.dtmf = dtmf.NewCollector(action.DTMF)
+				sess.runner = s.startCallFlow(sess, action.Domain)
+				if sess.runner == nil {
+					break
+				}
+				steps, err := sess.runner.Start()
+				if s.playSteps(sess, steps, err) {
+					return
+				}
+			case entity.ActionStop:
+				tr.stopRecognition()
+				sess.runner, sess.noInput = nil, nil
+				sess.dtmf, sess.dtmfTimeout = dtmf.NewCollector(sess.dtmfGrammar), nil
+				//TODO intersting flow here, stop should be
+				//a final status
+				sess.reply(ListenAction)
+			case entity.ActionSpeak:
+				if action.Text == "" {
+					sess.reply(entity.Response{
+						ErrCode: entity.ErrParamInvalid,
+						ErrMsg:  "speak requires text",
+					})
+					break
+				}
+				sess.player.Enqueue(&entity.SpeakRequest{
+					Text:       action.Text,
+					Voice:      action.Voice,
+					SampleRate: action.SampleRate,
+					BargeIn:    action.BargeIn,
+				})
+			case entity.ActionDTMF:
+				if s.keyPressed(sess, &action) {
+					return
+				}
+			}
+		case req := <-live.kill:
+			sess.close(req.cause, req.reason)
+			sess.reply(entity.Response{
+				ErrCode: entity.ErrCanNotUse,
+				ErrMsg:  req.message,
+			})
+			return
+		case <-group.Failed():
+			err := group.Err()
+			log.Error("N", logger.Trace(), "catch error"+err.Error())
+			trail.Error(err.Error())
+			sess.close(errorCause(err), err.Error())
+			if err == quota.ErrAudio || err == errAudioOverflow {
+				sess.reply(entity.Response{
+					ErrCode: entity.ErrCanNotUse,
+					ErrMsg:  err.Error(),
+				})
+			}
 			return
 		case <-ctx.Done():
-			s.logger.Error("N", logger.Trace(), "catch client request done event")
+			log.Error("N", logger.Trace(), "catch client request done event")
+			sess.close(CloseRequestDone, "request done")
 			return
-		case <-time.After(60 * time.Second):
-			s.logger.Error("N", logger.Trace(), "timeout")
+		case <-time.After(s.Timeouts().Idle):
+			log.Error("N", logger.Trace(), "timeout")
+			sess.close(CloseIdleTimeout, "idle timeout")
 			return
 		}
 	}
 }
 
-func (s *StreamHandler) streamFromWS(ctx context.Context, FSM *fsm.FSM, ws *websocket.Conn, ch chan<- []byte, errCh chan error) {
-	action := &entity.Action{}
-	//listening cmd
-	ListenAction := entity.Response{
-		ErrCode: 0,
-		State:   "listening",
+//acquire admits a session under the limits of its client
+func (s *StreamHandler) acquire(ctx *gin.Context, principal *auth.Principal) (*quota.Lease, error) {
+	subject := ""
+	if principal != nil {
+		subject = principal.Subject
+	}
+	key := quota.Key(subject, ctx.ClientIP())
+	lease, err := s.quotas.Acquire(key)
+	if err != nil {
+		metrics.QuotaRejections.WithLabelValues(quotaReason(err)).Inc()
+		s.logger.Info("N", logger.Trace(), key+": "+err.Error())
+		return nil, err
+	}
+	return lease, nil
+}
+
+func quotaReason(err error) string {
+	switch err {
+	case quota.ErrSessionRate:
+		return "session_rate"
+	case quota.ErrConcurrent:
+		return "concurrent"
+	case quota.ErrAudio:
+		return "audio"
+	}
+	return "unknown"
+}
+
+//authenticate checks a credential and records the outcome in metrics
+func (s *StreamHandler) authenticate(token string) (*auth.Principal, error) {
+	p, err := s.auth.Authenticate(token)
+	if err != nil {
+		method, reason := "", auth.ReasonMalformed
+		if aerr, ok := err.(*auth.Error); ok {
+			method, reason = aerr.Method, aerr.Reason
+		}
+		metrics.AuthFailures.WithLabelValues(method, reason).Inc()
+		s.logger.Info("N", logger.Trace(), err.Error())
+		return nil, err
 	}
+	metrics.AuthSuccesses.WithLabelValues(p.Method).Inc()
+	s.logger.Debug("N", logger.Trace(), "authenticated "+p.Subject+" by "+p.Method)
+	return p, nil
+}
+
+//authenticateStart waits for the first start action and checks its token.
+//The raw action is returned so the session can process it afterwards.
+func (s *StreamHandler) authenticateStart(ws *websocket.Conn) ([]byte, *auth.Principal, error) {
+	ws.SetReadDeadline(time.Now().Add(s.Timeouts().Auth))
+	defer ws.SetReadDeadline(time.Time{})
+	for {
+		msgType, msg, err := ws.ReadMessage()
+		if err != nil {
+			metrics.AuthFailures.WithLabelValues("", auth.ReasonMissing).Inc()
+			return nil, nil, err
+		}
+		//audio before start is dropped anyway
+		if msgType != websocket.TextMessage {
+			continue
+		}
+		action := entity.Action{}
+		if err := ffjson.Unmarshal(msg, &action); err != nil || action.Action != entity.ActionStart {
+			metrics.AuthFailures.WithLabelValues("", auth.ReasonMissing).Inc()
+			return nil, nil, errors.New("first action must be an authenticated start")
+		}
+		p, err := s.authenticate(action.Token)
+		return msg, p, err
+	}
+}
+
+//setupRecognition validates a start action and sends the recognition setup,
+//including resolved grammars, to the STT worker. The start is only accepted
+//once the worker acknowledged the setup, so no audio can overtake it.
+func (s *StreamHandler) setupRecognition(sess *session, action *entity.Action) bool {
+	reject := func(code int, msg string) bool {
+		sess.reply(entity.Response{
+			ErrCode: code,
+			ErrMsg:  msg,
+		})
+		return false
+	}
+	if err := dtmf.ValidateGrammar(action.DTMF); err != nil {
+		return reject(entity.ErrParamInvalid, err.Error())
+	}
+
+	setup := entity.RecognizeSetup{
+		Domain:         action.Domain,
+		Platform:       action.Platform,
+		UID:            action.UID,
+		IsGetPartial:   action.IsGetPartial,
+		IsGetWordInfo:  action.IsGetWordInfo,
+		NBestNum:       action.NBestNum,
+		IsDoEPD:        action.IsDoEPD,
+		RejectionLevel: action.RejectionLevel,
+		SampleRate:     action.SampleRate,
+	}
+	if len(action.Grammars) > 0 {
+		if s.grammars == nil {
+			return reject(entity.ErrParamInvalid, "grammars are not supported")
+		}
+		grammars, err := s.grammars.Resolve(action.Grammars)
+		if _, ok := err.(*grammar.ValidationError); ok {
+			return reject(entity.ErrParamInvalid, err.Error())
+		}
+		if err != nil {
+			sess.log.Error("N", logger.Trace(), "cannot resolve grammars: "+err.Error())
+			return reject(entity.ErrServerFails, "cannot load grammars")
+		}
+		setup.Grammars = grammars
+	}
+
+	ctx, span := sess.trace.span("stt.setup")
+	payload, err := ffjson.Marshal(&setup)
+	if err == nil {
+		_, err = sess.nc.RequestMsg(tracing.NewMsg(ctx, sess.nc, sess.subject+".setup", "", payload), s.Timeouts().Setup)
+	}
+	endSpan(span, err)
+	if err != nil {
+		sess.log.Error("N", logger.Trace(), "recognition setup not acknowledged: "+err.Error())
+		return reject(entity.ErrServerFails, "cannot reach STT")
+	}
+	return true
+}
+
+//startCallFlow returns a runner for the active flow of domain, or nil when
+//the session should keep the plain recognition behaviour
+func (s *StreamHandler) startCallFlow(sess *session, domain string) *callflow.Runner {
+	if s.flows == nil || domain == "" {
+		return nil
+	}
+	f, err := s.flows.Active(domain)
+	if err != nil {
+		if err != callflow.ErrNotFound {
+			sess.log.Error("N", logger.Trace(), "cannot load call flow for "+domain+": "+err.Error())
+		}
+		return nil
+	}
+	sess.log.Info("N", logger.Trace(), "run call flow "+f.Domain)
+	return callflow.NewRunner(f)
+}
+
+//playSteps reports the call flow steps to the client, queues prompts for
+//playback and arms the listen timeout. It returns true once the session has
+//to be closed right away.
+func (s *StreamHandler) playSteps(sess *session, steps []callflow.Step, err error) bool {
+	sess.noInput = nil
+	for _, step := range steps {
+		resp := entity.Response{
+			ErrCode: entity.ErrOK,
+			Node:    step.NodeID,
+		}
+		switch step.Type {
+		case callflow.NodePrompt:
+			resp.State = entity.StatePrompt
+			resp.Text = step.Text
+			sess.player.Enqueue(&entity.SpeakRequest{Text: step.Text, Node: step.NodeID, BargeIn: step.BargeIn})
+		case callflow.NodeListen:
+			resp.State = entity.StateListening
+			if step.Timeout > 0 {
+				sess.noInput = time.After(step.Timeout)
+			}
+			grammar := step.DTMF
+			if grammar == nil {
+				grammar = sess.dtmfGrammar
+			}
+			sess.dtmf, sess.dtmfTimeout = dtmf.NewCollector(grammar), nil
+		case callflow.NodeTransfer:
+			resp.State = entity.StateTransfer
+			resp.Target = step.Target
+			resp.Intent = step.Intent
+		case callflow.NodeHangup:
+			resp.State = entity.StateHangup
+			resp.Text = step.Text
+			if step.Text != "" {
+				sess.player.Enqueue(&entity.SpeakRequest{Text: step.Text, Node: step.NodeID})
+			}
+		}
+		sess.reply(resp)
+		if step.Type == callflow.NodeTransfer || step.Type == callflow.NodeHangup {
+			//let queued prompts finish before the connection is dropped
+			sess.closing = true
+			sess.close(CloseCallFlow, "call flow "+step.Type)
+			return sess.player.Current() == nil
+		}
+	}
+	if err != nil {
+		sess.log.Error("N", logger.Trace(), err.Error())
+		sess.reply(entity.Response{
+			ErrCode: entity.ErrServerFails,
+			ErrMsg:  err.Error(),
+		})
+		sess.close(CloseCallFlowError, "call flow error: "+err.Error())
+		return true
+	}
+	return false
+}
+
+//startRecording opens the recording of a session on its first accepted
+//start action, a failure leaves the session unrecorded
+func (s *StreamHandler) startRecording(sess *session, action *entity.Action) {
+	if s.recorder == nil || sess.recording != nil || (s.recordOnRequest && !action.Record) {
+		return
+	}
+	rec, err := s.recorder.Start(sess.id, action.SampleRate)
+	if err != nil {
+		metrics.RecordingFailures.Inc()
+		sess.log.Error("N", logger.Trace(), "cannot start recording: "+err.Error())
+		sess.trail.Error("recording failed: " + err.Error())
+		return
+	}
+	sess.recording = rec
+}
+
+//writeTrail counts a finished session, ends its trace, stores its recording
+//and appends its audit record to the audit log. Uploads can be slow so the
+//last two run in the background, DrainTrails waits for them.
+func (s *StreamHandler) writeTrail(sess *session) {
+	reason := sess.closeReason
+	if reason == "" {
+		reason = "unknown"
+	}
+	cause := sess.closeCause
+	if cause == "" {
+		cause = CloseError
+	}
+	metrics.SessionsClosed.WithLabelValues(cause).Inc()
+	sess.trace.end(cause, reason)
+	s.trails.Add(1)
+	go func() {
+		defer s.trails.Done()
+		if sess.recording != nil {
+			uri, err := sess.recording.Finish(s.trailCtx)
+			if err != nil {
+				metrics.RecordingFailures.Inc()
+				sess.log.Error("N", logger.Trace(), "cannot store recording: "+err.Error())
+				sess.trail.Error("recording failed: " + err.Error())
+			} else {
+				sess.trail.Recorded(uri)
+			}
+		}
+		if s.audit == nil {
+			return
+		}
+		if err := s.audit.Write(sess.trail.Close(reason)); err != nil {
+			sess.log.Error("N", logger.Trace(), "cannot write session audit record: "+err.Error())
+		}
+	}()
+}
+
+//observeResult measures recognition latency and counts STT errors
+func (s *StreamHandler) observeResult(sess *session, result *entity.Response) {
+	if result.ErrCode != entity.ErrOK {
+		metrics.STTErrors.WithLabelValues(strconv.Itoa(result.ErrCode)).Inc()
+	}
+	if result.State != entity.StateResult || sess.recogStart.IsZero() {
+		return
+	}
+	elapsed := time.Since(sess.recogStart).Seconds()
+	if !sess.gotResult {
+		metrics.FirstResultLatency.Observe(elapsed)
+		sess.gotResult = true
+	}
+	if result.IsFinish {
+		metrics.FinalResultLatency.Observe(elapsed)
+		sess.recogStart, sess.gotResult = time.Now(), false
+	}
+}
+
+//countAudio adds a chunk of 16 bit PCM to the relayed audio counters
+func countAudio(direction string, pcm []byte, sampleRate int) {
+	metrics.AudioBytes.WithLabelValues(direction).Add(float64(len(pcm)))
+	metrics.AudioSeconds.WithLabelValues(direction).Add(audio.Duration(len(pcm), sampleRate).Seconds())
+}
+
+//speakFinished reports the end of the current prompt and starts the next one
+func (s *StreamHandler) speakFinished(sess *session, err error) {
+	req := sess.player.Current()
+	resp := entity.Response{
+		ErrCode: entity.ErrOK,
+		State:   entity.StateSpeakDone,
+		Node:    req.Node,
+	}
+	if err != nil {
+		sess.log.Error("N", logger.Trace(), "speak failed: "+err.Error())
+		resp.ErrCode = entity.ErrServerFails
+		resp.ErrMsg = err.Error()
+	}
+	sess.reply(resp)
+	sess.player.Done()
+}
+
+//keyPressed feeds a dtmf event to the collector, it returns true once the
+//session has to be closed
+func (s *StreamHandler) keyPressed(sess *session, action *entity.Action) bool {
+	digit, err := dtmf.Digit(action)
+	if err != nil {
+		sess.reply(entity.Response{
+			ErrCode: entity.ErrParamInvalid,
+			ErrMsg:  err.Error(),
+		})
+		return false
+	}
+	if !sess.dtmf.Accepts(action) {
+		return false
+	}
+	if s.bargeIn(sess, "dtmf") {
+		return true
+	}
+	//the caller is answering, the flow no input timer no longer applies
+	sess.noInput = nil
+	outcome, done := sess.dtmf.Add(digit)
+	if !done {
+		sess.dtmfTimeout = time.After(sess.dtmf.InterDigitTimeout())
+		return false
+	}
+	return s.dtmfDone(sess, outcome)
+}
+
+//dtmfDone reports collected digits like a final recognition result and
+//hands them to the call flow
+func (s *StreamHandler) dtmfDone(sess *session, outcome *dtmf.Outcome) bool {
+	sess.dtmfTimeout = nil
+	resp := entity.Response{
+		ErrCode:     entity.ErrOK,
+		State:       entity.StateResult,
+		IsFinish:    true,
+		RecogResult: outcome.Digits,
+		InputMode:   entity.InputDTMF,
+	}
+	if !outcome.Match {
+		resp.ErrCode = entity.ErrNoResult
+		resp.ErrMsg = "not enough digits"
+	}
+	sess.reply(resp)
+
+	if sess.runner == nil || !sess.runner.Waiting() {
+		return false
+	}
+	var steps []callflow.Step
+	var err error
+	if outcome.Match {
+		steps, err = sess.flowInput(outcome.Digits)
+	} else {
+		steps, err = sess.runner.NoInput()
+	}
+	return s.playSteps(sess, steps, err)
+}
+
+//bargeIn stops the prompt being played when it allows the caller to
+//interrupt it, recognition carries on untouched. It returns true once the
+//session has to be closed.
+func (s *StreamHandler) bargeIn(sess *session, cause string) bool {
+	req := sess.player.Current()
+	if req == nil || !req.BargeIn {
+		return false
+	}
+	sess.log.Info("N", logger.Trace(), "barge-in detected by "+cause)
+	sess.player.Stop()
+	sess.reply(entity.Response{
+		ErrCode: entity.ErrOK,
+		State:   entity.StateBargeIn,
+		Node:    req.Node,
+	})
+	return sess.closing
+}
+
+func (s *StreamHandler) streamFromWS(ctx context.Context, log logger.Logger, FSM *fsm.FSM, ws *websocket.Conn, first []byte, lease *quota.Lease, audioIn *audioQueue, actCh chan<- entity.Action, startCh <-chan startAck, speechCh chan<- struct{}) error {
+	vad := audio.NewEnergyDetector(s.speechThreshold, s.speechFrames)
+	sampleRate := 0
+	var rec *recording.Recording
 	for {
 		select {
 		case <-ctx.Done():
-			s.logger.Info("N", logger.Trace(), "close goroutine")
-			return
+			log.Info("N", logger.Trace(), "close goroutine")
+			return nil
 		default:
-			msgType, msg, err := ws.ReadMessage()
+			var msgType int
+			var msg []byte
+			var err error
+			if first != nil {
+				//start action already read while authenticating
+				msgType, msg, first = websocket.TextMessage, first, nil
+			} else {
+				msgType, msg, err = ws.ReadMessage()
+				if err == nil {
+					s.alive(ws)
+				}
+			}
 			if err != nil {
-				s.logger.Error("N", logger.Trace(), err.Error())
-				if isClosed(errCh) {
-					return
+				//the connection is closed under the read once the session
+				//stops
+				if ctx.Err() != nil {
+					log.Info("N", logger.Trace(), "close goroutine")
+					return nil
 				}
-				errCh <- err
-				return
+				if err == websocket.ErrReadLimit {
+					log.Info("N", logger.Trace(), "message exceeds max_message_size, closing")
+				}
+				log.Error("N", logger.Trace(), err.Error())
+				return err
 			}
 			if binary.Size(msg) == 0 {
 				break
 			}
 
+			action := entity.Action{}
+			if msgType == 1 {
+				//json decode msg
+				ffjson.Unmarshal(msg, &action)
+				log.Debug("N", logger.Trace(), "action from client: "+action.Action)
+				//prompts can be played whether or not we are listening
+				if action.Action == entity.ActionSpeak {
+					if !sendAction(ctx, actCh, action) {
+						return nil
+					}
+					break
+				}
+			}
+
 			switch FSM.Current() {
 			case "open":
+				if msgType == 2 {
+					metrics.FramesDropped.WithLabelValues(QueueAudioIn, "not_listening").Inc()
+				}
 				if msgType == 1 {
-					//json decode msg
-					ffjson.Unmarshal(msg, &action)
-					// s.logger.Debug("N", logger.Trace(), "ASHD:"+action.Action)
+					// log.Debug("N", logger.Trace(), "ASHD:"+action.Action)
 					if action.Action == entity.ActionStart {
 						//ws.WriteMessage(1, []byte("lets rock"))
-						FSM.Event("start")
+						if !sendAction(ctx, actCh, action) {
+							return nil
+						}
+						//hold audio back until the STT worker acknowledged the
+						//setup, a rejected start leaves the session open
+						select {
+						case ack := <-startCh:
+							if ack.accepted {
+								sampleRate = action.SampleRate
+								rec = ack.recording
+								FSM.Event("start")
+							}
+						case <-ctx.Done():
+							return nil
+						}
 					}
 				}
 			case "listen":
 				if msgType == 1 {
-					//json decode msg
-					ffjson.Unmarshal(msg, &action)
-					if action.Action == entity.ActionStop {
+					switch action.Action {
+					case entity.ActionStop:
 						//ws.WriteMessage(1, []byte("bye"))
 						FSM.Event("stop")
-						//TODO intersting flow here, stop should be
-						//a final status
-						ws.WriteJSON(ListenAction)
+						if !sendAction(ctx, actCh, action) {
+							return nil
+						}
+					case entity.ActionDTMF:
+						if !sendAction(ctx, actCh, action) {
+							return nil
+						}
 					}
 				}
 				if msgType == 2 {
-					ch <- msg
+					if err := lease.Consume(audio.Duration(len(msg), sampleRate)); err != nil {
+						metrics.QuotaRejections.WithLabelValues(quotaReason(err)).Inc()
+						return err
+					}
+					rec.Write(msg)
+					countAudio("in", msg, sampleRate)
+					if vad.Feed(msg) {
+						select {
+						case speechCh <- struct{}{}:
+						default:
+						}
+					}
+					if err := audioIn.push(msg); err != nil {
+						log.Error("N", logger.Trace(), err.Error())
+						return err
+					}
 				}
 			}
 		}
 	}
 }
 
-func (s *StreamHandler) streamRelay(ctx context.Context, nc *nats.Conn, ch <-chan []byte, subject string, replyTo string, errCh chan error) {
+func (s *StreamHandler) streamRelay(ctx context.Context, log logger.Logger, nc *nats.Conn, tr *sessionTrace, ch <-chan []byte, subject string, replyTo string) error {
 	for {
 		select {
 		case <-ctx.Done():
-			s.logger.Info("N", logger.Trace(), "close goroutine")
-			return
-		default:
-			message, ok := <-ch
-			//if channel aleady close
-			if !ok {
-				return
-			}
-			if err := nc.PublishRequest(subject, replyTo, message); err != nil {
-				s.logger.Error("N", logger.Trace(), err.Error())
-				if isClosed(errCh) {
-					return
-				}
-				errCh <- err
-				return
+			log.Info("N", logger.Trace(), "close goroutine")
+			return nil
+		case message := <-ch:
+			if err := tr.publish(nc, subject, replyTo, message); err != nil {
+				log.Error("N", logger.Trace(), err.Error())
+				return err
 			}
 		}
 	}
 }
 
-func (s *StreamHandler) streamFromMailbox(ctx context.Context, mailbox *nats.Subscription, ch chan<- []byte, errCh chan error) {
+func (s *StreamHandler) streamFromMailbox(ctx context.Context, log logger.Logger, mailbox *nats.Subscription, ch chan<- *nats.Msg) error {
+	dropped := 0
 	for {
 		select {
 		case <-ctx.Done():
-			s.logger.Info("N", logger.Trace(), "close goroutine")
-			return
+			log.Info("N", logger.Trace(), "close goroutine")
+			return nil
 		default:
-			msg, err := mailbox.NextMsg(60 * time.Second)
+			msg, err := mailbox.NextMsg(s.Timeouts().Idle)
+			//the NATS client dropped messages it had no room for, the
+			//session goes on without them
+			if err == nats.ErrSlowConsumer {
+				if n, derr := mailbox.Dropped(); derr == nil && n > dropped {
+					metrics.FramesDropped.WithLabelValues(QueueResultsOut, "nats_slow_consumer").Add(float64(n - dropped))
+					dropped = n
+				}
+				log.Error("N", logger.Trace(), err.Error())
+				continue
+			}
 			if err != nil {
-				s.logger.Error("N", logger.Trace(), err.Error())
-				if isClosed(errCh) {
-					return
+				//the mailbox is unsubscribed under the read once the
+				//session stops
+				if ctx.Err() != nil {
+					log.Info("N", logger.Trace(), "close goroutine")
+					return nil
 				}
-				errCh <- err
-				return
+				log.Error("N", logger.Trace(), err.Error())
+				return err
+			}
+			if err := s.deliver(ctx, ch, msg); err != nil {
+				if err == errSlowClient {
+					log.Error("N", logger.Trace(), err.Error())
+					return err
+				}
+				return nil
 			}
-			ch <- msg.Data
 		}
 	}
 }
 
-func isClosed(ch chan error) bool {
+//sendAction hands an action to the session loop, it reports false once
+//the session stops
+func sendAction(ctx context.Context, ch chan<- entity.Action, action entity.Action) bool {
 	select {
-	case <-ch:
+	case ch <- action:
 		return true
-	default:
+	case <-ctx.Done():
+		return false
 	}
-	return false
 }
@@ -0,0 +1,607 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stdlog "log"
+	"net/http/httptest"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/audit"
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/metrics"
+	"github.com/4406arthur/bello/pkg/recording"
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+//sessionsPerCase is how many sessions of each kind run at once
+const sessionsPerCase = 100
+
+//poolSize is the number of NATS connections sessions share
+const poolSize = 8
+
+//failingDomain makes the fake STT worker go silent for a session, and
+//unsetDomain makes it ignore the setup
+const (
+	failingDomain = "fail"
+	unsetDomain   = "unset"
+)
+
+//memorySink keeps audit records in memory
+type memorySink struct {
+	mu      sync.Mutex
+	records []audit.Record
+}
+
+func (s *memorySink) Write(record audit.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memorySink) Last(instance string) (audit.Link, error) {
+	return audit.Link{}, nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+//sessions returns the session records by session id
+func (s *memorySink) sessions() map[string][]*audit.SessionRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string][]*audit.SessionRecord{}
+	for _, r := range s.records {
+		if rec, ok := r.(*audit.SessionRecord); ok {
+			out[rec.SessionID] = append(out[rec.SessionID], rec)
+		}
+	}
+	return out
+}
+
+func runNATS(t *testing.T) *natsserver.Server {
+	t.Helper()
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	return srv
+}
+
+//runWorker serves every subject of the manager like the STT workers do: it
+//acknowledges the setup and answers every audio frame with a partial
+//result, except for sessions set up for failingDomain which never hear back
+//and for unsetDomain which are never acknowledged
+func runWorker(t *testing.T, url string, subjects int) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu := sync.Mutex{}
+	silent := map[string]bool{}
+	result, _ := json.Marshal(entity.Response{State: entity.StateResult, RecogResult: "hello"})
+	for i := 0; i < subjects; i++ {
+		subject := "stt-" + strconv.Itoa(i)
+		_, err := nc.Subscribe(subject+".setup", func(msg *nats.Msg) {
+			setup := entity.RecognizeSetup{}
+			json.Unmarshal(msg.Data, &setup)
+			if setup.Domain == unsetDomain {
+				return
+			}
+			mu.Lock()
+			silent[subject] = setup.Domain == failingDomain
+			mu.Unlock()
+			msg.Respond(nil)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = nc.Subscribe(subject, func(msg *nats.Msg) {
+			mu.Lock()
+			quiet := silent[subject]
+			mu.Unlock()
+			if !quiet {
+				msg.Respond(result)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return nc
+}
+
+//client runs one session and returns the session id the greeting gave
+type client func(t *testing.T, url string) string
+
+func dial(t *testing.T, url string) (*websocket.Conn, string) {
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Error(err)
+		return nil, ""
+	}
+	ws.SetReadDeadline(time.Now().Add(30 * time.Second))
+	greeting := entity.Response{}
+	if err := ws.ReadJSON(&greeting); err != nil || greeting.SessionID == "" {
+		t.Errorf("no greeting: %+v, %v", greeting, err)
+		ws.Close()
+		return nil, ""
+	}
+	return ws, greeting.SessionID
+}
+
+func talk(t *testing.T, ws *websocket.Conn, domain string) bool {
+	if err := ws.WriteJSON(entity.Action{Action: entity.ActionStart, Domain: domain, SampleRate: 8000}); err != nil {
+		t.Error(err)
+		return false
+	}
+	frame := make([]byte, 320)
+	for i := 0; i < 5; i++ {
+		if err := ws.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Error(err)
+			return false
+		}
+	}
+	return true
+}
+
+//readUntilClosed reads until the controller closes the connection
+func readUntilClosed(ws *websocket.Conn) {
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+//closeNormally stops recognition after the results came and says goodbye
+func closeNormally(t *testing.T, url string) string {
+	ws, id := dial(t, url)
+	if ws == nil {
+		return ""
+	}
+	defer ws.Close()
+	if !talk(t, ws, "ok") {
+		return id
+	}
+	for i := 0; i < 5; i++ {
+		result := entity.Response{}
+		if err := ws.ReadJSON(&result); err != nil || result.RecogResult != "hello" {
+			t.Errorf("result %d: %+v, %v", i, result, err)
+			return id
+		}
+	}
+	ws.WriteJSON(entity.Action{Action: entity.ActionStop})
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	readUntilClosed(ws)
+	return id
+}
+
+//drop goes away in the middle of the audio without a close frame
+func drop(t *testing.T, url string) string {
+	ws, id := dial(t, url)
+	if ws == nil {
+		return ""
+	}
+	talk(t, ws, "ok")
+	ws.UnderlyingConn().Close()
+	return id
+}
+
+//workerFails waits for the controller to give up on a silent worker
+func workerFails(t *testing.T, url string) string {
+	ws, id := dial(t, url)
+	if ws == nil {
+		return ""
+	}
+	defer ws.Close()
+	if talk(t, ws, failingDomain) {
+		readUntilClosed(ws)
+	}
+	return id
+}
+
+//setupIgnored is refused when no worker acknowledges its start, and goes on
+//to close the still open session
+func setupIgnored(t *testing.T, url string) string {
+	ws, id := dial(t, url)
+	if ws == nil {
+		return ""
+	}
+	defer ws.Close()
+	if err := ws.WriteJSON(entity.Action{Action: entity.ActionStart, Domain: unsetDomain, SampleRate: 8000}); err != nil {
+		t.Error(err)
+		return id
+	}
+	refused := entity.Response{}
+	if err := ws.ReadJSON(&refused); err != nil || refused.ErrCode != entity.ErrServerFails {
+		t.Errorf("start not refused: %+v, %v", refused, err)
+		return id
+	}
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	readUntilClosed(ws)
+	return id
+}
+
+//waitGoroutines waits for the goroutine count to come back to n
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := &bytes.Buffer{}
+			pprof.Lookup("goroutine").WriteTo(buf, 1)
+			t.Fatalf("%d goroutines left running, %d before:\n%s", runtime.NumGoroutine(), n, buf)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSessionsUnderLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts hundreds of sessions")
+	}
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+
+	ns := runNATS(t)
+	defer ns.Shutdown()
+	worker := runWorker(t, ns.ClientURL(), 4*sessionsPerCase)
+	defer worker.Close()
+	pool, err := stream.NewPool(ns.ClientURL(), poolSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	manager := stream.NewManager("stt", 4*sessionsPerCase, log)
+	sink := &memorySink{}
+	auditLog, err := audit.NewLog(sink, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := recording.NewLocalStore(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := recording.NewRecorder(store, tmp, 0, nil, log)
+	h := NewStreamHandler(pool, manager, log,
+		WithAudit(auditLog),
+		WithRecording(recorder, false),
+		WithTimeouts(Timeouts{Idle: 2 * time.Second, Setup: time.Second}),
+	)
+	r := gin.New()
+	r.GET("/", h.Flow)
+	srv := httptest.NewUnstartedServer(r)
+	//net/http recovers handler panics and reports them here
+	panics := &lockedBuffer{}
+	srv.Config.ErrorLog = stdlog.New(panics, "", 0)
+	srv.Start()
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	before := runtime.NumGoroutine()
+	ids := make(chan string, 4*sessionsPerCase)
+	wg := sync.WaitGroup{}
+	for _, run := range []client{closeNormally, drop, workerFails, setupIgnored} {
+		for i := 0; i < sessionsPerCase; i++ {
+			wg.Add(1)
+			go func(run client) {
+				defer wg.Done()
+				if id := run(t, url); id != "" {
+					ids <- id
+				}
+			}(run)
+		}
+	}
+	wg.Wait()
+	close(ids)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for h.Sessions().Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d sessions still live", h.Sessions().Len())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := h.DrainTrails(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if manager.InUse() != 0 {
+		t.Fatalf("%d subjects not checked in", manager.InUse())
+	}
+	if strings.Contains(panics.String(), "panic") {
+		t.Fatalf("a handler panicked:\n%s", panics)
+	}
+
+	records := sink.sessions()
+	n := 0
+	for id := range ids {
+		n++
+		recs := records[id]
+		if len(recs) != 1 {
+			t.Fatalf("session %s has %d audit records", id, len(recs))
+		}
+		if recs[0].CloseReason == "" || recs[0].CloseReason == "unknown" {
+			t.Fatalf("session %s closed without a reason", id)
+		}
+	}
+	if n != 4*sessionsPerCase || len(records) != n {
+		t.Fatalf("%d sessions ran, %d were audited", n, len(records))
+	}
+	//each pool connection keeps a subscription for setup acknowledgements
+	waitGoroutines(t, before+poolSize)
+}
+
+//lockedBuffer is a bytes.Buffer safe for concurrent use
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+//loudFrame is 20ms of 8kHz audio well above the default speech threshold
+func loudFrame() []byte {
+	frame := make([]byte, 320)
+	for i := 0; i < len(frame); i += 2 {
+		frame[i], frame[i+1] = 0xd0, 0x07
+	}
+	return frame
+}
+
+func TestBargeIn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := runNATS(t)
+	defer ns.Shutdown()
+	worker := runWorker(t, ns.ClientURL(), 4)
+	defer worker.Close()
+	tts := runTTS(t, ns.ClientURL())
+	defer tts.Close()
+	pool, err := stream.NewPool(ns.ClientURL(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	h := NewStreamHandler(pool, stream.NewManager("stt", 4, log), log,
+		WithTimeouts(Timeouts{Idle: 10 * time.Second, SpeakChunk: 10 * time.Second}),
+	)
+	r := gin.New()
+	r.GET("/", h.Flow)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	tests := []struct {
+		name    string
+		domain  string
+		bargeIn bool
+		frame   []byte
+		want    bool
+	}{
+		//the silent worker leaves the speech detector to notice the caller
+		{"speech", failingDomain, true, loudFrame(), true},
+		{"result", "ok", true, make([]byte, 320), true},
+		{"prompt without barge-in", failingDomain, false, loudFrame(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, _ := dial(t, url)
+			if ws == nil {
+				return
+			}
+			defer ws.Close()
+			states := make(chan string, 100)
+			go func() {
+				defer close(states)
+				for {
+					resp := entity.Response{}
+					if err := ws.ReadJSON(&resp); err != nil {
+						return
+					}
+					states <- resp.State
+				}
+			}()
+			ws.WriteJSON(entity.Action{Action: entity.ActionStart, Domain: tt.domain, SampleRate: 8000})
+			//the stalled prompt keeps playing until it is interrupted
+			ws.WriteJSON(entity.Action{Action: entity.ActionSpeak, Text: "stall", BargeIn: tt.bargeIn})
+
+			//a burst of speech at a time, the first may come before the
+			//prompt starts
+			deadline := time.After(time.Second)
+			if tt.want {
+				deadline = time.After(10 * time.Second)
+			}
+			tick := time.NewTicker(50 * time.Millisecond)
+			defer tick.Stop()
+			for {
+				select {
+				case state, ok := <-states:
+					if !ok {
+						t.Fatal("session closed")
+					}
+					if state != entity.StateBargeIn {
+						break
+					}
+					if !tt.want {
+						t.Fatal("a prompt without barge-in was interrupted")
+					}
+					return
+				case <-tick.C:
+					for i := 0; i < 3; i++ {
+						ws.WriteMessage(websocket.BinaryMessage, tt.frame)
+					}
+					ws.WriteMessage(websocket.BinaryMessage, make([]byte, 320))
+				case <-deadline:
+					if tt.want {
+						t.Fatal("no barge-in")
+					}
+					return
+				}
+			}
+		})
+	}
+}
+
+//observations returns how many values h recorded
+func observations(t *testing.T, h prometheus.Histogram) uint64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := h.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestObserveResult(t *testing.T) {
+	first, final := observations(t, metrics.FirstResultLatency), observations(t, metrics.FinalResultLatency)
+	sttErrors := testutil.ToFloat64(metrics.STTErrors.WithLabelValues(strconv.Itoa(entity.ErrNoResult)))
+	h := &StreamHandler{}
+	sess := &session{}
+
+	//nothing is timed before recognition starts
+	h.observeResult(sess, &entity.Response{State: entity.StateResult, IsFinish: true})
+	sess.recogStart = time.Now()
+	tests := []struct {
+		result entity.Response
+		first  uint64
+		final  uint64
+	}{
+		{entity.Response{State: entity.StateListening}, 0, 0},
+		{entity.Response{State: entity.StateResult}, 1, 0},
+		{entity.Response{State: entity.StateResult}, 1, 0},
+		{entity.Response{State: entity.StateResult, IsFinish: true}, 1, 1},
+		//the next utterance is timed from the final result
+		{entity.Response{State: entity.StateResult, IsFinish: true}, 2, 2},
+		{entity.Response{ErrCode: entity.ErrNoResult}, 2, 2},
+	}
+	for i, tt := range tests {
+		h.observeResult(sess, &tt.result)
+		if got := observations(t, metrics.FirstResultLatency) - first; got != tt.first {
+			t.Fatalf("result %d: %d first results timed, want %d", i, got, tt.first)
+		}
+		if got := observations(t, metrics.FinalResultLatency) - final; got != tt.final {
+			t.Fatalf("result %d: %d final results timed, want %d", i, got, tt.final)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.STTErrors.WithLabelValues(strconv.Itoa(entity.ErrNoResult))) - sttErrors; got != 1 {
+		t.Fatalf("%v STT errors counted", got)
+	}
+}
+
+func TestCountAudio(t *testing.T) {
+	inBytes, inSeconds := testutil.ToFloat64(metrics.AudioBytes.WithLabelValues("in")), testutil.ToFloat64(metrics.AudioSeconds.WithLabelValues("in"))
+	countAudio("in", make([]byte, 1600), 8000)
+	countAudio("in", make([]byte, 3200), 0)
+	if got := testutil.ToFloat64(metrics.AudioBytes.WithLabelValues("in")) - inBytes; got != 4800 {
+		t.Fatalf("%v bytes counted", got)
+	}
+	if got := testutil.ToFloat64(metrics.AudioSeconds.WithLabelValues("in")) - inSeconds; got < 0.1999 || got > 0.2001 {
+		t.Fatalf("%v seconds counted", got)
+	}
+}
+
+func TestSessionLogFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := logtest.NewLocal(log.Log)
+	ns := runNATS(t)
+	defer ns.Shutdown()
+	worker := runWorker(t, ns.ClientURL(), 1)
+	defer worker.Close()
+	pool, err := stream.NewPool(ns.ClientURL(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	h := NewStreamHandler(pool, stream.NewManager("stt", 1, log), log)
+	r := gin.New()
+	r.GET("/", h.Flow)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ws, id := dial(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+	if ws == nil {
+		return
+	}
+	defer ws.Close()
+	ws.WriteJSON(entity.Action{Action: entity.ActionStart, Domain: "ok", UID: "caller-1", Platform: "ivr", SampleRate: 8000})
+	ws.WriteMessage(websocket.BinaryMessage, make([]byte, 320))
+	result := entity.Response{}
+	if err := ws.ReadJSON(&result); err != nil {
+		t.Fatal(err)
+	}
+	ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	readUntilClosed(ws)
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Sessions().Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session still live")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	started := false
+	for _, e := range hook.AllEntries() {
+		if e.Data["session"] != id {
+			continue
+		}
+		if e.Data["subject"] != "stt-0" || e.Data["clientIP"] != "127.0.0.1" {
+			t.Fatalf("%q logged with %v", e.Message, e.Data)
+		}
+		started = started || e.Data[logger.FieldUID] != nil
+		//the caller is known from the start action on
+		if started && (e.Data[logger.FieldUID] != "caller-1" || e.Data[logger.FieldPlatform] != "ivr") {
+			t.Fatalf("%q logged with %v", e.Message, e.Data)
+		}
+	}
+	if !started {
+		t.Fatal("no line carries the uid of the start action")
+	}
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/4406arthur/bello/cmd/handler"
+	"github.com/4406arthur/bello/pkg/config"
+	"github.com/4406arthur/bello/pkg/metrics"
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/sirupsen/logrus"
+)
+
+//reloader applies configuration changes while calls are live. Changes that
+//need a restart are refused and logged, the rest of the file still applies.
+type reloader struct {
+	mu      sync.Mutex
+	loader  *config.Loader
+	live    *config.Live
+	manager *stream.Manager
+	pool    *stream.Pool
+	streams *handler.StreamHandler
+	logger  logger.Logger
+}
+
+// reloadSettle lets an editor finish writing before the file is read again
+const reloadSettle = 500 * time.Millisecond
+
+//run reloads on SIGHUP and whenever the configuration file is written
+func (r *reloader) run(ctx context.Context) {
+	trigger := make(chan string, 1)
+	notify := func(source string) {
+		select {
+		case trigger <- source:
+		default:
+		}
+	}
+	//a write shows up as several events, the file is read once they stop
+	var settleMu sync.Mutex
+	var settle *time.Timer
+	file, err := r.loader.Watch(func() {
+		settleMu.Lock()
+		defer settleMu.Unlock()
+		if settle != nil {
+			settle.Stop()
+		}
+		settle = time.AfterFunc(reloadSettle, func() { notify("file change") })
+	})
+	if err != nil {
+		r.logger.Error("NA", logger.Trace(), "cannot watch the configuration: "+err.Error())
+	} else {
+		r.logger.Info("NA", logger.Trace(), "watching configuration "+file)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			notify("SIGHUP")
+		case source := <-trigger:
+			r.reload(source)
+		}
+	}
+}
+
+//reload reads the configuration again and applies what is safe
+func (r *reloader) reload(source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next, err := r.loader.Load()
+	if err != nil {
+		metrics.ConfigReloads.WithLabelValues("invalid").Inc()
+		r.logger.Error("NA", logger.Trace(), "configuration reload on "+source+" refused, keeping the running configuration: "+err.Error())
+		return
+	}
+	current := r.live.Get()
+	changes := current.Diff(next)
+	if len(changes) == 0 {
+		r.logger.Info("NA", logger.Trace(), "configuration reload on "+source+": nothing changed")
+		return
+	}
+	applied, refused := []string{}, []string{}
+	for _, c := range changes {
+		if config.Reloadable(c.Key) {
+			applied = append(applied, c.String())
+		} else {
+			refused = append(refused, c.String())
+		}
+	}
+	if len(refused) > 0 {
+		r.logger.Error("NA", logger.Trace(), "configuration changes need a restart and were not applied:\n  "+strings.Join(refused, "\n  "))
+	}
+	if len(applied) == 0 {
+		metrics.ConfigReloads.WithLabelValues("rejected").Inc()
+		return
+	}
+	cfg := current.Reload(next)
+	r.apply(current, cfg)
+	r.live.Set(cfg)
+	result := "applied"
+	if len(refused) > 0 {
+		result = "partial"
+	}
+	metrics.ConfigReloads.WithLabelValues(result).Inc()
+	r.logger.Info("NA", logger.Trace(), "configuration reload on "+source+" applied:\n  "+strings.Join(applied, "\n  "))
+}
+
+//apply puts the reloadable settings of cfg in effect. The log level is
+//only set when log_level changed, a level set through the admin API stays
+//otherwise.
+func (r *reloader) apply(current, cfg *config.Config) {
+	if cfg.Server.LogLevel != current.Server.LogLevel {
+		setLogLevel(r.logger, cfg.Server.LogLevel)
+	}
+	r.streams.SetTimeouts(streamTimeouts(cfg))
+	r.manager.Resize(cfg.NATS.ConnNumber)
+	if err := r.pool.Resize(cfg.NATS.SubjectNumber); err != nil {
+		r.logger.Error("NA", logger.Trace(), "cannot fill the NATS pool: "+err.Error())
+	}
+}
+
+func setLogLevel(log logger.Logger, level string) {
+	if l, err := logrus.ParseLevel(level); err == nil {
+		log.GetLogger().SetLevel(l)
+	}
+}
+
+func streamTimeouts(cfg *config.Config) handler.Timeouts {
+	return handler.Timeouts{
+		Auth:       cfg.Auth.Timeout,
+		Idle:       cfg.Server.IdleTimeout,
+		SpeakChunk: cfg.Server.TTSChunkTimeout,
+		Setup:      cfg.Server.STTSetupTimeout,
+		Ping:       cfg.Server.WSPingInterval,
+		Pong:       cfg.Server.WSPongTimeout,
+		Write:      cfg.Server.WSWriteTimeout,
+	}
+}
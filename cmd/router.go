@@ -2,21 +2,61 @@ package core
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"io"
+	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/4406arthur/bello/cmd/handler"
+	"github.com/4406arthur/bello/pkg/audit"
+	"github.com/4406arthur/bello/pkg/auth"
+	"github.com/4406arthur/bello/pkg/callflow"
+	"github.com/4406arthur/bello/pkg/config"
+	"github.com/4406arthur/bello/pkg/envelope"
+	"github.com/4406arthur/bello/pkg/grammar"
+	"github.com/4406arthur/bello/pkg/health"
+	"github.com/4406arthur/bello/pkg/metrics"
+	"github.com/4406arthur/bello/pkg/quota"
+	"github.com/4406arthur/bello/pkg/recording"
+	"github.com/4406arthur/bello/pkg/redact"
 	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/pkg/tlsconf"
+	"github.com/4406arthur/bello/pkg/tracing"
 	"github.com/4406arthur/bello/utils/logger"
 	ginlogrus "github.com/4406arthur/gin-logrus"
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
 	ginprometheus "github.com/zsais/go-gin-prometheus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-//InitRouter used config api endpoint and auth middleware
-func InitRouter(log logger.Logger, config *viper.Viper) {
+//InitRouter used config api endpoint and auth middleware, loader reads
+//the configuration again on SIGHUP or when the file changes. The sinks of
+//sinks are closed once the server has shut down.
+func InitRouter(sinks *logger.CLogger, loader *config.Loader, cfg *config.Config) {
+	var log logger.Logger = sinks
+
+	redactor := newRedactor(log, cfg.Redact)
+	if redactor != nil {
+		log = redact.NewLogger(log, redactor)
+	}
+	setLogLevel(log, cfg.Server.LogLevel)
+	//background jobs run until the server is shut down
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	err := tracing.Setup(tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Fatal("NA", logger.Trace(), err.Error())
+	}
 
 	r := gin.Default()
 	//set up prometheus exporter
@@ -33,14 +73,155 @@ func InitRouter(log logger.Logger, config *viper.Viper) {
 		c.Status(http.StatusOK)
 	})
 
-	ncPool, err := stream.NewPool(config.GetString("nats_config.host"), config.GetInt("nats_config.subject_number"))
+	ncPool, err := stream.NewPool(cfg.NATS.Host, cfg.NATS.SubjectNumber)
 	if err != nil {
 		log.Fatal("NA", logger.Trace(), err.Error())
 	}
-	subManager := stream.NewManager("voice", config.GetInt("nats_config.conn_number"), log)
-	streamHandler := handler.NewStreamHandler(ncPool, subManager, log)
+	subManager := stream.NewManager("voice", cfg.NATS.ConnNumber, log)
+	if err := metrics.RegisterStream(subManager, ncPool); err != nil {
+		log.Error("NA", logger.Trace(), "cannot register stream metrics: "+err.Error())
+	}
+
+	flowRepo := callflow.NewMemoryRepository()
+	grammarRepo := grammar.NewMemoryRepository()
+	db := connectMongo(log, cfg.Mongo)
+	if db != nil {
+		repo, err := callflow.NewMongoRepository(db)
+		if err != nil {
+			log.Fatal("NA", logger.Trace(), "cannot index call flow versions: "+err.Error())
+		}
+		flowRepo = repo
+		grammarRepo = grammar.NewMongoRepository(db)
+	}
+	flowService := callflow.NewService(flowRepo, log)
+	grammarService := grammar.NewService(grammarRepo, log)
+
+	streamOpts := []handler.StreamOption{
+		handler.WithCallFlows(flowService),
+		handler.WithGrammars(grammarService),
+		handler.WithTTSSubject(cfg.NATS.TTSSubject),
+		handler.WithTimeouts(streamTimeouts(cfg)),
+		handler.WithBargeIn(cfg.Server.BargeInThreshold, cfg.Server.BargeInFrames),
+		handler.WithBackpressure(handler.Backpressure{
+			AudioBuffer:  cfg.Server.AudioBuffer,
+			Overflow:     cfg.Server.AudioOverflow,
+			ResultBuffer: cfg.Server.ResultBuffer,
+			SlowClient:   cfg.Server.SlowClientTimeout,
+		}),
+		handler.WithUpgrader(handler.UpgraderConfig{
+			AllowedOrigins:  cfg.Server.AllowedOrigins,
+			Subprotocols:    cfg.Server.Subprotocols,
+			ReadBufferSize:  cfg.Server.ReadBufferSize,
+			WriteBufferSize: cfg.Server.WriteBufferSize,
+			Compression:     cfg.Server.EnableCompression,
+			MaxMessageSize:  cfg.Server.MaxMessageSize,
+		}),
+	}
+	authenticator := newAuthenticator(log, cfg.Auth)
+	if len(cfg.Auth.ClientCerts) > 0 {
+		certs, err := auth.NewClientCerts(cfg.Auth.ClientCerts)
+		if err != nil {
+			log.Fatal("NA", logger.Trace(), err.Error())
+		}
+		streamOpts = append(streamOpts, handler.WithClientCerts(certs))
+		//without token credentials an allowed certificate is the only way in
+		if authenticator == nil {
+			authenticator = auth.Chain{}
+		}
+	}
+	if authenticator != nil {
+		streamOpts = append(streamOpts, handler.WithAuth(authenticator))
+	}
+	limiter := quota.NewLimiter(cfg.Quota.Default, cfg.Quota.Rules)
+	keys := newKeyring(log, cfg.Encryption)
+	auditLog := newAuditLog(log, cfg.Audit, db, redactor, keys)
+	streamOpts = append(streamOpts, handler.WithQuotas(limiter), handler.WithAudit(auditLog))
+	if recorder := newRecorder(log, cfg.Recording, keys); recorder != nil {
+		go recorder.Prune(background, time.Hour)
+		streamOpts = append(streamOpts, handler.WithRecording(recorder, cfg.Recording.Mode == config.RecordOnRequest))
+	}
+	debugRules := logger.NewDebugRules()
+	streamOpts = append(streamOpts, handler.WithDebugRules(debugRules))
+	streamHandler := handler.NewStreamHandler(ncPool, subManager, log, streamOpts...)
 	r.GET("/", streamHandler.Flow)
-	//adminGroup := r.Group("/admin")
+	if err := metrics.RegisterQueues(streamHandler.Sessions().QueueDepths); err != nil {
+		log.Error("NA", logger.Trace(), "cannot register queue metrics: "+err.Error())
+	}
+
+	readiness := health.NewReadiness()
+	readiness.Add("nats", health.NATS(ncPool))
+	readiness.Add("subjects", health.Subjects(subManager))
+	if cfg.NATS.STTHealthSubject != "" {
+		readiness.Add("stt_workers", health.STTWorkers(ncPool, cfg.NATS.STTHealthSubject))
+	}
+	if db != nil {
+		readiness.Add("mongo", health.Mongo(db.Client()))
+	}
+	if cfg.Redis.Host != "" {
+		readiness.Add("redis", health.Redis(cfg.Redis.Host))
+	}
+	healthHandler := handler.NewHealthHandler(readiness)
+	r.GET("/livez", healthHandler.Livez)
+	r.GET("/readyz", healthHandler.Readyz)
+
+	live := config.NewLive(cfg)
+	reload := &reloader{
+		loader:  loader,
+		live:    live,
+		manager: subManager,
+		pool:    ncPool,
+		streams: streamHandler,
+		logger:  log,
+	}
+	go reload.run(background)
+
+	//without credentials the admin API is not served at all rather than
+	//left open
+	if adminAuth := newAdminAuthenticator(log, cfg.Admin); adminAuth != nil {
+		guard := handler.NewAdminGuard(adminAuth, log)
+		viewer := guard.Require(handler.RoleViewer, handler.RoleOperator, handler.RoleRuleEditor)
+		operator := guard.Require(handler.RoleOperator)
+		ruleEditor := guard.Require(handler.RoleRuleEditor)
+
+		adminGroup := r.Group("/admin")
+		adminGroup.Use(guard.Audit(auditLog), guard.Authenticate())
+		{
+			sessionHandler := handler.NewSessionHandler(streamHandler.Sessions(), log)
+			adminGroup.GET("/sessions", viewer, sessionHandler.List)
+			adminGroup.DELETE("/sessions/:id", operator, sessionHandler.Close)
+
+			configHandler := handler.NewConfigHandler(live)
+			adminGroup.GET("/config", operator, configHandler.Get)
+
+			flowHandler := handler.NewCallFlowHandler(flowService, log)
+			adminGroup.GET("/flows", viewer, flowHandler.List)
+			adminGroup.POST("/flows", ruleEditor, flowHandler.Upload)
+			adminGroup.GET("/flows/:domain", viewer, flowHandler.Active)
+			adminGroup.GET("/flows/:domain/versions", viewer, flowHandler.Versions)
+			adminGroup.GET("/flows/:domain/versions/:version", viewer, flowHandler.Version)
+			adminGroup.POST("/flows/:domain/versions/:version/activate", ruleEditor, flowHandler.Activate)
+
+			grammarHandler := handler.NewGrammarHandler(grammarService, log)
+			adminGroup.GET("/grammars", viewer, grammarHandler.List)
+			adminGroup.GET("/grammars/:id", viewer, grammarHandler.Get)
+			adminGroup.PUT("/grammars/:id", ruleEditor, grammarHandler.Put)
+			adminGroup.DELETE("/grammars/:id", ruleEditor, grammarHandler.Delete)
+
+			quotaHandler := handler.NewQuotaHandler(limiter, log)
+			adminGroup.GET("/quotas", viewer, quotaHandler.List)
+			adminGroup.GET("/quotas/:key", viewer, quotaHandler.Get)
+			adminGroup.PUT("/quotas/:key", ruleEditor, quotaHandler.Put)
+			adminGroup.DELETE("/quotas/:key", ruleEditor, quotaHandler.Delete)
+
+			logHandler := handler.NewLogHandler(debugRules, log)
+			adminGroup.GET("/log", viewer, logHandler.Get)
+			adminGroup.PUT("/log/level", operator, logHandler.SetLevel)
+			adminGroup.POST("/log/debug", operator, logHandler.AddDebug)
+			adminGroup.DELETE("/log/debug/:id", operator, logHandler.RemoveDebug)
+		}
+	} else {
+		log.Info("NA", logger.Trace(), "admin_config has no credentials, the admin API is not served")
+	}
 	//Token bucket: 20 tickets withun 10 sec
 	//adminGroup.Use(throttle.Throttle(10, 20))
 	//adminGroup.Use(RequestLogger(log))
@@ -52,23 +233,209 @@ func InitRouter(log logger.Logger, config *viper.Viper) {
 	//adminGroup.Get("/getRule", ruleHandler.GetRule)
 	//}
 
-	if config.IsSet("server_config.cert") && config.IsSet("server_config.key") {
-		r.RunTLS(
-			config.GetString("server_config.listen_addr"),
-			config.GetString("server_config.cert"),
-			config.GetString("server_config.key"),
-		)
+	s := &http.Server{
+		Addr:              cfg.Server.ListenAddr,
+		Handler:           newHTTPTimeouts(r, cfg),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		IdleTimeout:       cfg.Server.HTTPIdleTimeout,
+		MaxHeaderBytes:    1 << 20,
 	}
 
-	s := &http.Server{
-		Addr:           config.GetString("server_config.listen_addr"),
-		Handler:        r,
-		ReadTimeout:    4 * time.Second,
-		WriteTimeout:   4 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+	stopped := make(chan struct{})
+	go func() {
+		shutdownOnSignal(s, readiness, streamHandler, cfg.Server, log)
+		close(stopped)
+	}()
+
+	//one listener, TLS when a certificate is configured
+	if cfg.Server.TLS() {
+		reloader, err := tlsconf.New(tlsconf.Config{
+			CertFile:          cfg.Server.Cert,
+			KeyFile:           cfg.Server.Key,
+			ClientCAFile:      cfg.Server.ClientCA,
+			RequireClientCert: cfg.Server.RequireClientCert,
+			MinVersion:        cfg.Server.TLSMinVersion,
+			CipherSuites:      cfg.Server.CipherSuites,
+			ReloadInterval:    cfg.Server.CertReloadInterval,
+		}, log)
+		if err != nil {
+			log.Fatal("NA", logger.Trace(), err.Error())
+		}
+		go reloader.Watch(background)
+		s.TLSConfig = reloader.TLSConfig()
+	}
+	if s.TLSConfig != nil {
+		//older ListenAndServeTLS insist on Certificates or GetCertificate,
+		//the reloader hands out a whole config per handshake instead
+		var ln net.Listener
+		if ln, err = net.Listen("tcp", s.Addr); err == nil {
+			err = s.Serve(tls.NewListener(ln, s.TLSConfig))
+		}
+	} else {
+		err = s.ListenAndServe()
 	}
+	if err != http.ErrServerClosed {
+		log.Fatal("NA", logger.Trace(), err.Error())
+	}
+	<-stopped
+	stopBackground()
+	closeAll(auditLog, sinks, log)
+}
 
-	s.ListenAndServe()
+//connectMongo returns the configured database, or nil when mongo_config is
+//absent and the in-memory stores should be used
+func connectMongo(log logger.Logger, cfg config.Mongo) *mongo.Database {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Endpoint))
+	if err != nil {
+		log.Fatal("NA", logger.Trace(), err.Error())
+	}
+	return client.Database(cfg.Database)
+}
+
+//newAuthenticator chains the credential checks set in auth_config, it
+//returns nil when none is configured and clients stay anonymous
+func newAuthenticator(log logger.Logger, cfg config.Auth) auth.Authenticator {
+	chain := auth.Chain{}
+	if cfg.JWKSFile != "" {
+		jwt, err := auth.NewJWT(auth.JWTConfig{
+			JWKSFile: cfg.JWKSFile,
+			Issuer:   cfg.JWTIssuer,
+			Audience: cfg.JWTAudience,
+			Leeway:   cfg.JWTLeeway,
+		})
+		if err != nil {
+			log.Fatal("NA", logger.Trace(), err.Error())
+		}
+		chain = append(chain, jwt)
+	}
+	if len(cfg.HMACSecrets) > 0 {
+		chain = append(chain, auth.NewHMAC(cfg.HMACSecrets))
+	}
+	//API keys accept any token shape so they are tried last
+	if len(cfg.APIKeys) > 0 {
+		apiKeys := make([]auth.APIKey, 0, len(cfg.APIKeys))
+		for name, key := range cfg.APIKeys {
+			apiKeys = append(apiKeys, auth.APIKey{Name: name, Key: key})
+		}
+		chain = append(chain, auth.NewAPIKeys(apiKeys))
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain
+}
+
+//newAdminAuthenticator chains the admin credentials of admin_config, it
+//returns nil when none is configured
+func newAdminAuthenticator(log logger.Logger, cfg config.Admin) auth.Authenticator {
+	chain := auth.Chain{}
+	if cfg.JWKSFile != "" {
+		jwt, err := auth.NewJWT(auth.JWTConfig{
+			JWKSFile:   cfg.JWKSFile,
+			Issuer:     cfg.JWTIssuer,
+			Audience:   cfg.JWTAudience,
+			Leeway:     cfg.JWTLeeway,
+			RolesClaim: cfg.JWTRolesClaim,
+		})
+		if err != nil {
+			log.Fatal("NA", logger.Trace(), err.Error())
+		}
+		chain = append(chain, jwt)
+	}
+	if len(cfg.APIKeys) > 0 {
+		chain = append(chain, auth.NewAPIKeys(cfg.APIKeys))
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain
+}
+
+//newAuditLog opens the sink named by audit_config.sink (file, mongo or
+//elasticsearch), records go to the application log when it is not set
+func newAuditLog(log logger.Logger, cfg config.Audit, db *mongo.Database, redactor *redact.Redactor, keys *envelope.Keyring) *audit.Log {
+	var sink audit.Sink
+	var err error
+	switch cfg.Sink {
+	case config.AuditLogger:
+		sink = audit.NewLoggerSink(log)
+	case config.AuditFile:
+		sink, err = audit.NewFileSink(cfg.Path, keys)
+	case config.AuditMongo:
+		sink = audit.NewMongoSink(db)
+	case config.AuditElasticsearch:
+		sink, err = audit.NewElasticSink(cfg.Endpoint, cfg.Index)
+	}
+	if err != nil {
+		log.Fatal("NA", logger.Trace(), err.Error())
+	}
+	instance, _ := os.Hostname()
+	var redactText func(string) string
+	if redactor != nil {
+		redactText = redactor.String
+	}
+	auditLog, err := audit.NewLog(sink, instance, redactText)
+	if err != nil {
+		log.Fatal("NA", logger.Trace(), err.Error())
+	}
+	return auditLog
+}
+
+//newRedactor builds the personal data filter of logs and audit records from
+//redact_config, every built-in rule applies unless rules narrows them down.
+//It returns nil when redact_config.disabled is set.
+func newRedactor(log logger.Logger, cfg config.Redact) *redact.Redactor {
+	if cfg.Disabled {
+		return nil
+	}
+	redactor, err := redact.New(cfg.Rules, cfg.Patterns)
+	if err != nil {
+		log.Fatal("NA", logger.Trace(), err.Error())
+	}
+	redactor.Hit = func(rule string) {
+		metrics.Redactions.WithLabelValues(rule).Inc()
+	}
+	return redactor
+}
+
+//newKeyring loads the master keys of encryption_config, recordings and the
+//audit file are encrypted at rest when a keyfile is set
+func newKeyring(log logger.Logger, cfg config.Encryption) *envelope.Keyring {
+	if cfg.Keyfile == "" {
+		return nil
+	}
+	keys, err := envelope.LoadKeyring(cfg.Keyfile)
+	if err != nil {
+		log.Fatal("NA", logger.Trace(), err.Error())
+	}
+	log.Info("NA", logger.Trace(), "encryption at rest with master key "+keys.Active())
+	return keys
+}
+
+//newRecorder returns the recorder of recording_config, nil when sessions
+//are not recorded. Recordings go to a local directory or an S3 compatible
+//bucket and are deleted once older than retention.
+func newRecorder(log logger.Logger, cfg config.Recording, keys *envelope.Keyring) *recording.Recorder {
+	if !cfg.Enabled {
+		return nil
+	}
+	var store recording.Store
+	var err error
+	switch cfg.Store {
+	case config.StoreLocal:
+		store, err = recording.NewLocalStore(cfg.Dir)
+	case config.StoreS3:
+		store, err = recording.NewS3Store(cfg.S3)
+	}
+	if err != nil {
+		log.Fatal("NA", logger.Trace(), err.Error())
+	}
+	return recording.NewRecorder(store, cfg.TmpDir, cfg.Retention, keys, log)
 }
 
 // func RequestLogger(log logger.Logger) gin.HandlerFunc {
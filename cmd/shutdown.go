@@ -0,0 +1,115 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/4406arthur/bello/cmd/handler"
+	"github.com/4406arthur/bello/pkg/audit"
+	"github.com/4406arthur/bello/pkg/config"
+	"github.com/4406arthur/bello/pkg/health"
+	"github.com/4406arthur/bello/pkg/tracing"
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+//sessionCloseWait is how long closed sessions get to tell their client
+const sessionCloseWait = 5 * time.Second
+
+//flushWait is how long the buffered spans get to be exported
+const flushWait = 5 * time.Second
+
+//trailWait is how long the recordings and audit records of ended sessions
+//get to be stored
+const trailWait = 30 * time.Second
+
+//shutdownOnSignal stops srv on SIGTERM or SIGINT. /readyz fails first so
+//load balancers move new calls elsewhere, then the listener closes and the
+//live sessions get a while to end before they are closed, and their
+//recordings and audit records a while to be stored. A second signal skips
+//the waits.
+func shutdownOnSignal(srv *http.Server, readiness *health.Readiness, streams *handler.StreamHandler, cfg config.Server, log logger.Logger) {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sig)
+	shutdown(sig, srv, readiness, streams, cfg, log)
+}
+
+//shutdown runs the steps of shutdownOnSignal once sig delivers
+func shutdown(sig <-chan os.Signal, srv *http.Server, readiness *health.Readiness, streams *handler.StreamHandler, cfg config.Server, log logger.Logger) {
+	got := <-sig
+	log.Info("NA", logger.Trace(), "received "+got.String()+", draining")
+	readiness.Drain()
+
+	select {
+	case <-time.After(cfg.ShutdownDelay):
+	case <-sig:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error("NA", logger.Trace(), "cannot stop the listener: "+err.Error())
+	}
+
+	sessions := streams.Sessions()
+	if !waitSessions(ctx, sig, sessions) {
+		log.Info("NA", logger.Trace(), "closing "+strconv.Itoa(sessions.Len())+" live sessions")
+		sessions.Shutdown()
+		closing, cancel := context.WithTimeout(context.Background(), sessionCloseWait)
+		defer cancel()
+		waitSessions(closing, sig, sessions)
+	}
+
+	storing, cancel := context.WithTimeout(context.Background(), trailWait)
+	defer cancel()
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-storing.Done():
+		}
+	}()
+	if err := streams.DrainTrails(storing); err != nil {
+		log.Error("NA", logger.Trace(), "recordings still uploading were abandoned: "+err.Error())
+	}
+	log.Info("NA", logger.Trace(), "shutdown complete")
+}
+
+//waitSessions waits for every session to end, it returns false when ctx is
+//done or a signal comes first
+func waitSessions(ctx context.Context, sig <-chan os.Signal, sessions *handler.SessionRegistry) bool {
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for sessions.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-sig:
+			return false
+		case <-tick.C:
+		}
+	}
+	return true
+}
+
+//closeAll flushes what is still buffered once the sessions are gone and
+//their trails stored: the audit records first, then the log lines since
+//closing the audit sink may log, and the spans last
+func closeAll(auditLog *audit.Log, sinks *logger.CLogger, log logger.Logger) {
+	if err := auditLog.Close(); err != nil {
+		log.Error("NA", logger.Trace(), "cannot close the audit log: "+err.Error())
+	}
+	if err := sinks.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, "cannot close the log sinks: "+err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), flushWait)
+	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "cannot export the last spans: "+err.Error())
+	}
+}
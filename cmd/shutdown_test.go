@@ -0,0 +1,232 @@
+package core
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/cmd/handler"
+	"github.com/4406arthur/bello/pkg/audit"
+	"github.com/4406arthur/bello/pkg/config"
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/health"
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+//memorySink keeps audit records in memory and logs when it is closed
+type memorySink struct {
+	mu      sync.Mutex
+	records []audit.Record
+	log     logger.Logger
+}
+
+func (s *memorySink) Write(record audit.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memorySink) Last(instance string) (audit.Link, error) {
+	return audit.Link{}, nil
+}
+
+func (s *memorySink) Close() error {
+	if s.log != nil {
+		s.log.Info("NA", logger.Trace(), "audit sink closed")
+	}
+	return nil
+}
+
+//runSTT starts a NATS server with a worker acknowledging every setup but
+//never sending a result
+func runSTT(t *testing.T) string {
+	t.Helper()
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	if _, err := nc.Subscribe("*.setup", func(msg *nats.Msg) { msg.Respond(nil) }); err != nil {
+		t.Fatal(err)
+	}
+	nc.Flush()
+	return srv.ClientURL()
+}
+
+func TestShutdownOrder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := stream.NewPool(runSTT(t), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	sink := &memorySink{}
+	auditLog, err := audit.NewLog(sink, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := stream.NewManager("stt", 2, log)
+	readiness := health.NewReadiness()
+	readiness.Add("subjects", health.Subjects(manager))
+	streams := handler.NewStreamHandler(pool, manager, log, handler.WithAudit(auditLog))
+	r := gin.New()
+	r.GET("/", streams.Flow)
+	r.GET("/readyz", handler.NewHealthHandler(readiness).Readyz)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: r}
+	go srv.Serve(ln)
+	base := "http://" + ln.Addr().String()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	ready := func() (int, error) {
+		resp, err := client.Get(base + "/readyz")
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(base, "http")+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	greeting := entity.Response{}
+	if err := ws.ReadJSON(&greeting); err != nil {
+		t.Fatal(err)
+	}
+	ws.WriteJSON(entity.Action{Action: entity.ActionStart, Domain: "ok", SampleRate: 8000})
+	var last entity.Response
+	var closedAt time.Time
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			msg := entity.Response{}
+			if err := ws.ReadJSON(&msg); err != nil {
+				closedAt = time.Now()
+				return
+			}
+			last = msg
+		}
+	}()
+	if code, err := ready(); code != http.StatusOK {
+		t.Fatalf("not ready before the signal: %d, %v", code, err)
+	}
+
+	sig := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	go func() {
+		shutdown(sig, srv, readiness, streams, config.Server{ShutdownDelay: 300 * time.Millisecond, ShutdownTimeout: 300 * time.Millisecond}, log)
+		close(done)
+	}()
+	sig <- syscall.SIGTERM
+
+	//readiness fails first, while new connections are still served
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, err := ready()
+		if err != nil {
+			t.Fatalf("listener closed before readiness failed: %v", err)
+		}
+		if code == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("still ready after the signal")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if streams.Sessions().Len() != 1 {
+		t.Fatal("the live session did not outlast the drain")
+	}
+
+	//then the listener closes, and the live session gets a while longer
+	for {
+		if _, err := ready(); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("listener still open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	refusedAt := time.Now()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shutdown did not complete")
+	}
+	<-closed
+	if closedAt.Before(refusedAt) {
+		t.Fatal("the session was closed before the listener")
+	}
+	if last.ErrCode != entity.ErrCanNotUse || last.ErrMsg != "server shutting down" {
+		t.Fatalf("session ended with %+v", last)
+	}
+	//shutdown returns once the audit record is stored
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.records) != 1 {
+		t.Fatalf("%d audit records", len(sink.records))
+	}
+	if rec := sink.records[0].(*audit.SessionRecord); rec.Domain != "ok" || rec.CloseReason != "server shutting down" {
+		t.Fatalf("audited %+v", rec)
+	}
+}
+
+func TestCloseAll(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bello.log")
+	log, err := logger.NewLogger([]logger.SinkConfig{{Type: logger.SinkFile, Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := audit.NewLog(&memorySink{log: log}, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeAll(auditLog, log, log)
+	//lines after the sinks closed are dropped, not a panic
+	log.Info("NA", logger.Trace(), "after close")
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the audit log closes first, what it logs still reaches the file
+	if !strings.Contains(string(data), "audit sink closed") {
+		t.Fatalf("log file holds %q", data)
+	}
+	if strings.Contains(string(data), "after close") {
+		t.Fatal("a line was written after the sinks closed")
+	}
+}
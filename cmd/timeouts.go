@@ -0,0 +1,43 @@
+package core
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/config"
+	"github.com/gorilla/websocket"
+)
+
+//httpTimeouts sets the deadlines of each request rather than of the whole
+//server: WebSocket calls last minutes and their keepalive takes care of
+//them, admin requests may take longer than probes and scrapes
+type httpTimeouts struct {
+	next                  http.Handler
+	read, write           time.Duration
+	adminRead, adminWrite time.Duration
+}
+
+func newHTTPTimeouts(next http.Handler, cfg *config.Config) http.Handler {
+	return &httpTimeouts{
+		next:       next,
+		read:       cfg.Server.HTTPReadTimeout,
+		write:      cfg.Server.HTTPWriteTimeout,
+		adminRead:  cfg.Admin.ReadTimeout,
+		adminWrite: cfg.Admin.WriteTimeout,
+	}
+}
+
+func (h *httpTimeouts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		read, write := h.read, h.write
+		if r.URL.Path == "/admin" || strings.HasPrefix(r.URL.Path, "/admin/") {
+			read, write = h.adminRead, h.adminWrite
+		}
+		now := time.Now()
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(now.Add(read))
+		rc.SetWriteDeadline(now.Add(write))
+	}
+	h.next.ServeHTTP(w, r)
+}
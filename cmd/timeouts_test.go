@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/config"
+	"github.com/gorilla/websocket"
+)
+
+//slowPost sends the head of a POST to path at once and its body after
+//pause, it returns the status line
+func slowPost(t *testing.T, addr, path string, pause time.Duration) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("POST " + path + " HTTP/1.1\r\nHost: bello\r\nContent-Length: 4\r\n\r\nbe"))
+	time.Sleep(pause)
+	conn.Write([]byte("lo"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(status)
+}
+
+func TestHTTPTimeouts(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.HTTPReadTimeout = 100 * time.Millisecond
+	cfg.Server.HTTPWriteTimeout = 2 * time.Second
+	cfg.Admin.ReadTimeout = 2 * time.Second
+	cfg.Admin.WriteTimeout = 2 * time.Second
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	read := func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	mux.HandleFunc("/grammars", read)
+	mux.HandleFunc("/admin/grammars", read)
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		for {
+			mt, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(mt, msg)
+		}
+	})
+	srv := httptest.NewServer(newHTTPTimeouts(mux, cfg))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	tests := []struct {
+		name  string
+		path  string
+		pause time.Duration
+		want  string
+	}{
+		{"prompt body", "/grammars", 0, "HTTP/1.1 200 OK"},
+		{"slow body", "/grammars", 500 * time.Millisecond, "HTTP/1.1 408 Request Timeout"},
+		{"slow admin body", "/admin/grammars", 500 * time.Millisecond, "HTTP/1.1 200 OK"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slowPost(t, addr, tt.path, tt.pause); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	//WebSocket calls outlast the deadlines
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	time.Sleep(300 * time.Millisecond)
+	if err := ws.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != "hello" {
+		t.Fatalf("got %q, %v", msg, err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/4406arthur/bello/pkg/audit"
+	"github.com/4406arthur/bello/pkg/envelope"
+)
+
+//commands are run instead of the server when named as the first argument,
+//each returns the exit code
+var commands = map[string]func(args []string) int{
+	"verify-audit": verifyAudit,
+	"decrypt":      decrypt,
+	"rewrap":       rewrap,
+	"keygen":       keygen,
+}
+
+//verifyAudit checks the hash chains of an audit file, encrypted files need
+//the keyfile
+func verifyAudit(args []string) int {
+	fs := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	keyfile := fs.String("keyfile", "", "master keys of an encrypted audit file")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fmt.Println("usage: verify-audit [-keyfile <file>] <file>")
+		return 2
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer f.Close()
+	var r io.Reader = f
+	encrypted, err := envelope.IsEncrypted(fs.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if encrypted {
+		if *keyfile == "" {
+			fmt.Println("the audit file is encrypted, -keyfile is required")
+			return 2
+		}
+		if r, err = openEncrypted(f, *keyfile); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+	n, err := audit.Verify(r)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("%d records verified\n", n)
+	return 0
+}
+
+//decrypt writes the plain text of an encrypted recording or audit file to
+//stdout or -o. Only holders of the keyfile can run it.
+func decrypt(args []string) int {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	keyfile := fs.String("keyfile", "", "master keys")
+	out := fs.String("o", "", "output file, stdout when empty")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || *keyfile == "" {
+		fmt.Fprintln(os.Stderr, "usage: decrypt -keyfile <file> [-o <file>] <file>")
+		return 2
+	}
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer in.Close()
+	r, err := openEncrypted(in, *keyfile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if *out != "" {
+			os.Remove(*out)
+		}
+		return 1
+	}
+	return 0
+}
+
+//rewrap moves encrypted files to the newest master key so older keys can
+//be retired. Audit files must not be rewrapped while the server writes them.
+func rewrap(args []string) int {
+	fs := flag.NewFlagSet("rewrap", flag.ContinueOnError)
+	keyfile := fs.String("keyfile", "", "master keys, the first one wraps")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 || *keyfile == "" {
+		fmt.Println("usage: rewrap -keyfile <file> <file>...")
+		return 2
+	}
+	keys, err := envelope.LoadKeyring(*keyfile)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	code := 0
+	for _, path := range fs.Args() {
+		changed, err := envelope.Rewrap(path, keys)
+		switch {
+		case err != nil:
+			fmt.Printf("%s: %s\n", path, err.Error())
+			code = 1
+		case changed:
+			fmt.Printf("%s: rewrapped with %s\n", path, keys.Active())
+		default:
+			fmt.Printf("%s: already uses %s\n", path, keys.Active())
+		}
+	}
+	return code
+}
+
+//keygen prints a keyfile line with a new master key, putting it first in
+//the keyfile makes it the one that wraps new data keys
+func keygen(args []string) int {
+	if len(args) != 1 {
+		fmt.Println("usage: keygen <id>")
+		return 2
+	}
+	line, err := envelope.GenerateKey(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(line)
+	return 0
+}
+
+func openEncrypted(r io.Reader, keyfile string) (io.Reader, error) {
+	keys, err := envelope.LoadKeyring(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	return envelope.NewReader(r, keys)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/audit"
+	"github.com/4406arthur/bello/pkg/envelope"
+	"github.com/4406arthur/bello/pkg/envelope/envelopetest"
+)
+
+//writeAudit writes a chain of three admin records to path
+func writeAudit(t *testing.T, path string, keys *envelope.Keyring) {
+	t.Helper()
+	sink, err := audit.NewFileSink(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := audit.NewLog(sink, "bello-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, actor := range []string{"alice", "bob", "carol"} {
+		if err := log.Write(&audit.AdminRecord{Time: audit.Now(), Kind: audit.KindAdmin, Actor: actor, Method: "PUT", Path: "/admin/log", Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAudit(t *testing.T) {
+	dir := t.TempDir()
+	keyfile := envelopetest.Keyfile(t, envelopetest.KeyLines(t, "k1")...)
+	keys := envelopetest.Load(t, keyfile)
+	otherKeyfile := envelopetest.Keyfile(t, envelopetest.KeyLines(t, "k2")...)
+
+	plain := filepath.Join(dir, "audit.jsonl")
+	writeAudit(t, plain, nil)
+	encrypted := filepath.Join(dir, "audit.jsonl.enc")
+	writeAudit(t, encrypted, keys)
+	data, _ := ioutil.ReadFile(plain)
+	tampered := filepath.Join(dir, "tampered.jsonl")
+	ioutil.WriteFile(tampered, []byte(strings.Replace(string(data), `"actor":"bob"`, `"actor":"eve"`, 1)), 0600)
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"plain", []string{plain}, 0},
+		{"tampered", []string{tampered}, 1},
+		{"encrypted", []string{"-keyfile", keyfile, encrypted}, 0},
+		{"encrypted without keyfile", []string{encrypted}, 2},
+		{"encrypted with another key", []string{"-keyfile", otherKeyfile, encrypted}, 1},
+		{"missing file", []string{filepath.Join(dir, "none")}, 1},
+		{"no file", nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyAudit(tt.args); got != tt.want {
+				t.Fatalf("exit status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
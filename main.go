@@ -3,23 +3,33 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"runtime"
 
 	core "github.com/4406arthur/bello/cmd"
+	"github.com/4406arthur/bello/pkg/config"
+	"github.com/4406arthur/bello/pkg/metrics"
 	"github.com/4406arthur/bello/utils/logger"
-
-	"github.com/spf13/viper"
 )
 
 var usageStr = `
 AI Customer service controller
 
 Server Options:
-    -c, --config <file>              Configuration file path
+    -c, --config <path>              Configuration file, or a directory holding
+                                     config.json, config.yaml or config.toml
+        --check-config               Validate the configuration and exit
     -h, --help                       Show this message
     -v, --version                    Show version
+
+Commands:
+    verify-audit [-keyfile <file>] <file>
+                                     Check the hash chains of a JSONL audit file
+    decrypt -keyfile <file> [-o <file>] <file>
+                                     Decrypt a recording or audit file
+    rewrap -keyfile <file> <file>...
+                                     Wrap data keys with the newest master key
+    keygen <id>                      Print a new master key line for a keyfile
 `
 
 // usage will print out the flag options for the server.
@@ -28,22 +38,6 @@ func usage() {
 	os.Exit(0)
 }
 
-func setup(path string) *viper.Viper {
-	v := viper.New()
-	v.SetConfigType("json")
-	v.SetConfigName("config")
-	if path != "" {
-		v.AddConfigPath(path)
-	} else {
-		v.AddConfigPath("./config/")
-	}
-
-	if err := v.ReadInConfig(); err != nil {
-		log.Fatalf("Error reading config file, %s", err)
-	}
-	return v
-}
-
 var version string
 
 func printVersion() {
@@ -56,12 +50,19 @@ func printVersion() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
 	//from env
 	var configFile string
 	var showVersion bool
+	var checkConfig bool
 	version = "0.0.1"
 	flag.BoolVar(&showVersion, "v", false, "Print version information.")
 	flag.StringVar(&configFile, "c", "", "Configuration file path.")
+	flag.BoolVar(&checkConfig, "check-config", false, "Validate the configuration and exit.")
 	flag.Usage = usage
 	flag.Parse()
 
@@ -69,11 +70,24 @@ func main() {
 		printVersion()
 		os.Exit(0)
 	}
-	viperConfig := setup(configFile)
-	log := logger.InitLogger(
-		viperConfig.GetString("server_config.log_path"),
-		viperConfig.GetString("server_config.elasticsearch_endpoint"),
-	)
+	loader := config.NewLoader(configFile)
+	cfg, err := loader.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if checkConfig {
+		fmt.Println("configuration is valid")
+		os.Exit(0)
+	}
+	log, err := logger.NewLogger(cfg.LogSinks())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := metrics.RegisterLogSinks(log.Dropped); err != nil {
+		log.Error("NA", logger.Trace(), "cannot register log metrics: "+err.Error())
+	}
 
-	core.InitRouter(log, viperConfig)
+	core.InitRouter(log, loader, cfg)
 }
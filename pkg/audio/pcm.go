@@ -0,0 +1,15 @@
+package audio
+
+import "time"
+
+// DefaultSampleRate is assumed when a client does not say how its audio is
+// sampled
+const DefaultSampleRate = 16000
+
+//Duration is the playing time of n bytes of 16 bit mono PCM
+func Duration(n int, sampleRate int) time.Duration {
+	if sampleRate <= 0 {
+		sampleRate = DefaultSampleRate
+	}
+	return time.Duration(n/2) * time.Second / time.Duration(sampleRate)
+}
@@ -0,0 +1,71 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Default energy detector settings, tuned for 16 bit telephone audio
+const (
+	DefaultSpeechThreshold = 500.0
+	DefaultSpeechFrames    = 3
+)
+
+//EnergyDetector is a minimal voice activity detector for 16 bit little endian
+//PCM. Speech starts once Frames consecutive frames have an RMS level above
+//Threshold and ends at the first quiet frame.
+type EnergyDetector struct {
+	Threshold float64
+	Frames    int
+
+	loud     int
+	speaking bool
+}
+
+//NewEnergyDetector falls back to the defaults for non positive values
+func NewEnergyDetector(threshold float64, frames int) *EnergyDetector {
+	if threshold <= 0 {
+		threshold = DefaultSpeechThreshold
+	}
+	if frames <= 0 {
+		frames = DefaultSpeechFrames
+	}
+	return &EnergyDetector{
+		Threshold: threshold,
+		Frames:    frames,
+	}
+}
+
+//Feed consumes one frame and returns true only on the frame where speech starts
+func (d *EnergyDetector) Feed(frame []byte) bool {
+	if RMS(frame) < d.Threshold {
+		d.loud = 0
+		d.speaking = false
+		return false
+	}
+	d.loud++
+	if !d.speaking && d.loud >= d.Frames {
+		d.speaking = true
+		return true
+	}
+	return false
+}
+
+//Speaking reports whether the last frames were classified as speech
+func (d *EnergyDetector) Speaking() bool {
+	return d.speaking
+}
+
+//RMS returns the root mean square level of 16 bit little endian samples
+func RMS(frame []byte) float64 {
+	n := len(frame) / 2
+	if n == 0 {
+		return 0
+	}
+	var sum float64
+	for i := 0; i < n; i++ {
+		s := float64(int16(binary.LittleEndian.Uint16(frame[2*i:])))
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(n))
+}
@@ -0,0 +1,87 @@
+package audio
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+//frame is n samples of constant level
+func frame(level int16, n int) []byte {
+	b := make([]byte, 2*n)
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint16(b[2*i:], uint16(level))
+	}
+	return b
+}
+
+func TestRMS(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  float64
+	}{
+		{"empty", nil, 0},
+		{"odd byte only", []byte{0xff}, 0},
+		{"silence", frame(0, 160), 0},
+		{"positive", frame(1000, 160), 1000},
+		{"negative", frame(-1000, 160), 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RMS(tt.frame); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnergyDetector(t *testing.T) {
+	loud, quiet := frame(2000, 160), frame(100, 160)
+	d := NewEnergyDetector(0, 0)
+	if d.Threshold != DefaultSpeechThreshold || d.Frames != DefaultSpeechFrames {
+		t.Fatalf("defaults not applied: %+v", d)
+	}
+
+	//speech starts on the third loud frame in a row and is reported once
+	tests := []struct {
+		frame    []byte
+		started  bool
+		speaking bool
+	}{
+		{loud, false, false},
+		{loud, false, false},
+		{quiet, false, false},
+		{loud, false, false},
+		{loud, false, false},
+		{loud, true, true},
+		{loud, false, true},
+		{quiet, false, false},
+		{loud, false, false},
+	}
+	for i, tt := range tests {
+		if started := d.Feed(tt.frame); started != tt.started || d.Speaking() != tt.speaking {
+			t.Fatalf("frame %d: started %v speaking %v, want %v %v", i, started, d.Speaking(), tt.started, tt.speaking)
+		}
+	}
+
+	d = NewEnergyDetector(3000, 1)
+	if d.Feed(loud) {
+		t.Fatal("a frame below the threshold started speech")
+	}
+	if !d.Feed(frame(3000, 160)) {
+		t.Fatal("a frame at the threshold did not start speech")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	if got := Duration(32000, 16000); got != time.Second {
+		t.Fatalf("got %v", got)
+	}
+	if got := Duration(16000, 8000); got != time.Second {
+		t.Fatalf("got %v", got)
+	}
+	if got := Duration(3200, 0); got != 100*time.Millisecond {
+		t.Fatalf("got %v with the default rate", got)
+	}
+}
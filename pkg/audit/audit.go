@@ -0,0 +1,172 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/envelope"
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+// Record kinds
+const (
+	KindAdmin   = "admin"
+	KindSession = "session"
+)
+
+//Sink stores audit records. Records are only ever appended, Last lets a
+//restarted instance continue its hash chain.
+type Sink interface {
+	Write(record Record) error
+	Last(instance string) (Link, error)
+	Close() error
+}
+
+//Now is the timestamp used in records, truncated to what every sink
+//stores exactly so hashes still verify after a round trip
+func Now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+//AdminRecord describes a mutating admin API call, refused ones included
+type AdminRecord struct {
+	Link       `bson:",inline"`
+	Time       time.Time `json:"time" bson:"time"`
+	Kind       string    `json:"kind" bson:"kind"`
+	Actor      string    `json:"actor" bson:"actor"`
+	AuthMethod string    `json:"auth_method,omitempty" bson:"auth_method,omitempty"`
+	Roles      []string  `json:"roles,omitempty" bson:"roles,omitempty"`
+	Method     string    `json:"method" bson:"method"`
+	Path       string    `json:"path" bson:"path"`
+	Status     int       `json:"status" bson:"status"`
+	ClientIP   string    `json:"client_ip" bson:"client_ip"`
+}
+
+func (r *AdminRecord) redact(f func(string) string) {
+	r.Path = f(r.Path)
+}
+
+//FileSink appends one JSON document per line to a file. With a keyring
+//the file is encrypted and every record is sealed as it is written.
+type FileSink struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	keys   *envelope.Keyring
+	sealer *envelope.Writer
+	enc    *json.Encoder
+}
+
+//NewFileSink opens path for appending, creating it when needed. keys may
+//be nil for a plain text file.
+func NewFileSink(path string, keys *envelope.Keyring) (*FileSink, error) {
+	if keys == nil {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return &FileSink{path: path, file: f, enc: json.NewEncoder(f)}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	sealer, err := envelope.Append(f, keys)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileSink{path: path, file: f, keys: keys, sealer: sealer, enc: json.NewEncoder(sealer)}, nil
+}
+
+//Write ...
+func (s *FileSink) Write(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(record); err != nil {
+		return err
+	}
+	if s.sealer != nil {
+		return s.sealer.Flush()
+	}
+	return nil
+}
+
+//Last scans the file for the newest record of instance
+func (s *FileSink) Last(instance string) (Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(s.path)
+	if err != nil {
+		return Link{}, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if s.keys != nil {
+		if r, err = envelope.NewReader(f, s.keys); err != nil {
+			return Link{}, err
+		}
+	}
+	last := Link{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		link := Link{}
+		if err := json.Unmarshal(scanner.Bytes(), &link); err != nil {
+			continue
+		}
+		if link.Instance == instance && link.Seq > last.Seq {
+			last = link
+		}
+	}
+	return last, scanner.Err()
+}
+
+//Close ...
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.sealer != nil {
+		err = s.sealer.Close()
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//LoggerSink writes records to the application log when no audit sink is
+//configured, it cannot resume a chain after a restart
+type LoggerSink struct {
+	logger logger.Logger
+}
+
+//NewLoggerSink ...
+func NewLoggerSink(log logger.Logger) *LoggerSink {
+	return &LoggerSink{logger: log}
+}
+
+//Write ...
+func (s *LoggerSink) Write(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("AU", logger.Trace(), string(data))
+	return nil
+}
+
+//Last ...
+func (s *LoggerSink) Last(instance string) (Link, error) {
+	return Link{}, nil
+}
+
+//Close ...
+func (s *LoggerSink) Close() error {
+	return nil
+}
@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxRecordSize bounds a single JSONL line when files are read back
+const maxRecordSize = 16 << 20
+
+//Link chains a record to the previous one written by the same instance.
+//Hash covers the previous hash and the record with Hash left empty, so
+//changing or removing a record breaks every later link.
+type Link struct {
+	Instance string `json:"instance" bson:"instance"`
+	Seq      uint64 `json:"seq" bson:"seq"`
+	PrevHash string `json:"prev_hash" bson:"prev_hash"`
+	Hash     string `json:"hash" bson:"hash"`
+}
+
+func (l *Link) link() *Link {
+	return l
+}
+
+//Record is an audit entry that can be chained, AdminRecord and
+//SessionRecord embed Link
+type Record interface {
+	link() *Link
+	//redact rewrites the free text fields that may hold personal data
+	redact(func(string) string)
+}
+
+//Log chains records and hands them to a sink
+type Log struct {
+	mu       sync.Mutex
+	sink     Sink
+	instance string
+	last     Link
+	redact   func(string) string
+}
+
+//NewLog continues the chain instance left in sink. redact, when not nil, is
+//applied to the text of every record before it is hashed and stored.
+func NewLog(sink Sink, instance string, redact func(string) string) (*Log, error) {
+	last, err := sink.Last(instance)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{sink: sink, instance: instance, last: last, redact: redact}, nil
+}
+
+//Write links record to the previous one and appends it
+func (l *Log) Write(record Record) error {
+	if l.redact != nil {
+		record.redact(l.redact)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	link := record.link()
+	link.Instance = l.instance
+	link.Seq = l.last.Seq + 1
+	link.PrevHash = l.last.Hash
+	link.Hash = ""
+	hash, err := hashOf(record)
+	if err != nil {
+		return err
+	}
+	link.Hash = hash
+	if err := l.sink.Write(record); err != nil {
+		return err
+	}
+	l.last = *link
+	return nil
+}
+
+//Close ...
+func (l *Log) Close() error {
+	return l.sink.Close()
+}
+
+//hashOf must be called with the Hash of record empty
+func hashOf(record Record) (string, error) {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(record.link().PrevHash+"\n"), body...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+//Verify checks the chains of a JSONL audit file and returns how many
+//records it holds
+func Verify(r io.Reader) (int, error) {
+	last := map[string]Link{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	n := 0
+	for scanner.Scan() {
+		n++
+		kind := struct {
+			Kind string `json:"kind"`
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), &kind); err != nil {
+			return n, fmt.Errorf("record %d: %s", n, err.Error())
+		}
+		var record Record
+		switch kind.Kind {
+		case KindAdmin:
+			record = &AdminRecord{}
+		case KindSession:
+			record = &SessionRecord{}
+		default:
+			return n, fmt.Errorf("record %d: unknown kind %q", n, kind.Kind)
+		}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return n, fmt.Errorf("record %d: %s", n, err.Error())
+		}
+		link := *record.link()
+		prev := last[link.Instance]
+		if link.Seq != prev.Seq+1 || link.PrevHash != prev.Hash {
+			return n, fmt.Errorf("record %d: chain of %s broken at seq %d", n, link.Instance, link.Seq)
+		}
+		record.link().Hash = ""
+		hash, err := hashOf(record)
+		if err != nil {
+			return n, err
+		}
+		if hash != link.Hash {
+			return n, fmt.Errorf("record %d: hash mismatch at seq %d of %s", n, link.Seq, link.Instance)
+		}
+		last[link.Instance] = link
+	}
+	return n, scanner.Err()
+}
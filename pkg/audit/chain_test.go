@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/envelope"
+	"github.com/4406arthur/bello/pkg/envelope/envelopetest"
+)
+
+//writeRecords appends an admin and a session record for each actor to the
+//file at path, as instance
+func writeRecords(t *testing.T, path, instance string, keys *envelope.Keyring, actors ...string) {
+	t.Helper()
+	sink, err := NewFileSink(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := NewLog(sink, instance, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, actor := range actors {
+		if err := log.Write(&AdminRecord{Time: Now(), Kind: KindAdmin, Actor: actor, Method: "PUT", Path: "/admin/log", Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+		trail := NewTrail("s-"+actor, actor, "10.0.0.1")
+		trail.Transition("idle", "listening")
+		trail.Result(&entity.Response{State: entity.StateResult, IsFinish: true, RecogResult: "hello " + actor})
+		if err := log.Write(trail.Close("client closed")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func verifyFile(t *testing.T, path string, keys *envelope.Keyring) (int, error) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if keys == nil {
+		return Verify(f)
+	}
+	r, err := envelope.NewReader(f, keys)
+	if err != nil {
+		return 0, err
+	}
+	return Verify(r)
+}
+
+func TestChainRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeRecords(t, path, "a", nil, "alice", "bob")
+	//a restarted instance continues its chain, another one starts its own
+	writeRecords(t, path, "a", nil, "carol")
+	writeRecords(t, path, "b", nil, "dave")
+	writeRecords(t, path, "a", nil, "erin")
+	if n, err := verifyFile(t, path, nil); err != nil || n != 10 {
+		t.Fatalf("verified %d records: %v", n, err)
+	}
+}
+
+func TestVerifyDetectsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeRecords(t, path, "a", nil, "alice", "bob", "carol")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	lines[len(lines)-1] += "\n"
+	without := func(i int) []string {
+		return append(append([]string{}, lines[:i]...), lines[i+1:]...)
+	}
+	swapped := append([]string{}, lines...)
+	swapped[2], swapped[3] = swapped[3], swapped[2]
+	tampered := append([]string{}, lines...)
+	tampered[2] = strings.Replace(tampered[2], `"actor":"bob"`, `"actor":"mallory"`, 1)
+	retext := append([]string{}, lines...)
+	retext[3] = strings.Replace(retext[3], "hello bob", "hello eve", 1)
+
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"intact", lines, ""},
+		{"record changed", tampered, "hash mismatch"},
+		{"session text changed", retext, "hash mismatch"},
+		{"records reordered", swapped, "chain of a broken"},
+		{"record deleted", without(2), "chain of a broken"},
+		{"first record deleted", without(0), "chain of a broken"},
+		{"record repeated", append(append([]string{}, lines...), lines[5]), "chain of a broken"},
+		{"not json", append(append([]string{}, lines[:2]...), "garbage\n"), "record 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(strings.NewReader(strings.Join(tt.lines, "")))
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactionIsHashed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewFileSink(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := NewLog(sink, "a", func(s string) string { return strings.Replace(s, "4111", "****", -1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	trail := NewTrail("s1", "gateway", "10.0.0.1")
+	trail.Result(&entity.Response{State: entity.StateResult, IsFinish: true, RecogResult: "card 4111"})
+	trail.Intent("card 4111", "route", "payment")
+	if err := log.Write(trail.Close("done")); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+	data, _ := ioutil.ReadFile(path)
+	if bytes.Contains(data, []byte("4111")) {
+		t.Fatalf("not redacted: %s", data)
+	}
+	if _, err := Verify(bytes.NewReader(data)); err != nil {
+		t.Fatalf("a redacted record does not verify: %v", err)
+	}
+}
+
+func TestEncryptedFileSink(t *testing.T) {
+	keys := envelopetest.Keyring(t, "k1")
+	path := filepath.Join(t.TempDir(), "audit.jsonl.enc")
+	writeRecords(t, path, "a", keys, "alice")
+	writeRecords(t, path, "a", keys, "bob")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("alice")) || bytes.Contains(data, []byte("admin")) {
+		t.Fatal("a record is stored in the clear")
+	}
+	if n, err := verifyFile(t, path, keys); err != nil || n != 4 {
+		t.Fatalf("verified %d records: %v", n, err)
+	}
+
+	//a cut file is refused rather than read as a shorter chain
+	cut := filepath.Join(t.TempDir(), "cut.enc")
+	ioutil.WriteFile(cut, data[:len(data)-10], 0600)
+	if _, err := verifyFile(t, cut, keys); err == nil {
+		t.Fatal("a cut file verified")
+	}
+	if _, err := NewFileSink(cut, keys); err == nil {
+		t.Fatal("appending to a cut file")
+	}
+}
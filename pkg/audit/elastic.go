@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const elasticTimeout = 5 * time.Second
+
+//ElasticSink indexes records into an Elasticsearch index
+type ElasticSink struct {
+	client *elastic.Client
+	index  string
+}
+
+//NewElasticSink ...
+func NewElasticSink(endpoint, index string) (*ElasticSink, error) {
+	client, err := elastic.NewClient(elastic.SetURL(endpoint), elastic.SetSniff(false))
+	if err != nil {
+		return nil, err
+	}
+	return &ElasticSink{client: client, index: index}, nil
+}
+
+//Write creates the document instance-seq, an existing one is never replaced
+func (s *ElasticSink) Write(record Record) error {
+	ctx, cancel := context.WithTimeout(context.Background(), elasticTimeout)
+	defer cancel()
+	link := record.link()
+	_, err := s.client.Index().
+		Index(s.index).
+		Type("audit").
+		Id(link.Instance + "-" + strconv.FormatUint(link.Seq, 10)).
+		OpType("create").
+		BodyJson(record).
+		Do(ctx)
+	return err
+}
+
+//Last ...
+func (s *ElasticSink) Last(instance string) (Link, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), elasticTimeout)
+	defer cancel()
+	res, err := s.client.Search(s.index).
+		Query(elastic.NewTermQuery("instance.keyword", instance)).
+		Sort("seq", false).
+		Size(1).
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return Link{}, nil
+	}
+	if err != nil {
+		return Link{}, err
+	}
+	link := Link{}
+	if res.Hits == nil || len(res.Hits.Hits) == 0 || res.Hits.Hits[0].Source == nil {
+		return link, nil
+	}
+	err = json.Unmarshal(*res.Hits.Hits[0].Source, &link)
+	return link, err
+}
+
+//Close ...
+func (s *ElasticSink) Close() error {
+	s.client.Stop()
+	return nil
+}
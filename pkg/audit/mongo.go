@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const mongoTimeout = 5 * time.Second
+
+//MongoSink inserts records into the audit collection of a database
+type MongoSink struct {
+	records *mongo.Collection
+}
+
+//NewMongoSink ...
+func NewMongoSink(db *mongo.Database) *MongoSink {
+	return &MongoSink{records: db.Collection("audit")}
+}
+
+//Write ...
+func (s *MongoSink) Write(record Record) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err := s.records.InsertOne(ctx, record)
+	return err
+}
+
+//Last ...
+func (s *MongoSink) Last(instance string) (Link, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	link := Link{}
+	err := s.records.FindOne(ctx, bson.M{"instance": instance}, options.FindOne().SetSort(bson.M{"seq": -1})).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return Link{}, nil
+	}
+	return link, err
+}
+
+//Close ...
+func (s *MongoSink) Close() error {
+	return nil
+}
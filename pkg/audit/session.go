@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+)
+
+// Event types of a session record
+const (
+	EventTransition = "transition"
+	EventResult     = "result"
+	EventResponse   = "response"
+	EventIntent     = "intent"
+	EventError      = "error"
+)
+
+//SessionRecord is the audit record of one WebSocket session, written once
+//the session is over
+type SessionRecord struct {
+	Link        `bson:",inline"`
+	Kind        string    `json:"kind" bson:"kind"`
+	SessionID   string    `json:"session_id" bson:"session_id"`
+	Client      string    `json:"client,omitempty" bson:"client,omitempty"`
+	ClientIP    string    `json:"client_ip" bson:"client_ip"`
+	UID         string    `json:"uid,omitempty" bson:"uid,omitempty"`
+	Platform    string    `json:"platform,omitempty" bson:"platform,omitempty"`
+	Domain      string    `json:"domain,omitempty" bson:"domain,omitempty"`
+	StartedAt   time.Time `json:"started_at" bson:"started_at"`
+	EndedAt     time.Time `json:"ended_at" bson:"ended_at"`
+	Events      []Event   `json:"events" bson:"events"`
+	CloseReason string    `json:"close_reason" bson:"close_reason"`
+	//Recording is the URI of the caller audio when the session was recorded
+	Recording string `json:"recording,omitempty" bson:"recording,omitempty"`
+}
+
+func (r *SessionRecord) redact(f func(string) string) {
+	for i := range r.Events {
+		r.Events[i].Text = f(r.Events[i].Text)
+		r.Events[i].ErrMsg = f(r.Events[i].ErrMsg)
+	}
+	r.CloseReason = f(r.CloseReason)
+}
+
+//Event is one step of a session: an FSM transition, a final recognition
+//result, the intent a call flow matched it to, an answer sent to the client
+//or an error
+type Event struct {
+	At      time.Time `json:"at" bson:"at"`
+	Type    string    `json:"type" bson:"type"`
+	From    string    `json:"from,omitempty" bson:"from,omitempty"`
+	To      string    `json:"to,omitempty" bson:"to,omitempty"`
+	State   string    `json:"state,omitempty" bson:"state,omitempty"`
+	Text    string    `json:"text,omitempty" bson:"text,omitempty"`
+	Intent  string    `json:"intent,omitempty" bson:"intent,omitempty"`
+	Node    string    `json:"node,omitempty" bson:"node,omitempty"`
+	ErrCode int       `json:"err_code,omitempty" bson:"err_code,omitempty"`
+	ErrMsg  string    `json:"err_msg,omitempty" bson:"err_msg,omitempty"`
+}
+
+//Trail collects the events of a live session, it is safe for concurrent use
+type Trail struct {
+	mu     sync.Mutex
+	record SessionRecord
+}
+
+//NewTrail ...
+func NewTrail(sessionID, client, clientIP string) *Trail {
+	return &Trail{record: SessionRecord{
+		Kind:      KindSession,
+		SessionID: sessionID,
+		Client:    client,
+		ClientIP:  clientIP,
+		StartedAt: Now(),
+		Events:    []Event{},
+	}}
+}
+
+func (t *Trail) add(e Event) {
+	e.At = Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record.Events = append(t.record.Events, e)
+}
+
+//Started records the caller named by the start action
+func (t *Trail) Started(action *entity.Action) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record.UID = action.UID
+	t.record.Platform = action.Platform
+	t.record.Domain = action.Domain
+}
+
+//Transition ...
+func (t *Trail) Transition(from, to string) {
+	t.add(Event{Type: EventTransition, From: from, To: to})
+}
+
+//Result records a recognition result, partial ones are left out
+func (t *Trail) Result(resp *entity.Response) {
+	if !resp.IsFinish && resp.ErrCode == entity.ErrOK {
+		return
+	}
+	t.add(Event{
+		Type:    EventResult,
+		State:   resp.State,
+		Text:    resp.RecogResult,
+		Intent:  resp.Intent,
+		ErrCode: resp.ErrCode,
+		ErrMsg:  resp.ErrMsg,
+	})
+}
+
+//Intent records the intent a match node picked for the caller input text,
+//an empty intent means the node fell back to its default
+func (t *Trail) Intent(text, node, intent string) {
+	t.add(Event{Type: EventIntent, Text: text, Node: node, Intent: intent})
+}
+
+//Response records an answer the controller sent to the client
+func (t *Trail) Response(resp *entity.Response) {
+	text := resp.Text
+	if text == "" {
+		text = resp.RecogResult
+	}
+	t.add(Event{
+		Type:    EventResponse,
+		State:   resp.State,
+		Text:    text,
+		Intent:  resp.Intent,
+		Node:    resp.Node,
+		ErrCode: resp.ErrCode,
+		ErrMsg:  resp.ErrMsg,
+	})
+}
+
+//Error ...
+func (t *Trail) Error(msg string) {
+	t.add(Event{Type: EventError, ErrMsg: msg})
+}
+
+//Recorded attaches the URI of the session recording
+func (t *Trail) Recorded(uri string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record.Recording = uri
+}
+
+//Close ends the trail and returns the record to write
+func (t *Trail) Close(reason string) *SessionRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record.EndedAt = Now()
+	t.record.CloseReason = reason
+	record := t.record
+	return &record
+}
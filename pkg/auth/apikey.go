@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/sha256"
+)
+
+type apiKeys struct {
+	//sha256 of the key to its name, hashing keeps lookups independent of
+	//how many leading bytes of a guess are right
+	keys map[[sha256.Size]byte]*Principal
+}
+
+//APIKey is a static key and what it grants
+type APIKey struct {
+	Name  string   `json:"name" mapstructure:"name"`
+	Key   string   `json:"key" mapstructure:"key"`
+	Roles []string `json:"roles" mapstructure:"roles"`
+}
+
+//NewAPIKeys accepts static keys, the key name becomes the principal subject
+func NewAPIKeys(keys []APIKey) Authenticator {
+	a := &apiKeys{
+		keys: make(map[[sha256.Size]byte]*Principal, len(keys)),
+	}
+	for _, k := range keys {
+		if k.Key == "" {
+			continue
+		}
+		a.keys[sha256.Sum256([]byte(k.Key))] = &Principal{Subject: k.Name, Method: MethodAPIKey, Roles: k.Roles}
+	}
+	return a
+}
+
+//Applies to anything, API keys have no particular shape
+func (a *apiKeys) Applies(token string) bool {
+	return true
+}
+
+func (a *apiKeys) Authenticate(token string) (*Principal, error) {
+	p, ok := a.keys[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, fail(MethodAPIKey, ReasonUnknown, "")
+	}
+	copied := *p
+	return &copied, nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Authentication methods, also used as metric labels
+const (
+	MethodAPIKey = "apikey"
+	MethodHMAC   = "hmac"
+	MethodJWT    = "jwt"
+	MethodMTLS   = "mtls"
+)
+
+// Failure reasons, also used as metric labels
+const (
+	ReasonMissing   = "missing"
+	ReasonMalformed = "malformed"
+	ReasonUnknown   = "unknown_key"
+	ReasonSignature = "bad_signature"
+	ReasonExpired   = "expired"
+	ReasonClaims    = "bad_claims"
+)
+
+//Principal is the authenticated client of a session or admin call
+type Principal struct {
+	//Subject identifies the client, the API key name or token subject
+	Subject string
+	Method  string
+	//Roles granted to the key or carried by the token, only the admin API
+	//looks at them
+	Roles []string
+}
+
+//HasRole ...
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+//Error explains why a credential was refused
+type Error struct {
+	Method string
+	Reason string
+	Detail string
+}
+
+func (e *Error) Error() string {
+	msg := "authentication failed (" + e.Reason + ")"
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+func fail(method, reason, detail string) *Error {
+	return &Error{Method: method, Reason: reason, Detail: detail}
+}
+
+//Authenticator validates one kind of credential. Applies lets a chain pick
+//the authenticator matching the shape of a token.
+type Authenticator interface {
+	Applies(token string) bool
+	Authenticate(token string) (*Principal, error)
+}
+
+//Chain tries the first authenticator that recognises the token format
+type Chain []Authenticator
+
+//Applies ...
+func (c Chain) Applies(token string) bool {
+	for _, a := range c {
+		if a.Applies(token) {
+			return true
+		}
+	}
+	return false
+}
+
+//Authenticate ...
+func (c Chain) Authenticate(token string) (*Principal, error) {
+	if token == "" {
+		return nil, fail("", ReasonMissing, "no credential")
+	}
+	for _, a := range c {
+		if a.Applies(token) {
+			return a.Authenticate(token)
+		}
+	}
+	return nil, fail("", ReasonMalformed, "unrecognised credential")
+}
+
+//BearerToken extracts the credential of an HTTP request from the
+//Authorization header
+func BearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+//QueryToken takes the token query parameter browsers have to use for
+//WebSocket upgrades out of r, so that nothing handling the request later
+//sees or logs it. Other routes must only accept BearerToken.
+func QueryToken(r *http.Request) string {
+	q := r.URL.Query()
+	if _, ok := q["token"]; !ok {
+		return ""
+	}
+	token := q.Get("token")
+	q.Del("token")
+	r.URL.RawQuery = q.Encode()
+	r.RequestURI = r.URL.RequestURI()
+	return token
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name, header, target, want string
+	}{
+		{"header", "Bearer abc", "/admin/log", "abc"},
+		{"lower case scheme", "bearer abc", "/admin/log", "abc"},
+		{"other scheme", "Basic abc", "/admin/log", ""},
+		{"query is not a bearer token", "", "/admin/log?token=abc", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := BearerToken(r); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryToken(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws/stt?lang=zh&token=abc", nil)
+	if got := QueryToken(r); got != "abc" {
+		t.Fatalf("got %q", got)
+	}
+	if r.URL.RawQuery != "lang=zh" || r.RequestURI != "/ws/stt?lang=zh" {
+		t.Fatalf("token left in %q, %q", r.URL.RawQuery, r.RequestURI)
+	}
+	if got := QueryToken(r); got != "" {
+		t.Fatalf("token read twice: %q", got)
+	}
+	r = httptest.NewRequest("GET", "/ws/stt?lang=zh", nil)
+	if QueryToken(r) != "" || r.RequestURI != "/ws/stt?lang=zh" {
+		t.Fatalf("request without token changed to %q", r.RequestURI)
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Prefixes of the certificate identities a ClientCert can name
+const (
+	IdentityCN    = "cn:"
+	IdentityDNS   = "dns:"
+	IdentityURI   = "uri:"
+	IdentityEmail = "email:"
+)
+
+//ClientCert lets the holder of a certificate carrying Identity in, as the
+//client Name. Identity is cn:<common name>, dns:<DNS SAN>, uri:<URI SAN> or
+//email:<email SAN>.
+type ClientCert struct {
+	Identity string `json:"identity" mapstructure:"identity"`
+	Name     string `json:"name" mapstructure:"name"`
+}
+
+//ClientCerts authenticates clients by their verified TLS certificate. Being
+//signed by the client CA is not enough, the certificate has to carry one of
+//the allowed identities.
+type ClientCerts struct {
+	allowed map[string]string
+}
+
+//NewClientCerts ...
+func NewClientCerts(certs []ClientCert) (*ClientCerts, error) {
+	c := &ClientCerts{allowed: make(map[string]string, len(certs))}
+	for _, cert := range certs {
+		identity, err := normalizeIdentity(cert.Identity)
+		if err != nil {
+			return nil, err
+		}
+		if cert.Name == "" {
+			return nil, errors.New("client certificate " + cert.Identity + " has no name")
+		}
+		c.allowed[identity] = cert.Name
+	}
+	return c, nil
+}
+
+//normalizeIdentity checks the prefix of an identity, DNS names compare
+//case insensitively
+func normalizeIdentity(identity string) (string, error) {
+	for _, prefix := range []string{IdentityCN, IdentityDNS, IdentityURI, IdentityEmail} {
+		if !strings.HasPrefix(identity, prefix) {
+			continue
+		}
+		if len(identity) == len(prefix) {
+			return "", errors.New("client certificate identity " + identity + " is empty")
+		}
+		if prefix == IdentityDNS {
+			return strings.ToLower(identity), nil
+		}
+		return identity, nil
+	}
+	return "", errors.New("client certificate identity " + identity + " must start with cn:, dns:, uri: or email:")
+}
+
+//Identities lists what a certificate can be allowed by
+func Identities(cert *x509.Certificate) []string {
+	ids := make([]string, 0, 1+len(cert.DNSNames)+len(cert.URIs)+len(cert.EmailAddresses))
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, IdentityCN+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		ids = append(ids, IdentityDNS+strings.ToLower(name))
+	}
+	for _, uri := range cert.URIs {
+		ids = append(ids, IdentityURI+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		ids = append(ids, IdentityEmail+email)
+	}
+	return ids
+}
+
+//Authenticate returns the client of a request whose verified certificate is
+//allowed. It returns nil and no error when no verified certificate was
+//presented, or when c is nil.
+func (c *ClientCerts) Authenticate(r *http.Request) (*Principal, error) {
+	if c == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	ids := Identities(r.TLS.VerifiedChains[0][0])
+	for _, id := range ids {
+		if name, ok := c.allowed[id]; ok {
+			return &Principal{Subject: name, Method: MethodMTLS}, nil
+		}
+	}
+	return nil, fail(MethodMTLS, ReasonUnknown, "certificate "+strings.Join(ids, " ")+" is not allowed")
+}
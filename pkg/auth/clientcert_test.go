@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func certRequest(cert *x509.Certificate) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	if cert != nil {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	return r
+}
+
+func TestClientCerts(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://bello/gateway")
+	gateway := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "gateway"},
+		DNSNames:       []string{"GW1.example.com"},
+		URIs:           []*url.URL{spiffe},
+		EmailAddresses: []string{"ops@example.com"},
+	}
+	stranger := &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}
+
+	tests := []struct {
+		name    string
+		allowed []ClientCert
+		cert    *x509.Certificate
+		want    string
+		refused bool
+	}{
+		{"cn", []ClientCert{{Identity: "cn:gateway", Name: "gw"}}, gateway, "gw", false},
+		{"dns ignores case", []ClientCert{{Identity: "dns:gw1.EXAMPLE.com", Name: "gw"}}, gateway, "gw", false},
+		{"uri", []ClientCert{{Identity: "uri:spiffe://bello/gateway", Name: "gw"}}, gateway, "gw", false},
+		{"email", []ClientCert{{Identity: "email:ops@example.com", Name: "ops"}}, gateway, "ops", false},
+		{"cn is not a dns name", []ClientCert{{Identity: "dns:gateway", Name: "gw"}}, gateway, "", true},
+		{"signed but not allowed", []ClientCert{{Identity: "cn:gateway", Name: "gw"}}, stranger, "", true},
+		{"empty allow-list", nil, gateway, "", true},
+		{"no certificate", []ClientCert{{Identity: "cn:gateway", Name: "gw"}}, nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClientCerts(tt.allowed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := c.Authenticate(certRequest(tt.cert))
+			if tt.refused {
+				if p != nil || err == nil {
+					t.Fatalf("got %+v, %v, want a refusal", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if p != nil {
+					t.Fatalf("got %+v without a certificate", p)
+				}
+				return
+			}
+			if p == nil || p.Subject != tt.want || p.Method != MethodMTLS {
+				t.Fatalf("got %+v, want %s", p, tt.want)
+			}
+		})
+	}
+
+	var none *ClientCerts
+	if p, err := none.Authenticate(certRequest(gateway)); p != nil || err != nil {
+		t.Fatalf("nil ClientCerts authenticated %+v, %v", p, err)
+	}
+}
+
+func TestNewClientCertsRefuses(t *testing.T) {
+	for _, certs := range [][]ClientCert{
+		{{Identity: "gateway", Name: "gw"}},
+		{{Identity: "cn:", Name: "gw"}},
+		{{Identity: "cn:gateway"}},
+	} {
+		if _, err := NewClientCerts(certs); err == nil {
+			t.Fatalf("accepted %+v", certs)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // register SHA-256 for crypto.Hash
+	_ "crypto/sha512" // register SHA-384/512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//JWTConfig describes how bearer JWTs are checked
+type JWTConfig struct {
+	//JWKSFile is a local JSON Web Key Set holding the verification keys
+	JWKSFile string
+	//Issuer and Audience are compared with iss and aud when set
+	Issuer   string
+	Audience string
+	//Leeway tolerates clock skew on exp and nbf
+	Leeway time.Duration
+	//RolesClaim names the claim listing the roles of the subject, "roles"
+	//when empty. It may hold an array or a space separated string.
+	RolesClaim string
+	//RefreshInterval is the least time between two reloads of JWKSFile,
+	//which happen when a token names a kid missing from the set.
+	//DefaultJWKSRefresh when zero.
+	RefreshInterval time.Duration
+}
+
+//DefaultJWKSRefresh ...
+const DefaultJWKSRefresh = 30 * time.Second
+
+type jwtVerifier struct {
+	cfg JWTConfig
+	now func() time.Time
+
+	mu sync.RWMutex
+	//keys is replaced as a whole on refresh, never modified
+	keys    map[string]*jwk
+	modTime time.Time
+	checked time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+
+	public interface{}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+}
+
+//NewJWT loads the key set and returns an authenticator for signed JWTs
+//(RS256/384/512, ES256/384/512 and HS256/384/512)
+func NewJWT(cfg JWTConfig) (Authenticator, error) {
+	modTime := jwksModTime(cfg.JWKSFile)
+	keys, err := loadJWKS(cfg.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = DefaultJWKSRefresh
+	}
+	return &jwtVerifier{cfg: cfg, keys: keys, modTime: modTime, now: time.Now}, nil
+}
+
+func jwksModTime(path string) time.Time {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+//key finds the key named by kid after refreshing the key set, so that
+//rotated keys are picked up and removed ones stop verifying without a
+//restart
+func (v *jwtVerifier) key(kid string) *jwk {
+	v.refresh()
+	return v.lookup(kid)
+}
+
+func (v *jwtVerifier) lookup(kid string) *jwk {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if k := v.keys[kid]; k != nil {
+		return k
+	}
+	//a token without kid is only accepted against a single key
+	if kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k
+		}
+	}
+	return nil
+}
+
+//refresh reloads JWKSFile when it changed, at most once per
+//RefreshInterval so that neither busy clients nor made up kids make every
+//request read the disk. A broken file keeps the previous keys.
+func (v *jwtVerifier) refresh() {
+	v.mu.RLock()
+	fresh := v.now().Sub(v.checked) < v.cfg.RefreshInterval
+	v.mu.RUnlock()
+	if fresh {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	if now.Sub(v.checked) < v.cfg.RefreshInterval {
+		return
+	}
+	v.checked = now
+	modTime := jwksModTime(v.cfg.JWKSFile)
+	if !modTime.After(v.modTime) {
+		return
+	}
+	keys, err := loadJWKS(v.cfg.JWKSFile)
+	if err != nil {
+		return
+	}
+	v.keys = keys
+	v.modTime = modTime
+}
+
+//loadJWKS reads a JSON Web Key Set file and indexes its keys by kid
+func loadJWKS(path string) (map[string]*jwk, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := struct {
+		Keys []*jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS %s: %s", path, err.Error())
+	}
+	keys := make(map[string]*jwk, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if err := k.parse(); err != nil {
+			return nil, fmt.Errorf("JWKS key #%d: %s", i, err.Error())
+		}
+		keys[k.Kid] = k
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS " + path + " holds no signing key")
+	}
+	return keys, nil
+}
+
+func (k *jwk) parse() error {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return err
+		}
+		k.public = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return errors.New("EC point is not on the curve")
+		}
+		k.public = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return errors.New("invalid oct key")
+		}
+		k.public = secret
+	default:
+		return errors.New("unsupported key type " + k.Kty)
+	}
+	return nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+//Applies to three dot separated parts whose first one is a JSON header
+func (v *jwtVerifier) Applies(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	return err == nil && len(header) > 0 && header[0] == '{'
+}
+
+func (v *jwtVerifier) Authenticate(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fail(MethodJWT, ReasonMalformed, "")
+	}
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fail(MethodJWT, ReasonMalformed, "header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fail(MethodJWT, ReasonMalformed, "signature")
+	}
+
+	key := v.key(header.Kid)
+	if key == nil {
+		return nil, fail(MethodJWT, ReasonUnknown, "kid "+header.Kid)
+	}
+	if key.Alg != "" && key.Alg != header.Alg {
+		return nil, fail(MethodJWT, ReasonSignature, "algorithm mismatch")
+	}
+	if err := verifySignature(header.Alg, key.public, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, fail(MethodJWT, ReasonSignature, err.Error())
+	}
+
+	claims := jwtClaims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fail(MethodJWT, ReasonMalformed, "claims")
+	}
+	all := map[string]json.RawMessage{}
+	if err := decodeSegment(parts[1], &all); err != nil {
+		return nil, fail(MethodJWT, ReasonMalformed, "claims")
+	}
+	now := v.now()
+	if claims.ExpiresAt == nil {
+		return nil, fail(MethodJWT, ReasonClaims, "exp is required")
+	}
+	if now.Add(-v.cfg.Leeway).Unix() >= *claims.ExpiresAt {
+		return nil, fail(MethodJWT, ReasonExpired, "")
+	}
+	if claims.NotBefore != nil && now.Add(v.cfg.Leeway).Unix() < *claims.NotBefore {
+		return nil, fail(MethodJWT, ReasonClaims, "not valid yet")
+	}
+	if v.cfg.Issuer != "" && claims.Issuer != v.cfg.Issuer {
+		return nil, fail(MethodJWT, ReasonClaims, "issuer")
+	}
+	if v.cfg.Audience != "" && !hasAudience(claims.Audience, v.cfg.Audience) {
+		return nil, fail(MethodJWT, ReasonClaims, "audience")
+	}
+	if claims.Subject == "" {
+		return nil, fail(MethodJWT, ReasonClaims, "sub is required")
+	}
+	return &Principal{Subject: claims.Subject, Method: MethodJWT, Roles: roles(all[v.cfg.RolesClaim])}, nil
+}
+
+func roles(raw json.RawMessage) []string {
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil {
+		return many
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return strings.Fields(one)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func hasAudience(raw json.RawMessage, want string) bool {
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return one == want
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil {
+		for _, aud := range many {
+			if aud == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifySignature(alg string, key interface{}, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return errors.New("unsupported algorithm " + alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return errors.New("unsupported algorithm " + alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key is not RSA")
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key is not EC")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("bad EC signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "HS"):
+		secret, ok := key.([]byte)
+		if !ok {
+			return errors.New("key is not a shared secret")
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported algorithm " + alg)
+}
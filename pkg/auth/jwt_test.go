@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret    = []byte("0123456789abcdef0123456789abcdef")
+	epoch     = time.Unix(1700000000, 0)
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+}
+
+func ecJWK(kid string, pub *ecdsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(pub.X.Bytes()), "y": b64(pub.Y.Bytes())}
+}
+
+func octJWK(kid string, k []byte) map[string]string {
+	return map[string]string{"kty": "oct", "kid": kid, "k": b64(k)}
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//newTestJWT verifies against a key set holding one RSA, EC and oct key, at
+//a clock fixed to epoch
+func newTestJWT(t *testing.T, cfg JWTConfig) *jwtVerifier {
+	t.Helper()
+	cfg.JWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	pinned := rsaJWK("pinned", &rsaKey.PublicKey)
+	pinned["alg"] = "RS256"
+	writeJWKS(t, cfg.JWKSFile,
+		rsaJWK("rsa", &rsaKey.PublicKey),
+		ecJWK("ec", &ecKey.PublicKey),
+		octJWK("hmac", secret),
+		pinned,
+	)
+	a, err := NewJWT(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := a.(*jwtVerifier)
+	v.now = func() time.Time { return epoch }
+	return v
+}
+
+//sign builds a token, key is an *rsa.PrivateKey, *ecdsa.PrivateKey or a
+//shared secret and must match alg
+func sign(t *testing.T, header, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+	return signed + "." + b64(sig)
+}
+
+func claims(extra map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"sub": "alice",
+		"iss": "https://idp",
+		"aud": "bello",
+		"exp": epoch.Add(time.Minute).Unix(),
+	}
+	for k, v := range extra {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func hdr(alg, kid string) map[string]interface{} {
+	h := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		h["kid"] = kid
+	}
+	return h
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	v := newTestJWT(t, JWTConfig{Issuer: "https://idp", Audience: "bello", Leeway: 30 * time.Second})
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: mustMarshalPKIX(t, &rsaKey.PublicKey)})
+	valid := sign(t, hdr("RS256", "rsa"), claims(nil), rsaKey)
+	parts := strings.Split(valid, ".")
+	forged := parts[0] + "." + b64(mustJSON(t, claims(map[string]interface{}{"sub": "mallory"}))) + "." + parts[2]
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sig[len(sig)/2] ^= 1
+	tampered := parts[0] + "." + parts[1] + "." + b64(sig)
+
+	tests := []struct {
+		name   string
+		token  string
+		reason string
+	}{
+		{"rs256", valid, ""},
+		{"es256", sign(t, hdr("ES256", "ec"), claims(nil), ecKey), ""},
+		{"hs256", sign(t, hdr("HS256", "hmac"), claims(nil), secret), ""},
+		{"pinned alg", sign(t, hdr("RS256", "pinned"), claims(nil), rsaKey), ""},
+		{"aud list", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"aud": []string{"other", "bello"}}), rsaKey), ""},
+
+		{"alg none", b64([]byte(`{"alg":"none","kid":"rsa"}`)) + "." + b64(mustJSON(t, claims(nil))) + ".", ReasonSignature},
+		{"alg None", b64([]byte(`{"alg":"None","kid":"hmac"}`)) + "." + b64(mustJSON(t, claims(nil))) + ".", ReasonSignature},
+		{"hs256 with the rsa public key", sign(t, hdr("HS256", "rsa"), claims(nil), rsaPEM), ReasonSignature},
+		{"hs256 with the rsa modulus", sign(t, hdr("HS256", "rsa"), claims(nil), rsaKey.N.Bytes()), ReasonSignature},
+		{"rs256 against the oct key", sign(t, hdr("RS256", "hmac"), claims(nil), rsaKey), ReasonSignature},
+		{"es256 against the rsa key", sign(t, hdr("ES256", "rsa"), claims(nil), ecKey), ReasonSignature},
+		{"alg other than pinned", sign(t, hdr("HS256", "pinned"), claims(nil), secret), ReasonSignature},
+		{"missing kid", sign(t, hdr("RS256", ""), claims(nil), rsaKey), ReasonUnknown},
+		{"unknown kid", sign(t, hdr("RS256", "nope"), claims(nil), rsaKey), ReasonUnknown},
+		{"tampered signature", tampered, ReasonSignature},
+		{"tampered claims", forged, ReasonSignature},
+		{"wrong secret", sign(t, hdr("HS256", "hmac"), claims(nil), []byte("guess")), ReasonSignature},
+
+		{"expired", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"exp": epoch.Add(-time.Minute).Unix()}), rsaKey), ReasonExpired},
+		{"expired within leeway", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"exp": epoch.Add(-10 * time.Second).Unix()}), rsaKey), ""},
+		{"expires now plus leeway", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"exp": epoch.Add(-30 * time.Second).Unix()}), rsaKey), ReasonExpired},
+		{"no exp", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"exp": nil}), rsaKey), ReasonClaims},
+		{"not valid yet", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"nbf": epoch.Add(time.Minute).Unix()}), rsaKey), ReasonClaims},
+		{"nbf within leeway", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"nbf": epoch.Add(20 * time.Second).Unix()}), rsaKey), ""},
+		{"wrong aud", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"aud": "other"}), rsaKey), ReasonClaims},
+		{"no aud", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"aud": nil}), rsaKey), ReasonClaims},
+		{"wrong iss", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"iss": "https://evil"}), rsaKey), ReasonClaims},
+		{"no sub", sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"sub": nil}), rsaKey), ReasonClaims},
+
+		{"two parts", "a.b", ReasonMalformed},
+		{"bad header", "!!." + b64(mustJSON(t, claims(nil))) + ".AA", ReasonMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := v.Authenticate(tt.token)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("refused: %v", err)
+				}
+				if p.Subject != "alice" || p.Method != MethodJWT {
+					t.Fatalf("got %+v", p)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("accepted as %+v, want %s", p, tt.reason)
+			}
+			if e, ok := err.(*Error); !ok || e.Reason != tt.reason {
+				t.Fatalf("got %v, want %s", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestJWTSingleKeyWithoutKid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaJWK("rsa", &rsaKey.PublicKey))
+	a, err := NewJWT(JWTConfig{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := a.(*jwtVerifier)
+	v.now = func() time.Time { return epoch }
+	if _, err := v.Authenticate(sign(t, hdr("RS256", ""), claims(nil), rsaKey)); err != nil {
+		t.Fatalf("a token without kid was refused by a one key set: %v", err)
+	}
+}
+
+func TestJWTRoles(t *testing.T) {
+	v := newTestJWT(t, JWTConfig{RolesClaim: "scope"})
+	p, err := v.Authenticate(sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"scope": "admin read"}), rsaKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasRole("admin") || !p.HasRole("read") || p.HasRole("write") {
+		t.Fatalf("got roles %v", p.Roles)
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	v := newTestJWT(t, JWTConfig{RefreshInterval: time.Minute})
+	now := epoch
+	v.now = func() time.Time { return now }
+	rotated, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token := sign(t, hdr("RS256", "next"), claims(map[string]interface{}{"exp": epoch.Add(time.Hour).Unix()}), rotated)
+	reason := func() string {
+		if _, err := v.Authenticate(token); err != nil {
+			return err.(*Error).Reason
+		}
+		return ""
+	}
+
+	if r := reason(); r != ReasonUnknown {
+		t.Fatalf("key not published yet: got %q", r)
+	}
+	//the first miss used up the refresh of this interval
+	writeJWKS(t, v.cfg.JWKSFile, rsaJWK("rsa", &rsaKey.PublicKey), rsaJWK("next", &rotated.PublicKey))
+	later := time.Now().Add(time.Second)
+	os.Chtimes(v.cfg.JWKSFile, later, later)
+	if r := reason(); r != ReasonUnknown {
+		t.Fatalf("reloaded within the refresh interval: got %q", r)
+	}
+	now = now.Add(time.Minute)
+	if r := reason(); r != "" {
+		t.Fatalf("rotated key not picked up: got %q", r)
+	}
+	if _, err := v.Authenticate(sign(t, hdr("RS256", "ec"), claims(nil), ecKey)); err == nil {
+		t.Fatal("a key removed from the set is still trusted")
+	}
+
+	//a broken file keeps the keys already loaded
+	ioutil.WriteFile(v.cfg.JWKSFile, []byte("{"), 0600)
+	later = later.Add(time.Second)
+	os.Chtimes(v.cfg.JWKSFile, later, later)
+	now = now.Add(time.Minute)
+	v.key("unknown")
+	if r := reason(); r != "" {
+		t.Fatalf("keys lost after a broken reload: got %q", r)
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func mustMarshalPKIX(t *testing.T, pub interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestJWKSKeyRemoved(t *testing.T) {
+	v := newTestJWT(t, JWTConfig{RefreshInterval: time.Minute})
+	now := epoch
+	v.now = func() time.Time { return now }
+	token := sign(t, hdr("RS256", "rsa"), claims(map[string]interface{}{"exp": epoch.Add(time.Hour).Unix()}), rsaKey)
+	if _, err := v.Authenticate(token); err != nil {
+		t.Fatal(err)
+	}
+
+	writeJWKS(t, v.cfg.JWKSFile, ecJWK("ec", &ecKey.PublicKey))
+	later := time.Now().Add(time.Second)
+	os.Chtimes(v.cfg.JWKSFile, later, later)
+	if _, err := v.Authenticate(token); err != nil {
+		t.Fatalf("reloaded within the refresh interval: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := v.Authenticate(token); err == nil || err.(*Error).Reason != ReasonUnknown {
+		t.Fatalf("a key removed from the set still verifies: %v", err)
+	}
+}
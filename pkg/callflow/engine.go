@@ -0,0 +1,182 @@
+package callflow
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+)
+
+// maxSteps bounds how many nodes a single Start/Input call may walk through
+const maxSteps = 64
+
+//ErrFinished returned when input is delivered to a runner that already ended
+var ErrFinished = errors.New("call flow already finished")
+
+//Step is a side effect the session has to carry out for the caller
+type Step struct {
+	Type    string
+	NodeID  string
+	Text    string
+	Target  string
+	Intent  string
+	Timeout time.Duration
+	BargeIn bool
+	DTMF    *entity.DTMFGrammar
+}
+
+//Match is the outcome of a match node, Intent is empty when no intent
+//applied and the default was taken
+type Match struct {
+	NodeID string
+	Intent string
+}
+
+//Runner executes one flow for one session, it is not safe for concurrent use
+type Runner struct {
+	flow    *Flow
+	current *Node
+	vars    map[string]string
+	done    bool
+	matches []Match
+}
+
+//NewRunner prepares a runner positioned before the start node
+func NewRunner(f *Flow) *Runner {
+	return &Runner{
+		flow: f,
+		vars: make(map[string]string),
+	}
+}
+
+//Flow returns the definition being executed
+func (r *Runner) Flow() *Flow {
+	return r.flow
+}
+
+//Done reports whether a transfer or hangup has been reached
+func (r *Runner) Done() bool {
+	return r.done
+}
+
+//Waiting reports whether the runner is parked on a listen node
+func (r *Runner) Waiting() bool {
+	return !r.done && r.current != nil && r.current.Type == NodeListen
+}
+
+//Var returns a flow variable
+func (r *Runner) Var(name string) string {
+	return r.vars[name]
+}
+
+//Matches returns the match nodes the last Start, Input or NoInput call
+//went through
+func (r *Runner) Matches() []Match {
+	return r.matches
+}
+
+//Start walks from the start node until the flow needs caller input or ends
+func (r *Runner) Start() ([]Step, error) {
+	return r.run(r.flow.Start)
+}
+
+//Input delivers a final recognition result to the listen node being waited on
+func (r *Runner) Input(result string) ([]Step, error) {
+	if r.done {
+		return nil, ErrFinished
+	}
+	if !r.Waiting() {
+		return nil, nil
+	}
+	r.vars[VarResult] = result
+	return r.run(r.current.Next)
+}
+
+//NoInput is called when the listen timeout expires without a final result
+func (r *Runner) NoInput() ([]Step, error) {
+	if r.done {
+		return nil, ErrFinished
+	}
+	if !r.Waiting() {
+		return nil, nil
+	}
+	r.vars[VarResult] = ""
+	next := r.current.NoInput
+	if next == "" {
+		next = r.current.Next
+	}
+	return r.run(next)
+}
+
+func (r *Runner) run(id string) ([]Step, error) {
+	var steps []Step
+	r.matches = nil
+	for i := 0; i < maxSteps; i++ {
+		n := r.flow.Node(id)
+		if n == nil {
+			r.done = true
+			return steps, errors.New("call flow refers to unknown node " + id)
+		}
+		r.current = n
+		switch n.Type {
+		case NodePrompt:
+			steps = append(steps, Step{Type: NodePrompt, NodeID: n.ID, Text: n.Text, BargeIn: n.BargeIn})
+			id = n.Next
+		case NodeListen:
+			steps = append(steps, Step{
+				Type:    NodeListen,
+				NodeID:  n.ID,
+				Timeout: time.Duration(n.Timeout) * time.Second,
+				DTMF:    n.DTMF,
+			})
+			return steps, nil
+		case NodeMatch:
+			id = r.match(n)
+		case NodeBranch:
+			id = r.branch(n)
+		case NodeTransfer:
+			r.done = true
+			steps = append(steps, Step{Type: NodeTransfer, NodeID: n.ID, Target: n.Target, Intent: r.vars[VarIntent]})
+			return steps, nil
+		case NodeHangup:
+			r.done = true
+			steps = append(steps, Step{Type: NodeHangup, NodeID: n.ID, Text: n.Text})
+			return steps, nil
+		default:
+			r.done = true
+			return steps, errors.New("call flow node " + n.ID + " has unknown type " + n.Type)
+		}
+	}
+	r.done = true
+	return steps, errors.New("call flow exceeded step limit")
+}
+
+func (r *Runner) match(n *Node) string {
+	result := strings.ToLower(r.vars[VarResult])
+	for _, it := range n.Intents {
+		for _, p := range it.Phrases {
+			if p != "" && strings.Contains(result, strings.ToLower(p)) {
+				r.vars[VarIntent] = it.Name
+				r.matches = append(r.matches, Match{NodeID: n.ID, Intent: it.Name})
+				return it.Next
+			}
+		}
+	}
+	r.vars[VarIntent] = ""
+	r.matches = append(r.matches, Match{NodeID: n.ID})
+	return n.Default
+}
+
+func (r *Runner) branch(n *Node) string {
+	for _, c := range n.Conditions {
+		v := r.vars[c.Var]
+		if c.Equals != "" && v == c.Equals {
+			return c.Next
+		}
+		if c.Contains != "" && strings.Contains(v, c.Contains) {
+			return c.Next
+		}
+	}
+	return n.Default
+}
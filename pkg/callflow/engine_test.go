@@ -0,0 +1,133 @@
+package callflow
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, data, format string) *Flow {
+	t.Helper()
+	f, err := Parse([]byte(data), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func stepTypes(steps []Step) []string {
+	out := make([]string, len(steps))
+	for i, s := range steps {
+		out[i] = s.Type + ":" + s.NodeID
+	}
+	return out
+}
+
+func sameSteps(t *testing.T, got []Step, want ...string) {
+	t.Helper()
+	types := stepTypes(got)
+	if len(types) != len(want) {
+		t.Fatalf("got steps %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("got steps %v, want %v", types, want)
+		}
+	}
+}
+
+func TestRunnerMatchesIntent(t *testing.T) {
+	r := NewRunner(mustParse(t, bankingJSON, FormatJSON))
+	steps, err := r.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameSteps(t, steps, "prompt:welcome", "listen:ask")
+	if steps[1].Timeout != 5*time.Second || !r.Waiting() {
+		t.Fatalf("listen step %+v, waiting %v", steps[1], r.Waiting())
+	}
+
+	steps, err = r.Input("I want an AGENT please")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameSteps(t, steps, "transfer:agent")
+	if steps[0].Target != "sip:agent@pbx" || steps[0].Intent != "agent" || !r.Done() {
+		t.Fatalf("transfer step %+v, done %v", steps[0], r.Done())
+	}
+	if m := r.Matches(); len(m) != 1 || m[0] != (Match{NodeID: "route", Intent: "agent"}) {
+		t.Fatalf("matches %+v", m)
+	}
+	if _, err := r.Input("again"); err != ErrFinished {
+		t.Fatalf("input after the end: %v", err)
+	}
+}
+
+func TestRunnerDefaultAndNoInput(t *testing.T) {
+	r := NewRunner(mustParse(t, bankingJSON, FormatJSON))
+	r.Start()
+	steps, err := r.Input("something else")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the default of route goes back to listening
+	sameSteps(t, steps, "listen:ask")
+	if r.Var(VarIntent) != "" {
+		t.Fatalf("intent %q after no match", r.Var(VarIntent))
+	}
+	if m := r.Matches(); len(m) != 1 || m[0] != (Match{NodeID: "route"}) {
+		t.Fatalf("matches %+v", m)
+	}
+	steps, err = r.NoInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameSteps(t, steps, "hangup:bye")
+	if !r.Done() || len(r.Matches()) != 0 {
+		t.Fatalf("done %v, matches %+v after hangup", r.Done(), r.Matches())
+	}
+}
+
+func TestRunnerBranch(t *testing.T) {
+	flow := `domain: d
+start: ask
+nodes:
+  - {id: ask, type: listen, next: check}
+  - id: check
+    type: branch
+    default: no
+    conditions:
+      - {var: result, equals: "1", next: yes}
+      - {var: result, contains: yes, next: yes}
+  - {id: yes, type: hangup, text: yes}
+  - {id: no, type: hangup, text: no}`
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1", "hangup:yes"},
+		{"oh yes", "hangup:yes"},
+		{"12", "hangup:no"},
+	}
+	for _, tt := range tests {
+		r := NewRunner(mustParse(t, flow, FormatYAML))
+		r.Start()
+		steps, err := r.Input(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sameSteps(t, steps, tt.want)
+	}
+}
+
+func TestRunnerIgnoresInputWhenNotWaiting(t *testing.T) {
+	flow := `domain: d
+start: a
+nodes:
+  - {id: a, type: prompt, text: hi, next: b}
+  - {id: b, type: hangup}`
+	r := NewRunner(mustParse(t, flow, FormatYAML))
+	steps, err := r.Input("hello")
+	if steps != nil || err != nil {
+		t.Fatalf("input before start: %v %v", steps, err)
+	}
+}
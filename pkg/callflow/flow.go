@@ -0,0 +1,321 @@
+package callflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/dtmf"
+	"github.com/4406arthur/bello/pkg/entity"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Node types
+const (
+	NodePrompt   = "prompt"
+	NodeListen   = "listen"
+	NodeMatch    = "match"
+	NodeBranch   = "branch"
+	NodeTransfer = "transfer"
+	NodeHangup   = "hangup"
+)
+
+// Definition formats accepted by Parse
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Variables set by the engine while a flow runs
+const (
+	VarResult = "result"
+	VarIntent = "intent"
+)
+
+//ErrNotFound returned when a flow or version does not exist
+var ErrNotFound = errors.New("call flow not found")
+
+//ErrVersionTaken returned by Store when the version of a domain exists already
+var ErrVersionTaken = errors.New("call flow version already exists")
+
+//Flow is a declarative call-flow definition bound to a domain
+type Flow struct {
+	Domain    string    `json:"domain" yaml:"domain" bson:"domain"`
+	Version   int       `json:"version" yaml:"version" bson:"version"`
+	Start     string    `json:"start" yaml:"start" bson:"start"`
+	Nodes     []*Node   `json:"nodes" yaml:"nodes" bson:"nodes"`
+	CreatedAt time.Time `json:"created_at" yaml:"-" bson:"created_at"`
+
+	index map[string]*Node
+}
+
+//Node is a single step of a call flow
+type Node struct {
+	ID   string `json:"id" yaml:"id" bson:"id"`
+	Type string `json:"type" yaml:"type" bson:"type"`
+	// prompt, BargeIn lets the caller interrupt it by talking
+	Text    string `json:"text,omitempty" yaml:"text" bson:"text,omitempty"`
+	BargeIn bool   `json:"barge_in,omitempty" yaml:"barge_in" bson:"barge_in,omitempty"`
+	// listen: seconds to wait for a final result, NoInput is taken on timeout
+	// or when keypad input does not satisfy DTMF
+	Timeout int                 `json:"timeout,omitempty" yaml:"timeout" bson:"timeout,omitempty"`
+	NoInput string              `json:"no_input,omitempty" yaml:"no_input" bson:"no_input,omitempty"`
+	DTMF    *entity.DTMFGrammar `json:"dtmf,omitempty" yaml:"dtmf" bson:"dtmf,omitempty"`
+	// match
+	Intents []*Intent `json:"intents,omitempty" yaml:"intents" bson:"intents,omitempty"`
+	// branch
+	Conditions []*Condition `json:"conditions,omitempty" yaml:"conditions" bson:"conditions,omitempty"`
+	// transfer
+	Target string `json:"target,omitempty" yaml:"target" bson:"target,omitempty"`
+	// match and branch fall back to Default when nothing applies
+	Default string `json:"default,omitempty" yaml:"default" bson:"default,omitempty"`
+	Next    string `json:"next,omitempty" yaml:"next" bson:"next,omitempty"`
+}
+
+//Intent maps recognized phrases to the next node
+type Intent struct {
+	Name    string   `json:"name" yaml:"name" bson:"name"`
+	Phrases []string `json:"phrases" yaml:"phrases" bson:"phrases"`
+	Next    string   `json:"next" yaml:"next" bson:"next"`
+}
+
+//Condition compares a flow variable, exactly one of Equals or Contains is used
+type Condition struct {
+	Var      string `json:"var" yaml:"var" bson:"var"`
+	Equals   string `json:"equals,omitempty" yaml:"equals" bson:"equals,omitempty"`
+	Contains string `json:"contains,omitempty" yaml:"contains" bson:"contains,omitempty"`
+	Next     string `json:"next" yaml:"next" bson:"next"`
+}
+
+//ValidationError collects every problem found in a flow definition
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid call flow: " + strings.Join(e.Problems, "; ")
+}
+
+//Parse decodes a flow definition in the given format and validates it. A
+//definition that cannot be decoded is a ValidationError as well.
+func Parse(data []byte, format string) (*Flow, error) {
+	f := &Flow{}
+	var err error
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		//unknown fields are refused as the YAML decoder does
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(f)
+	case FormatYAML, "yml":
+		err = yaml.UnmarshalStrict(data, f)
+	default:
+		return nil, &ValidationError{Problems: []string{fmt.Sprintf("unsupported call flow format %q", format)}}
+	}
+	if err != nil {
+		return nil, &ValidationError{Problems: []string{err.Error()}}
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+//Node returns the node with the given id or nil
+func (f *Flow) Node(id string) *Node {
+	if f.index == nil {
+		f.buildIndex()
+	}
+	return f.index[id]
+}
+
+func (f *Flow) buildIndex() {
+	f.index = make(map[string]*Node, len(f.Nodes))
+	for _, n := range f.Nodes {
+		if n != nil {
+			f.index[n.ID] = n
+		}
+	}
+}
+
+//Validate checks node references, per-type required fields and loops
+func (f *Flow) Validate() error {
+	var problems []string
+	report := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if f.Domain == "" {
+		report("domain is required")
+	}
+	if len(f.Nodes) == 0 {
+		report("at least one node is required")
+	}
+
+	f.index = make(map[string]*Node, len(f.Nodes))
+	for i, n := range f.Nodes {
+		if n == nil {
+			report("node #%d is empty", i)
+			continue
+		}
+		if n.ID == "" {
+			report("node #%d has no id", i)
+			continue
+		}
+		if _, dup := f.index[n.ID]; dup {
+			report("node %q is defined more than once", n.ID)
+			continue
+		}
+		f.index[n.ID] = n
+	}
+
+	if f.Start == "" {
+		report("start is required")
+	} else if f.index[f.Start] == nil {
+		report("start node %q does not exist", f.Start)
+	}
+
+	ref := func(n *Node, field, target string, required bool) {
+		if target == "" {
+			if required {
+				report("node %q: %s is required", n.ID, field)
+			}
+			return
+		}
+		if f.index[target] == nil {
+			report("node %q: %s refers to unknown node %q", n.ID, field, target)
+		}
+	}
+
+	for _, n := range f.Nodes {
+		if n == nil || f.index[n.ID] != n {
+			continue
+		}
+		switch n.Type {
+		case NodePrompt:
+			if n.Text == "" {
+				report("node %q: text is required", n.ID)
+			}
+			ref(n, "next", n.Next, true)
+		case NodeListen:
+			if n.Timeout < 0 {
+				report("node %q: timeout must not be negative", n.ID)
+			}
+			if err := dtmf.ValidateGrammar(n.DTMF); err != nil {
+				report("node %q: %s", n.ID, err.Error())
+			}
+			ref(n, "next", n.Next, true)
+			ref(n, "no_input", n.NoInput, false)
+		case NodeMatch:
+			if len(n.Intents) == 0 {
+				report("node %q: at least one intent is required", n.ID)
+			}
+			for i, it := range n.Intents {
+				if it == nil || it.Name == "" {
+					report("node %q: intent #%d has no name", n.ID, i)
+					continue
+				}
+				if len(it.Phrases) == 0 {
+					report("node %q: intent %q has no phrases", n.ID, it.Name)
+				}
+				ref(n, "intent "+it.Name+" next", it.Next, true)
+			}
+			ref(n, "default", n.Default, true)
+		case NodeBranch:
+			if len(n.Conditions) == 0 {
+				report("node %q: at least one condition is required", n.ID)
+			}
+			for i, c := range n.Conditions {
+				if c == nil || c.Var == "" {
+					report("node %q: condition #%d has no var", n.ID, i)
+					continue
+				}
+				if (c.Equals == "") == (c.Contains == "") {
+					report("node %q: condition #%d needs exactly one of equals or contains", n.ID, i)
+				}
+				ref(n, fmt.Sprintf("condition #%d next", i), c.Next, true)
+			}
+			ref(n, "default", n.Default, true)
+		case NodeTransfer:
+			if n.Target == "" {
+				report("node %q: target is required", n.ID)
+			}
+		case NodeHangup:
+		case "":
+			report("node %q: type is required", n.ID)
+		default:
+			report("node %q: unknown type %q", n.ID, n.Type)
+		}
+	}
+
+	if len(problems) == 0 {
+		if loop := f.findSilentLoop(); loop != "" {
+			report("node %q is part of a loop without a listen node", loop)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// findSilentLoop looks for a cycle the engine could spin in forever because
+// no node on it waits for caller input.
+func (f *Flow) findSilentLoop() string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(f.index))
+	var visit func(id string) string
+	visit = func(id string) string {
+		n := f.index[id]
+		if n == nil || n.Type == NodeListen {
+			return ""
+		}
+		switch state[id] {
+		case visiting:
+			return id
+		case visited:
+			return ""
+		}
+		state[id] = visiting
+		for _, next := range n.successors() {
+			if loop := visit(next); loop != "" {
+				return loop
+			}
+		}
+		state[id] = visited
+		return ""
+	}
+	for _, n := range f.Nodes {
+		if loop := visit(n.ID); loop != "" {
+			return loop
+		}
+	}
+	return ""
+}
+
+func (n *Node) successors() []string {
+	var out []string
+	add := func(id string) {
+		if id != "" {
+			out = append(out, id)
+		}
+	}
+	add(n.Next)
+	add(n.NoInput)
+	add(n.Default)
+	for _, it := range n.Intents {
+		add(it.Next)
+	}
+	for _, c := range n.Conditions {
+		add(c.Next)
+	}
+	return out
+}
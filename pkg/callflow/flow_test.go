@@ -0,0 +1,126 @@
+package callflow
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const bankingJSON = `{
+	"domain": "banking",
+	"start": "welcome",
+	"nodes": [
+		{"id": "welcome", "type": "prompt", "text": "hello", "next": "ask"},
+		{"id": "ask", "type": "listen", "timeout": 5, "no_input": "bye", "next": "route"},
+		{"id": "route", "type": "match", "default": "ask",
+			"intents": [{"name": "agent", "phrases": ["agent"], "next": "agent"}]},
+		{"id": "agent", "type": "transfer", "target": "sip:agent@pbx"},
+		{"id": "bye", "type": "hangup", "text": "bye"}
+	]
+}`
+
+func TestParseExample(t *testing.T) {
+	data, err := ioutil.ReadFile("../../doc/callflow.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Parse(data, FormatYAML)
+	if err != nil {
+		t.Fatalf("example flow: %v", err)
+	}
+	if f.Domain != "banking" || f.Node("ask") == nil {
+		t.Fatalf("example flow decoded as %+v", f)
+	}
+}
+
+func TestParseRejectsUnknownFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+	}{
+		{"json", FormatJSON, strings.Replace(bankingJSON, `"timeout": 5`, `"timout": 5`, 1)},
+		{"yaml", FormatYAML, "domain: d\nstart: a\nnodes:\n  - id: a\n    type: hangup\n    timout: 5\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.data), tt.format); err == nil {
+				t.Fatal("a misspelled field was accepted")
+			} else if _, ok := err.(*ValidationError); !ok {
+				t.Fatalf("got %T", err)
+			}
+		})
+	}
+	if _, err := Parse([]byte(bankingJSON), FormatJSON); err != nil {
+		t.Fatalf("valid JSON flow: %v", err)
+	}
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	if _, err := Parse([]byte(bankingJSON), "xml"); err == nil {
+		t.Fatal("xml was accepted")
+	} else if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("got %T", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		flow    string
+		problem string
+	}{
+		{"no domain", `start: a
+nodes: [{id: a, type: hangup}]`, "domain is required"},
+		{"no start", `domain: d
+nodes: [{id: a, type: hangup}]`, "start is required"},
+		{"unknown start", `domain: d
+start: b
+nodes: [{id: a, type: hangup}]`, `start node "b" does not exist`},
+		{"duplicate id", `domain: d
+start: a
+nodes: [{id: a, type: hangup}, {id: a, type: hangup}]`, `node "a" is defined more than once`},
+		{"unknown next", `domain: d
+start: a
+nodes: [{id: a, type: prompt, text: hi, next: b}]`, `next refers to unknown node "b"`},
+		{"prompt without text", `domain: d
+start: a
+nodes: [{id: a, type: prompt, next: b}, {id: b, type: hangup}]`, `node "a": text is required`},
+		{"unknown type", `domain: d
+start: a
+nodes: [{id: a, type: dance}]`, `unknown type "dance"`},
+		{"condition with both", `domain: d
+start: a
+nodes:
+  - {id: a, type: branch, default: b, conditions: [{var: result, equals: x, contains: y, next: b}]}
+  - {id: b, type: hangup}`, "exactly one of equals or contains"},
+		{"silent loop", `domain: d
+start: a
+nodes:
+  - {id: a, type: prompt, text: hi, next: b}
+  - {id: b, type: prompt, text: again, next: a}`, "loop without a listen node"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.flow), FormatYAML)
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("got %v, want a validation error", err)
+			}
+			if !strings.Contains(verr.Error(), tt.problem) {
+				t.Fatalf("got %q, want it to mention %q", verr.Error(), tt.problem)
+			}
+		})
+	}
+}
+
+func TestLoopThroughListenIsAllowed(t *testing.T) {
+	flow := `domain: d
+start: a
+nodes:
+  - {id: a, type: prompt, text: hi, next: b}
+  - {id: b, type: listen, next: a}`
+	if _, err := Parse([]byte(flow), FormatYAML); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,147 @@
+package callflow
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const mongoTimeout = 5 * time.Second
+
+type mongoRepository struct {
+	flows  *mongo.Collection
+	active *mongo.Collection
+}
+
+type activeVersion struct {
+	Domain  string `bson:"_id"`
+	Version int    `bson:"version"`
+}
+
+// duplicateKey is the server error code of a unique index violation
+const duplicateKey = 11000
+
+//NewMongoRepository stores flow versions in the callflows collection of db.
+//A unique index on domain and version keeps replicas from storing the same
+//version twice.
+func NewMongoRepository(db *mongo.Database) (Repository, error) {
+	r := &mongoRepository{
+		flows:  db.Collection("callflows"),
+		active: db.Collection("callflows_active"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err := r.flows.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "domain", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *mongoRepository) Store(f *Flow) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err := r.flows.InsertOne(ctx, f)
+	if isDuplicateKey(err) {
+		return ErrVersionTaken
+	}
+	return err
+}
+
+func isDuplicateKey(err error) bool {
+	we, ok := err.(mongo.WriteException)
+	if !ok {
+		return false
+	}
+	for _, e := range we.WriteErrors {
+		if e.Code == duplicateKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *mongoRepository) Get(domain string, version int) (*Flow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	f := &Flow{}
+	err := r.flows.FindOne(ctx, bson.M{"domain": domain, "version": version}).Decode(f)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.buildIndex()
+	return f, nil
+}
+
+func (r *mongoRepository) Versions(domain string) ([]*Flow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	cur, err := r.flows.Find(ctx, bson.M{"domain": domain}, options.Find().SetSort(bson.M{"version": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var out []*Flow
+	for cur.Next(ctx) {
+		f := &Flow{}
+		if err := cur.Decode(f); err != nil {
+			return nil, err
+		}
+		f.buildIndex()
+		out = append(out, f)
+	}
+	return out, cur.Err()
+}
+
+func (r *mongoRepository) Domains() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	values, err := r.flows.Distinct(ctx, "domain", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (r *mongoRepository) SetActive(domain string, version int) error {
+	if _, err := r.Get(domain, version); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err := r.active.ReplaceOne(ctx,
+		bson.M{"_id": domain},
+		activeVersion{Domain: domain, Version: version},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *mongoRepository) Active(domain string) (*Flow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	a := activeVersion{}
+	err := r.active.FindOne(ctx, bson.M{"_id": domain}).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(domain, a.Version)
+}
@@ -0,0 +1,96 @@
+package callflow
+
+import (
+	"sort"
+	"sync"
+)
+
+//Repository persists every version of every flow and which one is active
+type Repository interface {
+	//Store fails with ErrVersionTaken when the version exists already
+	Store(f *Flow) error
+	Get(domain string, version int) (*Flow, error)
+	Versions(domain string) ([]*Flow, error)
+	Domains() ([]string, error)
+	SetActive(domain string, version int) error
+	Active(domain string) (*Flow, error)
+}
+
+type memoryRepository struct {
+	mu     sync.RWMutex
+	flows  map[string][]*Flow
+	active map[string]int
+}
+
+//NewMemoryRepository keeps flows in process memory, used when no database is configured
+func NewMemoryRepository() Repository {
+	return &memoryRepository{
+		flows:  make(map[string][]*Flow),
+		active: make(map[string]int),
+	}
+}
+
+func (r *memoryRepository) Store(f *Flow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.flows[f.Domain] {
+		if v.Version == f.Version {
+			return ErrVersionTaken
+		}
+	}
+	r.flows[f.Domain] = append(r.flows[f.Domain], f)
+	return nil
+}
+
+func (r *memoryRepository) Get(domain string, version int) (*Flow, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, f := range r.flows[domain] {
+		if f.Version == version {
+			return f, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryRepository) Versions(domain string) ([]*Flow, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]*Flow, len(r.flows[domain]))
+	copy(out, r.flows[domain])
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func (r *memoryRepository) Domains() ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.flows))
+	for d := range r.flows {
+		out = append(out, d)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (r *memoryRepository) SetActive(domain string, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.flows[domain] {
+		if f.Version == version {
+			r.active[domain] = version
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryRepository) Active(domain string) (*Flow, error) {
+	r.mu.RLock()
+	version, ok := r.active[domain]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r.Get(domain, version)
+}
@@ -0,0 +1,93 @@
+package callflow
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+//Service validates, versions and looks up call flows
+type Service struct {
+	repo   Repository
+	logger logger.Logger
+	mu     sync.Mutex
+}
+
+//NewService ...
+func NewService(repo Repository, log logger.Logger) *Service {
+	return &Service{
+		repo:   repo,
+		logger: log,
+	}
+}
+
+// uploadAttempts bounds how often Upload picks the next version again when
+// another replica stored it first
+const uploadAttempts = 5
+
+//Upload parses a definition, stores it as the next version of its domain
+//and makes it the active one
+func (s *Service) Upload(data []byte, format string) (*Flow, error) {
+	f, err := Parse(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for attempt := 1; ; attempt++ {
+		versions, err := s.repo.Versions(f.Domain)
+		if err != nil {
+			return nil, err
+		}
+		f.Version = 1
+		if n := len(versions); n > 0 {
+			f.Version = versions[n-1].Version + 1
+		}
+		f.CreatedAt = time.Now().UTC()
+		err = s.repo.Store(f)
+		if err == nil {
+			break
+		}
+		if err != ErrVersionTaken || attempt == uploadAttempts {
+			return nil, err
+		}
+	}
+	if err := s.repo.SetActive(f.Domain, f.Version); err != nil {
+		return nil, err
+	}
+	s.logger.Info("N", logger.Trace(), fmt.Sprintf("call flow %s version %d activated", f.Domain, f.Version))
+	return f, nil
+}
+
+//Activate switches a domain to a previously uploaded version
+func (s *Service) Activate(domain string, version int) error {
+	if err := s.repo.SetActive(domain, version); err != nil {
+		return err
+	}
+	s.logger.Info("N", logger.Trace(), "call flow "+domain+" version "+strconv.Itoa(version)+" activated")
+	return nil
+}
+
+//Active returns the flow currently used for a domain
+func (s *Service) Active(domain string) (*Flow, error) {
+	return s.repo.Active(domain)
+}
+
+//Get returns a specific version
+func (s *Service) Get(domain string, version int) (*Flow, error) {
+	return s.repo.Get(domain, version)
+}
+
+//Versions lists every stored version of a domain
+func (s *Service) Versions(domain string) ([]*Flow, error) {
+	return s.repo.Versions(domain)
+}
+
+//Domains lists every domain with at least one flow
+func (s *Service) Domains() ([]string, error) {
+	return s.repo.Domains()
+}
@@ -0,0 +1,127 @@
+package callflow
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+func newTestService(t *testing.T, repo Repository) *Service {
+	t.Helper()
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(repo, log)
+}
+
+func TestUploadVersions(t *testing.T) {
+	s := newTestService(t, NewMemoryRepository())
+	for want := 1; want <= 3; want++ {
+		f, err := s.Upload([]byte(bankingJSON), FormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Version != want {
+			t.Fatalf("got version %d, want %d", f.Version, want)
+		}
+	}
+	active, err := s.Active("banking")
+	if err != nil || active.Version != 3 {
+		t.Fatalf("active %+v, %v", active, err)
+	}
+	if err := s.Activate("banking", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Activate("banking", 9); err != ErrNotFound {
+		t.Fatalf("activating a missing version: %v", err)
+	}
+}
+
+func TestMemoryStoreRefusesTakenVersion(t *testing.T) {
+	repo := NewMemoryRepository()
+	f := mustParse(t, bankingJSON, FormatJSON)
+	f.Version = 1
+	if err := repo.Store(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Store(f); err != ErrVersionTaken {
+		t.Fatalf("storing version 1 twice: %v", err)
+	}
+}
+
+// racingRepository stores a version of its own right before every Store,
+// as another replica would
+type racingRepository struct {
+	Repository
+	races int
+}
+
+func (r *racingRepository) Store(f *Flow) error {
+	if r.races > 0 {
+		r.races--
+		other := *f
+		if err := r.Repository.Store(&other); err != nil {
+			return err
+		}
+	}
+	return r.Repository.Store(f)
+}
+
+func TestUploadRetriesTakenVersion(t *testing.T) {
+	repo := &racingRepository{Repository: NewMemoryRepository(), races: 2}
+	s := newTestService(t, repo)
+	f, err := s.Upload([]byte(bankingJSON), FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Version != 3 {
+		t.Fatalf("got version %d after two lost races, want 3", f.Version)
+	}
+}
+
+func TestUploadGivesUp(t *testing.T) {
+	repo := &racingRepository{Repository: NewMemoryRepository(), races: uploadAttempts}
+	s := newTestService(t, repo)
+	if _, err := s.Upload([]byte(bankingJSON), FormatJSON); err != ErrVersionTaken {
+		t.Fatalf("got %v, want ErrVersionTaken", err)
+	}
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	//two services share a repository like two replicas share Mongo
+	repo := NewMemoryRepository()
+	a, b := newTestService(t, repo), newTestService(t, repo)
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		for _, s := range []*Service{a, b} {
+			wg.Add(1)
+			go func(s *Service) {
+				defer wg.Done()
+				_, err := s.Upload([]byte(bankingJSON), FormatJSON)
+				errs <- err
+			}(s)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	stored := 0
+	for err := range errs {
+		if err == nil {
+			stored++
+		} else if err != ErrVersionTaken {
+			t.Fatal(err)
+		}
+	}
+	versions, _ := repo.Versions("banking")
+	if len(versions) != stored {
+		t.Fatalf("%d uploads succeeded but %d versions stored", stored, len(versions))
+	}
+	for i, f := range versions {
+		if f.Version != i+1 {
+			t.Fatalf("versions are not 1..%d: %v", len(versions), versions)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+//Package config holds the typed configuration of bello. It is read from
+//config.json, config.yaml or config.toml and every value can be overridden
+//by an environment variable: nats_config.host becomes BELLO_NATS_HOST,
+//recording_config.s3.bucket becomes BELLO_RECORDING_S3_BUCKET.
+package config
+
+import (
+	"time"
+
+	"github.com/4406arthur/bello/pkg/auth"
+	"github.com/4406arthur/bello/pkg/quota"
+	"github.com/4406arthur/bello/pkg/recording"
+	"github.com/4406arthur/bello/pkg/redact"
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+//Config is the whole configuration, sections keep the names of the
+//configuration file
+type Config struct {
+	Server     Server     `json:"server_config" mapstructure:"server_config"`
+	NATS       NATS       `json:"nats_config" mapstructure:"nats_config"`
+	Redis      Redis      `json:"redis_config" mapstructure:"redis_config"`
+	Mongo      Mongo      `json:"mongo_config" mapstructure:"mongo_config"`
+	Auth       Auth       `json:"auth_config" mapstructure:"auth_config"`
+	Admin      Admin      `json:"admin_config" mapstructure:"admin_config"`
+	Quota      Quota      `json:"quota_config" mapstructure:"quota_config"`
+	Audit      Audit      `json:"audit_config" mapstructure:"audit_config"`
+	Redact     Redact     `json:"redact_config" mapstructure:"redact_config"`
+	Encryption Encryption `json:"encryption_config" mapstructure:"encryption_config"`
+	Recording  Recording  `json:"recording_config" mapstructure:"recording_config"`
+	Tracing    Tracing    `json:"tracing_config" mapstructure:"tracing_config"`
+	Log        Log        `json:"log_config" mapstructure:"log_config"`
+}
+
+// Audio overflow policies
+const (
+	AudioDropOldest = "drop_oldest"
+	AudioAbort      = "abort"
+)
+
+//Server is the listener, logging and WebSocket tuning
+type Server struct {
+	ListenAddr string `json:"listen_addr" mapstructure:"listen_addr"`
+	LogLevel   string `json:"log_level" mapstructure:"log_level"`
+	//LogPath and ElasticsearchEndpoint are the log sinks when log_config
+	//lists none
+	LogPath               string `json:"log_path" mapstructure:"log_path"`
+	ElasticsearchEndpoint string `json:"elasticsearch_endpoint" mapstructure:"elasticsearch_endpoint"`
+
+	//IdleTimeout closes sessions that see no traffic, TTSChunkTimeout gives
+	//up on a prompt when the TTS worker stalls and STTSetupTimeout on a
+	//start no STT worker acknowledged
+	IdleTimeout     time.Duration `json:"idle_timeout" mapstructure:"idle_timeout"`
+	TTSChunkTimeout time.Duration `json:"tts_chunk_timeout" mapstructure:"tts_chunk_timeout"`
+	STTSetupTimeout time.Duration `json:"stt_setup_timeout" mapstructure:"stt_setup_timeout"`
+
+	//WebSocket clients are pinged every WSPingInterval and closed when no
+	//pong or frame comes within WSPongTimeout more, or when a frame to them
+	//waits longer than WSWriteTimeout
+	WSPingInterval time.Duration `json:"ws_ping_interval" mapstructure:"ws_ping_interval"`
+	WSPongTimeout  time.Duration `json:"ws_pong_timeout" mapstructure:"ws_pong_timeout"`
+	WSWriteTimeout time.Duration `json:"ws_write_timeout" mapstructure:"ws_write_timeout"`
+
+	//a session queues AudioBuffer frames of caller audio for NATS, once
+	//they are all taken AudioOverflow drops the oldest (drop_oldest) or ends
+	//the session (abort). ResultBuffer STT messages wait for the client, a
+	//client leaving them all taken for SlowClientTimeout is closed.
+	AudioBuffer       int           `json:"audio_buffer" mapstructure:"audio_buffer"`
+	AudioOverflow     string        `json:"audio_overflow" mapstructure:"audio_overflow"`
+	ResultBuffer      int           `json:"result_buffer" mapstructure:"result_buffer"`
+	SlowClientTimeout time.Duration `json:"slow_client_timeout" mapstructure:"slow_client_timeout"`
+
+	//plain HTTP requests, the admin API has timeouts of its own and
+	//WebSocket upgrades have none
+	ReadHeaderTimeout time.Duration `json:"read_header_timeout" mapstructure:"read_header_timeout"`
+	HTTPReadTimeout   time.Duration `json:"http_read_timeout" mapstructure:"http_read_timeout"`
+	HTTPWriteTimeout  time.Duration `json:"http_write_timeout" mapstructure:"http_write_timeout"`
+	HTTPIdleTimeout   time.Duration `json:"http_idle_timeout" mapstructure:"http_idle_timeout"`
+
+	//barge-in speech detector, handler defaults when zero
+	BargeInThreshold float64 `json:"barge_in_threshold" mapstructure:"barge_in_threshold"`
+	BargeInFrames    int     `json:"barge_in_frames" mapstructure:"barge_in_frames"`
+
+	AllowedOrigins    []string `json:"allowed_origins" mapstructure:"allowed_origins"`
+	Subprotocols      []string `json:"subprotocols" mapstructure:"subprotocols"`
+	ReadBufferSize    int      `json:"read_buffer_size" mapstructure:"read_buffer_size"`
+	WriteBufferSize   int      `json:"write_buffer_size" mapstructure:"write_buffer_size"`
+	EnableCompression bool     `json:"enable_compression" mapstructure:"enable_compression"`
+	MaxMessageSize    int64    `json:"max_message_size" mapstructure:"max_message_size"`
+
+	//TLS is served when both Cert and Key are set
+	Cert               string        `json:"cert" mapstructure:"cert"`
+	Key                string        `json:"key" mapstructure:"key"`
+	ClientCA           string        `json:"client_ca" mapstructure:"client_ca"`
+	RequireClientCert  bool          `json:"require_client_cert" mapstructure:"require_client_cert"`
+	TLSMinVersion      string        `json:"tls_min_version" mapstructure:"tls_min_version"`
+	CipherSuites       []string      `json:"cipher_suites" mapstructure:"cipher_suites"`
+	CertReloadInterval time.Duration `json:"cert_reload_interval" mapstructure:"cert_reload_interval"`
+
+	//on SIGTERM /readyz fails for ShutdownDelay before the listener closes,
+	//live sessions then get ShutdownTimeout to end before they are closed
+	ShutdownDelay   time.Duration `json:"shutdown_delay" mapstructure:"shutdown_delay"`
+	ShutdownTimeout time.Duration `json:"shutdown_timeout" mapstructure:"shutdown_timeout"`
+}
+
+//TLS reports whether the listener serves TLS
+func (s Server) TLS() bool {
+	return s.Cert != "" && s.Key != ""
+}
+
+//NATS is the connection to the speech workers
+type NATS struct {
+	Host          string `json:"host" mapstructure:"host"`
+	ConnNumber    int    `json:"conn_number" mapstructure:"conn_number"`
+	SubjectNumber int    `json:"subject_number" mapstructure:"subject_number"`
+	TTSSubject    string `json:"tts_subject" mapstructure:"tts_subject"`
+	//STT workers answer requests on STTHealthSubject, /readyz fails when
+	//none does. Empty skips the check.
+	STTHealthSubject string `json:"stt_health_subject" mapstructure:"stt_health_subject"`
+}
+
+//Redis is kept for existing deployments, only /readyz checks it is up
+type Redis struct {
+	Host string `json:"host" mapstructure:"host"`
+	DB   int    `json:"db" mapstructure:"db"`
+}
+
+//Mongo stores call flows, grammars and audit records, in-memory stores are
+//used when Endpoint is empty
+type Mongo struct {
+	Endpoint string `json:"endpoint" mapstructure:"endpoint"`
+	Database string `json:"database" mapstructure:"database"`
+}
+
+//Auth is how WebSocket clients prove who they are, clients stay anonymous
+//when nothing is set
+type Auth struct {
+	Timeout     time.Duration `json:"timeout" mapstructure:"timeout"`
+	JWKSFile    string        `json:"jwks_file" mapstructure:"jwks_file"`
+	JWTIssuer   string        `json:"jwt_issuer" mapstructure:"jwt_issuer"`
+	JWTAudience string        `json:"jwt_audience" mapstructure:"jwt_audience"`
+	JWTLeeway   time.Duration `json:"jwt_leeway" mapstructure:"jwt_leeway"`
+	HMACSecrets []string      `json:"hmac_secrets" mapstructure:"hmac_secrets"`
+	//APIKeys maps client names to keys
+	APIKeys map[string]string `json:"api_keys" mapstructure:"api_keys"`
+	//ClientCerts names the client certificates, verified against
+	//server_config.client_ca, that authenticate without a token
+	ClientCerts []auth.ClientCert `json:"client_certs" mapstructure:"client_certs"`
+}
+
+//Admin is how admin API callers prove who they are and which roles they
+//hold, the admin API is not served when nothing is set
+type Admin struct {
+	JWKSFile      string        `json:"jwks_file" mapstructure:"jwks_file"`
+	JWTIssuer     string        `json:"jwt_issuer" mapstructure:"jwt_issuer"`
+	JWTAudience   string        `json:"jwt_audience" mapstructure:"jwt_audience"`
+	JWTLeeway     time.Duration `json:"jwt_leeway" mapstructure:"jwt_leeway"`
+	JWTRolesClaim string        `json:"jwt_roles_claim" mapstructure:"jwt_roles_claim"`
+	APIKeys       []auth.APIKey `json:"api_keys" mapstructure:"api_keys"`
+	//bound reading an admin request and writing its response
+	ReadTimeout  time.Duration `json:"read_timeout" mapstructure:"read_timeout"`
+	WriteTimeout time.Duration `json:"write_timeout" mapstructure:"write_timeout"`
+}
+
+//Quota are the client limits at startup, the admin API changes them later
+type Quota struct {
+	Default quota.Limits `json:"default" mapstructure:"default"`
+	Rules   []quota.Rule `json:"rules" mapstructure:"rules"`
+}
+
+// Audit sinks
+const (
+	AuditLogger        = ""
+	AuditFile          = "file"
+	AuditMongo         = "mongo"
+	AuditElasticsearch = "elasticsearch"
+)
+
+//Audit is where the audit trail is written
+type Audit struct {
+	Sink     string `json:"sink" mapstructure:"sink"`
+	Path     string `json:"path" mapstructure:"path"`
+	Endpoint string `json:"endpoint" mapstructure:"endpoint"`
+	Index    string `json:"index" mapstructure:"index"`
+}
+
+//Redact filters personal data out of logs and audit records, every
+//built-in rule applies unless Rules narrows them down
+type Redact struct {
+	Disabled bool             `json:"disabled" mapstructure:"disabled"`
+	Rules    []string         `json:"rules" mapstructure:"rules"`
+	Patterns []redact.Pattern `json:"patterns" mapstructure:"patterns"`
+}
+
+//Encryption encrypts recordings and the audit file at rest when Keyfile
+//is set
+type Encryption struct {
+	Keyfile string `json:"keyfile" mapstructure:"keyfile"`
+}
+
+// Recording stores and modes
+const (
+	StoreLocal = "local"
+	StoreS3    = "s3"
+
+	RecordAlways    = "always"
+	RecordOnRequest = "on_request"
+)
+
+//Recording is where and when call audio is recorded
+type Recording struct {
+	Enabled   bool               `json:"enabled" mapstructure:"enabled"`
+	Store     string             `json:"store" mapstructure:"store"`
+	Dir       string             `json:"dir" mapstructure:"dir"`
+	S3        recording.S3Config `json:"s3" mapstructure:"s3"`
+	TmpDir    string             `json:"tmp_dir" mapstructure:"tmp_dir"`
+	Retention time.Duration      `json:"retention" mapstructure:"retention"`
+	Mode      string             `json:"mode" mapstructure:"mode"`
+}
+
+//Tracing is where OpenTelemetry spans are exported: "otlp" sends them to
+//the OTLP/HTTP collector at Endpoint (host:port), "stdout" prints them
+type Tracing struct {
+	Exporter    string  `json:"exporter" mapstructure:"exporter"`
+	Endpoint    string  `json:"endpoint" mapstructure:"endpoint"`
+	Insecure    bool    `json:"insecure" mapstructure:"insecure"`
+	ServiceName string  `json:"service_name" mapstructure:"service_name"`
+	SampleRatio float64 `json:"sample_ratio" mapstructure:"sample_ratio"`
+}
+
+//Log lists where log lines go, every sink has a level of its own below
+//which it skips lines. server_config.log_level comes first: lines below it
+//reach no sink.
+type Log struct {
+	Sinks []logger.SinkConfig `json:"sinks" mapstructure:"sinks"`
+}
+
+//LogSinks are the configured sinks, or the file and Elasticsearch sinks of
+//server_config when there are none
+func (cfg *Config) LogSinks() []logger.SinkConfig {
+	if len(cfg.Log.Sinks) > 0 {
+		return cfg.Log.Sinks
+	}
+	return logger.LegacySinks(cfg.Server.LogPath, cfg.Server.ElasticsearchEndpoint)
+}
+
+//Default is the configuration before the file and the environment are
+//applied
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			ListenAddr:         ":8080",
+			LogLevel:           "debug",
+			IdleTimeout:        60 * time.Second,
+			TTSChunkTimeout:    5 * time.Second,
+			STTSetupTimeout:    5 * time.Second,
+			WSPingInterval:     20 * time.Second,
+			WSPongTimeout:      10 * time.Second,
+			WSWriteTimeout:     10 * time.Second,
+			AudioBuffer:        30,
+			AudioOverflow:      AudioDropOldest,
+			ResultBuffer:       30,
+			SlowClientTimeout:  5 * time.Second,
+			ReadHeaderTimeout:  10 * time.Second,
+			HTTPReadTimeout:    10 * time.Second,
+			HTTPWriteTimeout:   10 * time.Second,
+			HTTPIdleTimeout:    2 * time.Minute,
+			MaxMessageSize:     1 << 20,
+			TLSMinVersion:      "1.2",
+			CertReloadInterval: 30 * time.Second,
+			ShutdownDelay:      5 * time.Second,
+			ShutdownTimeout:    30 * time.Second,
+		},
+		NATS: NATS{
+			ConnNumber:       1,
+			SubjectNumber:    1,
+			TTSSubject:       "tts",
+			STTHealthSubject: "stt.health",
+		},
+		Mongo: Mongo{
+			Database: "bello",
+		},
+		Auth: Auth{
+			Timeout: 10 * time.Second,
+		},
+		Admin: Admin{
+			JWTRolesClaim: "roles",
+			ReadTimeout:   30 * time.Second,
+			WriteTimeout:  time.Minute,
+		},
+		Audit: Audit{
+			Index: "bello-audit",
+		},
+		Recording: Recording{
+			Store: StoreLocal,
+			Dir:   "recordings",
+			S3:    recording.S3Config{Region: "us-east-1"},
+			Mode:  RecordAlways,
+		},
+		Tracing: Tracing{
+			ServiceName: "bello",
+			SampleRatio: 1,
+		},
+	}
+}
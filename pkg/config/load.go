@@ -0,0 +1,124 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix starts every environment override
+const EnvPrefix = "BELLO"
+
+//Load reads the configuration at path, a file or a directory holding
+//config.json, config.yaml or config.toml (./config/ when empty). Defaults
+//are applied first, then the file, then the environment. The result is
+//validated, a *ValidationError lists every invalid field.
+func Load(path string) (*Config, error) {
+	return NewLoader(path).Load()
+}
+
+//Loader reads the configuration again and again from the same place
+type Loader struct {
+	path string
+}
+
+//NewLoader ...
+func NewLoader(path string) *Loader {
+	return &Loader{path: path}
+}
+
+func (l *Loader) viper() *viper.Viper {
+	v := viper.New()
+	if info, err := os.Stat(l.path); err == nil && !info.IsDir() {
+		v.SetConfigFile(l.path)
+	} else {
+		v.SetConfigName("config")
+		path := l.path
+		if path == "" {
+			path = "./config/"
+		}
+		v.AddConfigPath(path)
+	}
+	return v
+}
+
+//Watch calls changed whenever the configuration file is written, it returns
+//the file being watched
+func (l *Loader) Watch(changed func()) (string, error) {
+	//a viper of its own, loads never share state with the watcher
+	v := l.viper()
+	if err := v.ReadInConfig(); err != nil {
+		return "", err
+	}
+	v.OnConfigChange(func(fsnotify.Event) {
+		changed()
+	})
+	v.WatchConfig()
+	return v.ConfigFileUsed(), nil
+}
+
+//Load reads and validates the configuration
+func (l *Loader) Load() (*Config, error) {
+	v := l.viper()
+	v.SetEnvPrefix(EnvPrefix)
+	//viper upper-cases the key before replacing
+	v.SetEnvKeyReplacer(strings.NewReplacer("_CONFIG.", "_", ".", "_"))
+	v.AutomaticEnv()
+	setDefaults(v, "", reflect.ValueOf(Default()).Elem())
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	problems := []string{}
+	err := v.Unmarshal(cfg, func(dc *mapstructure.DecoderConfig) {
+		//a misspelt key is an error rather than a silent zero value
+		dc.ErrorUnused = true
+	})
+	if err != nil {
+		if merr, ok := err.(*mapstructure.Error); ok {
+			problems = append(problems, merr.Errors...)
+		} else {
+			problems = append(problems, err.Error())
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err.(*ValidationError).Problems...)
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+//setDefaults registers every plain field of the default configuration with
+//viper, registered keys are the ones environment variables can override
+func setDefaults(v *viper.Viper, prefix string, val reflect.Value) {
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+		if name == "" {
+			continue
+		}
+		key := prefix + name
+		fv := val.Field(i)
+		switch {
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			v.SetDefault(key, fv.Interface())
+		case fv.Kind() == reflect.Struct:
+			setDefaults(v, key+".", fv)
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String:
+			v.SetDefault(key, fv.Interface())
+		case fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map:
+			//lists of objects and maps only come from the file
+		default:
+			v.SetDefault(key, fv.Interface())
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func problems(t *testing.T, err error) string {
+	t.Helper()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+	return strings.Join(verr.Problems, "\n")
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, "config.json", `{
+		"server_config": {"listen_addr": ":9000", "idle_timeout": "90s"},
+		"nats_config": {"host": "nats://nats:4222", "subject_number": 4},
+		"quota_config": {"default": {"max_concurrent": 2}, "rules": [{"key": "client:big", "max_concurrent": 10}]}
+	}`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.ListenAddr != ":9000" || cfg.Server.IdleTimeout != 90*time.Second || cfg.NATS.SubjectNumber != 4 {
+		t.Fatalf("file not applied: %+v", cfg)
+	}
+	//defaults fill what the file leaves out
+	if cfg.Server.WSPingInterval != 20*time.Second || cfg.NATS.TTSSubject != "tts" {
+		t.Fatalf("defaults not applied: %+v", cfg)
+	}
+	if cfg.Quota.Default.MaxConcurrent != 2 || len(cfg.Quota.Rules) != 1 || cfg.Quota.Rules[0].MaxConcurrent != 10 {
+		t.Fatalf("quota not read: %+v", cfg.Quota)
+	}
+}
+
+func TestLoadYAMLDirectory(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "nats_config:\n  host: nats://nats:4222\n")
+	cfg, err := Load(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NATS.Host != "nats://nats:4222" {
+		t.Fatalf("got %+v", cfg.NATS)
+	}
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"nats_config": {"host": "nats://file:4222"}}`)
+	os.Setenv("BELLO_NATS_HOST", "nats://env:4222")
+	os.Setenv("BELLO_SERVER_IDLE_TIMEOUT", "3m")
+	defer os.Unsetenv("BELLO_NATS_HOST")
+	defer os.Unsetenv("BELLO_SERVER_IDLE_TIMEOUT")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NATS.Host != "nats://env:4222" || cfg.Server.IdleTimeout != 3*time.Minute {
+		t.Fatalf("environment not applied: %+v %+v", cfg.NATS, cfg.Server.IdleTimeout)
+	}
+}
+
+func TestLoadRefusesUnknownKeys(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"nats_config": {"host": "nats://n:4222", "hots": "x"}}`)
+	_, err := Load(path)
+	if !strings.Contains(problems(t, err), "hots") {
+		t.Fatalf("misspelt key not reported: %v", err)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Server.LogLevel = "loud"
+	cfg.Server.IdleTimeout = 0
+	cfg.Server.AudioOverflow = "spill"
+	cfg.Server.Cert = "cert.pem"
+	cfg.Server.RequireClientCert = true
+	cfg.NATS.SubjectNumber = 0
+	cfg.Auth.APIKeys = map[string]string{"gateway": ""}
+	cfg.Auth.JWKSFile = "/does/not/exist.json"
+	cfg.Quota.Default.MaxConcurrent = -1
+	cfg.Audit.Sink = "tape"
+
+	out := problems(t, cfg.Validate())
+	for _, field := range []string{
+		"server_config.log_level",
+		"server_config.idle_timeout",
+		"server_config.audio_overflow",
+		"server_config.cert: cert and key must be set together",
+		"server_config.require_client_cert",
+		"nats_config.host: is required",
+		"nats_config.subject_number",
+		"auth_config.api_keys.gateway",
+		"auth_config.jwks_file",
+		"quota_config.default.max_concurrent",
+		"audit_config.sink",
+	} {
+		if !strings.Contains(out, field) {
+			t.Fatalf("%s not reported in:\n%s", field, out)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Masked replaces credentials wherever configuration is shown
+const Masked = "******"
+
+//reloadable are the keys a running controller can take on without dropping
+//calls, every other change needs a restart
+var reloadable = map[string]bool{
+	"nats_config.conn_number":         true,
+	"nats_config.subject_number":      true,
+	"server_config.log_level":         true,
+	"server_config.idle_timeout":      true,
+	"server_config.tts_chunk_timeout": true,
+	"server_config.stt_setup_timeout": true,
+	"server_config.ws_ping_interval":  true,
+	"server_config.ws_pong_timeout":   true,
+	"server_config.ws_write_timeout":  true,
+	"auth_config.timeout":             true,
+}
+
+//Reloadable tells whether a change of key can be applied live
+func Reloadable(key string) bool {
+	return reloadable[key]
+}
+
+//Reload returns a copy of cfg holding the reloadable settings of next
+func (cfg *Config) Reload(next *Config) *Config {
+	out := *cfg
+	out.NATS.ConnNumber = next.NATS.ConnNumber
+	out.NATS.SubjectNumber = next.NATS.SubjectNumber
+	out.Server.LogLevel = next.Server.LogLevel
+	out.Server.IdleTimeout = next.Server.IdleTimeout
+	out.Server.TTSChunkTimeout = next.Server.TTSChunkTimeout
+	out.Server.STTSetupTimeout = next.Server.STTSetupTimeout
+	out.Server.WSPingInterval = next.Server.WSPingInterval
+	out.Server.WSPongTimeout = next.Server.WSPongTimeout
+	out.Server.WSWriteTimeout = next.Server.WSWriteTimeout
+	out.Auth.Timeout = next.Auth.Timeout
+	return &out
+}
+
+//Sensitive tells whether the value of key is a credential, everything below
+//a sensitive key (api_keys, hmac_secrets...) is one as well. Values of other
+//keys only have the credentials of their URLs masked.
+func Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	if key == "key" || key == "api_keys" || key == "access_key" || key == "api_key" || key == "dsn" || key == "sig" {
+		return true
+	}
+	for _, word := range []string{"secret", "password", "token", "signature", "credential"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+//Change is one setting that differs between two configurations, values are
+//JSON with credentials masked
+type Change struct {
+	Key string
+	Old string
+	New string
+}
+
+func (c Change) String() string {
+	return c.Key + ": " + c.Old + " -> " + c.New
+}
+
+//Diff lists the settings that differ from cfg in next, sorted by key
+func (cfg *Config) Diff(next *Config) []Change {
+	old, cur := flatten(cfg), flatten(next)
+	changes := []Change{}
+	for key, value := range cur {
+		if prev, ok := old[key]; !ok || prev != value {
+			if !ok {
+				prev = "null"
+			}
+			changes = append(changes, Change{Key: key, Old: prev, New: value})
+		}
+	}
+	for key, prev := range old {
+		if _, ok := cur[key]; !ok {
+			changes = append(changes, Change{Key: key, Old: prev, New: "null"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+//flatten maps dotted keys to the JSON of their values, lists stay whole
+func flatten(cfg *Config) map[string]string {
+	out := map[string]string{}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		if m, ok := v.(map[string]interface{}); ok {
+			for k, child := range m {
+				walk(prefix+k+".", child)
+			}
+			return
+		}
+		value, _ := json.Marshal(v)
+		out[strings.TrimSuffix(prefix, ".")] = string(value)
+	}
+	walk("", cfg.Settings())
+	return out
+}
+
+//Settings returns the configuration as nested maps keyed like the file,
+//durations are written as "1m30s" and credentials are masked
+func (cfg *Config) Settings() map[string]interface{} {
+	return settings(reflect.ValueOf(cfg).Elem(), false).(map[string]interface{})
+}
+
+var (
+	//userinfo of URLs, even in lists such as nats://a:p@h1,nats://h2 or
+	//mongodb://u:p@h1,h2/db
+	urlUserinfo = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://)[^/?#\s]+@`)
+	urlParam    = regexp.MustCompile(`([?&]([^=&#\s]*)=)([^&#\s]*)`)
+)
+
+//maskURLs hides the credentials a value carries in URLs, the userinfo and
+//query parameters named like a secret, and keeps the hosts readable
+func maskURLs(value string) string {
+	if !strings.Contains(value, "://") {
+		return value
+	}
+	value = urlUserinfo.ReplaceAllString(value, "${1}"+Masked+"@")
+	return urlParam.ReplaceAllStringFunc(value, func(param string) string {
+		m := urlParam.FindStringSubmatch(param)
+		if Sensitive(m[2]) {
+			return m[1] + Masked
+		}
+		return param
+	})
+}
+
+func settings(v reflect.Value, sensitive bool) interface{} {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		if sensitive {
+			return Masked
+		}
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		out := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			tag := strings.Split(field.Tag.Get("json"), ",")
+			if field.Anonymous && tag[0] == "" {
+				//embedded structs such as quota.Limits are inlined
+				for k, val := range settings(v.Field(i), sensitive).(map[string]interface{}) {
+					out[k] = val
+				}
+				continue
+			}
+			name := tag[0]
+			if name == "" {
+				name = field.Name
+			}
+			out[name] = settings(v.Field(i), sensitive || Sensitive(name))
+		}
+		return out
+	case reflect.Map:
+		out := map[string]interface{}{}
+		for _, k := range v.MapKeys() {
+			out[fmt.Sprint(k.Interface())] = settings(v.MapIndex(k), sensitive)
+		}
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = settings(v.Index(i), sensitive)
+		}
+		return out
+	}
+	if sensitive {
+		return Masked
+	}
+	if v.Kind() == reflect.String {
+		return maskURLs(v.String())
+	}
+	return v.Interface()
+}
+
+//Live holds the configuration in effect, reloads replace it as a whole
+type Live struct {
+	mu  sync.RWMutex
+	cfg *Config
+}
+
+//NewLive ...
+func NewLive(cfg *Config) *Live {
+	return &Live{cfg: cfg}
+}
+
+//Get returns the configuration in effect, it must not be modified
+func (l *Live) Get() *Config {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.cfg
+}
+
+//Set ...
+func (l *Live) Set(cfg *Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/auth"
+	"github.com/4406arthur/bello/pkg/redact"
+	"github.com/4406arthur/bello/pkg/tlsconf"
+	"github.com/4406arthur/bello/pkg/tracing"
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/sirupsen/logrus"
+)
+
+//ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+//checker collects problems as field: message
+type checker struct {
+	problems []string
+}
+
+func (c *checker) fail(field, format string, args ...interface{}) {
+	c.problems = append(c.problems, field+": "+fmt.Sprintf(format, args...))
+}
+
+func (c *checker) required(field, value string) {
+	if value == "" {
+		c.fail(field, "is required")
+	}
+}
+
+func (c *checker) min(field string, value, min int64) {
+	if value < min {
+		c.fail(field, "must be at least %d, got %d", min, value)
+	}
+}
+
+func (c *checker) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	c.fail(field, "must be one of %q, got %q", allowed, value)
+}
+
+func (c *checker) positive(field string, d time.Duration) {
+	if d <= 0 {
+		c.fail(field, "must be positive, got %s", d)
+	}
+}
+
+func (c *checker) nonNegative(field string, d time.Duration) {
+	if d < 0 {
+		c.fail(field, "must not be negative, got %s", d)
+	}
+}
+
+//file checks that a referenced file can be read
+func (c *checker) file(field, path string) {
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		c.fail(field, "%s", err.Error())
+		return
+	}
+	f.Close()
+}
+
+//Validate checks every section and reports all problems at once
+func (cfg *Config) Validate() error {
+	c := &checker{}
+
+	s := cfg.Server
+	c.required("server_config.listen_addr", s.ListenAddr)
+	if _, err := logrus.ParseLevel(s.LogLevel); err != nil {
+		c.fail("server_config.log_level", "%s", err.Error())
+	}
+	c.positive("server_config.idle_timeout", s.IdleTimeout)
+	c.positive("server_config.tts_chunk_timeout", s.TTSChunkTimeout)
+	c.positive("server_config.stt_setup_timeout", s.STTSetupTimeout)
+	c.positive("server_config.ws_ping_interval", s.WSPingInterval)
+	c.positive("server_config.ws_pong_timeout", s.WSPongTimeout)
+	c.positive("server_config.ws_write_timeout", s.WSWriteTimeout)
+	c.min("server_config.audio_buffer", int64(s.AudioBuffer), 1)
+	c.oneOf("server_config.audio_overflow", s.AudioOverflow, AudioDropOldest, AudioAbort)
+	c.min("server_config.result_buffer", int64(s.ResultBuffer), 1)
+	c.positive("server_config.slow_client_timeout", s.SlowClientTimeout)
+	c.positive("server_config.read_header_timeout", s.ReadHeaderTimeout)
+	c.positive("server_config.http_read_timeout", s.HTTPReadTimeout)
+	c.positive("server_config.http_write_timeout", s.HTTPWriteTimeout)
+	c.nonNegative("server_config.http_idle_timeout", s.HTTPIdleTimeout)
+	if s.BargeInThreshold < 0 {
+		c.fail("server_config.barge_in_threshold", "must not be negative")
+	}
+	c.min("server_config.barge_in_frames", int64(s.BargeInFrames), 0)
+	c.min("server_config.read_buffer_size", int64(s.ReadBufferSize), 0)
+	c.min("server_config.write_buffer_size", int64(s.WriteBufferSize), 0)
+	c.min("server_config.max_message_size", s.MaxMessageSize, 0)
+	if (s.Cert == "") != (s.Key == "") {
+		c.fail("server_config.cert", "cert and key must be set together")
+	}
+	c.file("server_config.cert", s.Cert)
+	c.file("server_config.key", s.Key)
+	c.file("server_config.client_ca", s.ClientCA)
+	if s.ClientCA != "" && !s.TLS() {
+		c.fail("server_config.client_ca", "requires cert and key")
+	}
+	if s.RequireClientCert && s.ClientCA == "" {
+		c.fail("server_config.require_client_cert", "requires client_ca")
+	}
+	c.oneOf("server_config.tls_min_version", s.TLSMinVersion, "", "1.2", "1.3")
+	if _, err := tlsconf.CipherSuites(s.CipherSuites); err != nil {
+		c.fail("server_config.cipher_suites", "%s", err.Error())
+	}
+	c.nonNegative("server_config.cert_reload_interval", s.CertReloadInterval)
+	c.nonNegative("server_config.shutdown_delay", s.ShutdownDelay)
+	c.nonNegative("server_config.shutdown_timeout", s.ShutdownTimeout)
+
+	c.required("nats_config.host", cfg.NATS.Host)
+	c.min("nats_config.conn_number", int64(cfg.NATS.ConnNumber), 1)
+	c.min("nats_config.subject_number", int64(cfg.NATS.SubjectNumber), 1)
+
+	c.nonNegative("auth_config.timeout", cfg.Auth.Timeout)
+	c.file("auth_config.jwks_file", cfg.Auth.JWKSFile)
+	for name, key := range cfg.Auth.APIKeys {
+		if key == "" {
+			c.fail("auth_config.api_keys."+name, "key is empty")
+		}
+	}
+	if len(cfg.Auth.ClientCerts) > 0 {
+		if s.ClientCA == "" {
+			c.fail("auth_config.client_certs", "requires server_config.client_ca")
+		}
+		if _, err := auth.NewClientCerts(cfg.Auth.ClientCerts); err != nil {
+			c.fail("auth_config.client_certs", "%s", err.Error())
+		}
+	}
+	c.file("admin_config.jwks_file", cfg.Admin.JWKSFile)
+	c.positive("admin_config.read_timeout", cfg.Admin.ReadTimeout)
+	c.positive("admin_config.write_timeout", cfg.Admin.WriteTimeout)
+	for i, key := range cfg.Admin.APIKeys {
+		field := fmt.Sprintf("admin_config.api_keys[%d]", i)
+		c.required(field+".name", key.Name)
+		c.required(field+".key", key.Key)
+	}
+
+	c.min("quota_config.default.session_burst", int64(cfg.Quota.Default.SessionBurst), 0)
+	c.min("quota_config.default.max_concurrent", int64(cfg.Quota.Default.MaxConcurrent), 0)
+	c.min("quota_config.default.audio_seconds_per_day", cfg.Quota.Default.AudioSecondsPerDay, 0)
+	for i, rule := range cfg.Quota.Rules {
+		c.required(fmt.Sprintf("quota_config.rules[%d].key", i), rule.Key)
+	}
+
+	a := cfg.Audit
+	c.oneOf("audit_config.sink", a.Sink, AuditLogger, AuditFile, AuditMongo, AuditElasticsearch)
+	switch a.Sink {
+	case AuditFile:
+		c.required("audit_config.path", a.Path)
+	case AuditMongo:
+		if cfg.Mongo.Endpoint == "" {
+			c.fail("audit_config.sink", "mongo requires mongo_config.endpoint")
+		}
+	case AuditElasticsearch:
+		c.required("audit_config.endpoint", a.Endpoint)
+	}
+
+	if !cfg.Redact.Disabled {
+		if _, err := redact.New(cfg.Redact.Rules, cfg.Redact.Patterns); err != nil {
+			c.fail("redact_config", "%s", err.Error())
+		}
+	}
+
+	c.file("encryption_config.keyfile", cfg.Encryption.Keyfile)
+
+	r := cfg.Recording
+	if r.Enabled {
+		c.oneOf("recording_config.store", r.Store, StoreLocal, StoreS3)
+		c.oneOf("recording_config.mode", r.Mode, RecordAlways, RecordOnRequest)
+		switch r.Store {
+		case StoreLocal:
+			c.required("recording_config.dir", r.Dir)
+		case StoreS3:
+			c.required("recording_config.s3.endpoint", r.S3.Endpoint)
+			c.required("recording_config.s3.bucket", r.S3.Bucket)
+			c.required("recording_config.s3.access_key", r.S3.AccessKey)
+			c.required("recording_config.s3.secret_key", r.S3.SecretKey)
+		}
+		c.nonNegative("recording_config.retention", r.Retention)
+	}
+
+	for i, sink := range cfg.Log.Sinks {
+		field := fmt.Sprintf("log_config.sinks[%d]", i)
+		c.oneOf(field+".type", sink.Type, logger.SinkStdout, logger.SinkStderr, logger.SinkFile, logger.SinkElasticsearch, logger.SinkSyslog)
+		if sink.Level != "" {
+			if _, err := logrus.ParseLevel(sink.Level); err != nil {
+				c.fail(field+".level", "%s", err.Error())
+			}
+		}
+		c.oneOf(field+".format", sink.Format, "", logger.FormatJSON, logger.FormatText)
+		switch sink.Type {
+		case logger.SinkFile:
+			c.required(field+".path", sink.Path)
+			c.min(field+".max_size_mb", int64(sink.MaxSizeMB), 0)
+			c.min(field+".max_age_days", int64(sink.MaxAgeDays), 0)
+			c.min(field+".max_backups", int64(sink.MaxBackups), 0)
+		case logger.SinkElasticsearch:
+			c.required(field+".endpoint", sink.Endpoint)
+			c.min(field+".batch_size", int64(sink.BatchSize), 0)
+			c.nonNegative(field+".flush_interval", sink.FlushInterval)
+		case logger.SinkSyslog:
+			c.oneOf(field+".network", sink.Network, "", "udp", "tcp", "unix", "unixgram")
+			if sink.Network != "" {
+				c.required(field+".address", sink.Address)
+			}
+		}
+		c.min(field+".buffer_size", int64(sink.BufferSize), 0)
+	}
+
+	t := cfg.Tracing
+	c.oneOf("tracing_config.exporter", t.Exporter, tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout)
+	if t.Exporter == tracing.ExporterOTLP {
+		c.required("tracing_config.endpoint", t.Endpoint)
+	}
+	if t.Exporter != tracing.ExporterNone {
+		c.required("tracing_config.service_name", t.ServiceName)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		c.fail("tracing_config.sample_ratio", "must be between 0 and 1, got %g", t.SampleRatio)
+	}
+
+	if len(c.problems) > 0 {
+		return &ValidationError{Problems: c.problems}
+	}
+	return nil
+}
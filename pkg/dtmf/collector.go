@@ -0,0 +1,149 @@
+package dtmf
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+)
+
+// Defaults used when a grammar leaves a field empty
+const (
+	DefaultMinLength         = 1
+	DefaultTerminator        = "#"
+	DefaultInterDigitTimeout = 3 * time.Second
+)
+
+// events maps RFC 4733 event codes 0-15 to their keys
+const events = "0123456789*#ABCD"
+
+//ErrInvalidDigit returned for events that are not a DTMF key
+var ErrInvalidDigit = errors.New("invalid dtmf digit")
+
+//Digit decodes a dtmf action, the RFC 4733 event code wins over the digit text
+func Digit(a *entity.Action) (byte, error) {
+	if a.Event != nil {
+		if *a.Event < 0 || *a.Event >= len(events) {
+			return 0, ErrInvalidDigit
+		}
+		return events[*a.Event], nil
+	}
+	if len(a.Digit) != 1 {
+		return 0, ErrInvalidDigit
+	}
+	d := strings.ToUpper(a.Digit)[0]
+	if strings.IndexByte(events, d) < 0 {
+		return 0, ErrInvalidDigit
+	}
+	return d, nil
+}
+
+//ValidateGrammar checks a grammar sent by a client or a call flow
+func ValidateGrammar(g *entity.DTMFGrammar) error {
+	if g == nil {
+		return nil
+	}
+	if g.MinLength < 0 || g.MaxLength < 0 || g.InterDigitTimeout < 0 {
+		return errors.New("dtmf grammar values must not be negative")
+	}
+	if g.MaxLength > 0 && g.MinLength > g.MaxLength {
+		return errors.New("dtmf minLength is greater than maxLength")
+	}
+	if len(g.Terminator) > 1 || (g.Terminator != "" && strings.IndexByte(events, strings.ToUpper(g.Terminator)[0]) < 0) {
+		return errors.New("dtmf terminator must be a single key")
+	}
+	return nil
+}
+
+//Outcome is a completed digit collection
+type Outcome struct {
+	Digits string
+	//Match is false when the input ended before MinLength digits were given
+	Match bool
+}
+
+//Collector gathers key presses according to a grammar
+type Collector struct {
+	min        int
+	max        int
+	terminator byte
+	timeout    time.Duration
+
+	digits []byte
+	lastTS uint32
+}
+
+//NewCollector applies the defaults to a possibly nil grammar
+func NewCollector(g *entity.DTMFGrammar) *Collector {
+	c := &Collector{
+		min:        DefaultMinLength,
+		terminator: DefaultTerminator[0],
+		timeout:    DefaultInterDigitTimeout,
+	}
+	if g == nil {
+		return c
+	}
+	if g.MinLength > 0 {
+		c.min = g.MinLength
+	}
+	c.max = g.MaxLength
+	if g.Terminator != "" {
+		c.terminator = strings.ToUpper(g.Terminator)[0]
+	}
+	if g.InterDigitTimeout > 0 {
+		c.timeout = time.Duration(g.InterDigitTimeout) * time.Millisecond
+	}
+	return c
+}
+
+//InterDigitTimeout is how long to wait for the next key
+func (c *Collector) InterDigitTimeout() time.Duration {
+	return c.timeout
+}
+
+//Pending reports whether some digits were collected
+func (c *Collector) Pending() bool {
+	return len(c.digits) > 0
+}
+
+//Accepts reports whether the action is a new key press. RFC 4733 senders
+//repeat packets of the same event, only the first end packet of an event
+//(identified by its timestamp) counts. Actions without a timestamp are
+//treated as one key press each.
+func (c *Collector) Accepts(a *entity.Action) bool {
+	if a.Timestamp == 0 {
+		return true
+	}
+	if !a.End || a.Timestamp == c.lastTS {
+		return false
+	}
+	c.lastTS = a.Timestamp
+	return true
+}
+
+//Add records a key and returns the outcome once the input is complete
+func (c *Collector) Add(d byte) (*Outcome, bool) {
+	if d == c.terminator {
+		return c.finish(), true
+	}
+	c.digits = append(c.digits, d)
+	if c.max > 0 && len(c.digits) >= c.max {
+		return c.finish(), true
+	}
+	return nil, false
+}
+
+//Timeout ends the input after the inter digit timeout expired
+func (c *Collector) Timeout() *Outcome {
+	return c.finish()
+}
+
+func (c *Collector) finish() *Outcome {
+	o := &Outcome{
+		Digits: string(c.digits),
+		Match:  len(c.digits) >= c.min,
+	}
+	c.digits = c.digits[:0]
+	return o
+}
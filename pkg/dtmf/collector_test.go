@@ -0,0 +1,148 @@
+package dtmf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+)
+
+func event(code int) *int {
+	return &code
+}
+
+func TestDigit(t *testing.T) {
+	tests := []struct {
+		name   string
+		action entity.Action
+		want   byte
+		err    error
+	}{
+		{"digit", entity.Action{Digit: "5"}, '5', nil},
+		{"star", entity.Action{Digit: "*"}, '*', nil},
+		{"lower case letter", entity.Action{Digit: "b"}, 'B', nil},
+		{"event", entity.Action{Event: event(11)}, '#', nil},
+		{"event D", entity.Action{Event: event(15)}, 'D', nil},
+		{"event wins over digit", entity.Action{Digit: "1", Event: event(0)}, '0', nil},
+		{"event out of range", entity.Action{Event: event(16)}, 0, ErrInvalidDigit},
+		{"negative event", entity.Action{Event: event(-1)}, 0, ErrInvalidDigit},
+		{"two keys", entity.Action{Digit: "12"}, 0, ErrInvalidDigit},
+		{"no key", entity.Action{}, 0, ErrInvalidDigit},
+		{"not a key", entity.Action{Digit: "E"}, 0, ErrInvalidDigit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Digit(&tt.action)
+			if got != tt.want || err != tt.err {
+				t.Fatalf("got %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateGrammar(t *testing.T) {
+	tests := []struct {
+		name  string
+		g     *entity.DTMFGrammar
+		valid bool
+	}{
+		{"none", nil, true},
+		{"empty", &entity.DTMFGrammar{}, true},
+		{"full", &entity.DTMFGrammar{MinLength: 4, MaxLength: 6, Terminator: "*", InterDigitTimeout: 2000}, true},
+		{"letter terminator", &entity.DTMFGrammar{Terminator: "a"}, true},
+		{"negative", &entity.DTMFGrammar{MinLength: -1}, false},
+		{"negative timeout", &entity.DTMFGrammar{InterDigitTimeout: -1}, false},
+		{"min above max", &entity.DTMFGrammar{MinLength: 5, MaxLength: 4}, false},
+		{"long terminator", &entity.DTMFGrammar{Terminator: "##"}, false},
+		{"terminator not a key", &entity.DTMFGrammar{Terminator: "x"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateGrammar(tt.g); (err == nil) != tt.valid {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
+
+//collect feeds keys to a collector and returns the first outcome
+func collect(c *Collector, keys string) (*Outcome, bool) {
+	for i := 0; i < len(keys); i++ {
+		if o, done := c.Add(keys[i]); done {
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+func TestCollector(t *testing.T) {
+	tests := []struct {
+		name    string
+		g       *entity.DTMFGrammar
+		keys    string
+		timeout bool
+		want    Outcome
+	}{
+		{"terminator", nil, "1234#", false, Outcome{"1234", true}},
+		{"terminator alone", nil, "#", false, Outcome{"", false}},
+		{"max length", &entity.DTMFGrammar{MaxLength: 3}, "12345", false, Outcome{"123", true}},
+		{"short input", &entity.DTMFGrammar{MinLength: 4}, "12#", false, Outcome{"12", false}},
+		{"own terminator", &entity.DTMFGrammar{Terminator: "*"}, "1#2*", false, Outcome{"1#2", true}},
+		{"lower case terminator", &entity.DTMFGrammar{Terminator: "d"}, "9D", false, Outcome{"9", true}},
+		{"timeout", &entity.DTMFGrammar{MinLength: 2}, "12", true, Outcome{"12", true}},
+		{"timeout too early", &entity.DTMFGrammar{MinLength: 3}, "12", true, Outcome{"12", false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollector(tt.g)
+			o, done := collect(c, tt.keys)
+			if tt.timeout {
+				if done || !c.Pending() {
+					t.Fatalf("input ended before the timeout: %+v", o)
+				}
+				o = c.Timeout()
+			} else if !done {
+				t.Fatal("input did not end")
+			}
+			if *o != tt.want {
+				t.Fatalf("got %+v, want %+v", *o, tt.want)
+			}
+			if c.Pending() {
+				t.Fatal("digits left after the outcome")
+			}
+		})
+	}
+}
+
+func TestCollectorDefaults(t *testing.T) {
+	if got := NewCollector(nil).InterDigitTimeout(); got != DefaultInterDigitTimeout {
+		t.Fatalf("got %v", got)
+	}
+	if got := NewCollector(&entity.DTMFGrammar{InterDigitTimeout: 1500}).InterDigitTimeout(); got != 1500*time.Millisecond {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestAcceptsIgnoresRepeatedPackets(t *testing.T) {
+	c := NewCollector(nil)
+	packets := []struct {
+		action entity.Action
+		want   bool
+	}{
+		{entity.Action{Event: event(1), Timestamp: 100}, false},
+		{entity.Action{Event: event(1), Timestamp: 100, End: true}, true},
+		//end packets are sent three times
+		{entity.Action{Event: event(1), Timestamp: 100, End: true}, false},
+		{entity.Action{Event: event(1), Timestamp: 100, End: true}, false},
+		//the same key pressed again is a new event
+		{entity.Action{Event: event(1), Timestamp: 900, End: true}, true},
+		//clients without RFC 4733 timestamps send one action per key
+		{entity.Action{Digit: "2"}, true},
+		{entity.Action{Digit: "2"}, true},
+	}
+	for i, p := range packets {
+		if got := c.Accepts(&p.action); got != p.want {
+			t.Fatalf("packet %d: got %v, want %v", i, got, p.want)
+		}
+	}
+}
@@ -4,12 +4,24 @@ package entity
 const (
 	StateListening = "listening"
 	StateResult    = "result"
+	StatePrompt    = "prompt"            // 流程播放提示
+	StateTransfer  = "transfer"          // 流程轉接
+	StateHangup    = "hangup"            // 流程掛斷
+	StateSpeakDone = "speak-complete"    // 語音播放完畢
+	StateBargeIn   = "barge-in-occurred" // 使用者插話中斷播放
 )
 
 // 指令
 const (
 	ActionStart = "start"
 	ActionStop  = "stop"
+	ActionSpeak = "speak" // 語音合成播放
+	ActionDTMF  = "dtmf"  // 按鍵事件
+)
+
+// 輸入方式
+const (
+	InputDTMF = "dtmf"
 )
 
 // Error Code
@@ -41,21 +53,90 @@ type Response struct {
 	ResultIndex   int             `json:"result_index,omitempty"`
 	RecogWord     []RecognizeWord `json:"recog_word,omitempty"`
 	RecogResult   string          `json:"recog_result,omitempty"`
+	Node          string          `json:"node,omitempty"`       // 流程節點
+	Text          string          `json:"text,omitempty"`       // 提示文字
+	Intent        string          `json:"intent,omitempty"`     // 比對到的意圖
+	Target        string          `json:"target,omitempty"`     // 轉接目標
+	InputMode     string          `json:"input_mode,omitempty"` // 輸入方式
+	SessionID     string          `json:"session_id,omitempty"` // 連線識別碼
 }
 
 // 傳給辨識的指令 (json)
 type Action struct {
-	Action         string `json:"action,omitempty"`
-	Domain         string `json:"domain,omitempty"`
-	Platform       string `json:"platform,omitempty"`
-	UID            string `json:"uid,omitempty"`
-	IsGetPartial   bool   `json:"isGetPartial,omitempty"`
-	IsGetWordInfo  bool   `json:"isGetWordInfo,omitempty"`
-	NBestNum       int    `json:"nBestNum,omitempty"`
-	Type           string `json:"type,omitempty"`
-	Token          string `json:"token,omitempty"`
-	IsDoEPD        bool   `json:"bIsDoEPD"`
-	PCMD           string `json:"pcmd,omitempty"`
-	Text           string `json:"text,omitempty"`
-	RejectionLevel int    `json:"rejectionLevel,omitempty"`
+	Action         string       `json:"action,omitempty"`
+	Domain         string       `json:"domain,omitempty"`
+	Platform       string       `json:"platform,omitempty"`
+	UID            string       `json:"uid,omitempty"`
+	IsGetPartial   bool         `json:"isGetPartial,omitempty"`
+	IsGetWordInfo  bool         `json:"isGetWordInfo,omitempty"`
+	NBestNum       int          `json:"nBestNum,omitempty"`
+	Type           string       `json:"type,omitempty"`
+	Token          string       `json:"token,omitempty"`
+	IsDoEPD        bool         `json:"bIsDoEPD"`
+	PCMD           string       `json:"pcmd,omitempty"`
+	Text           string       `json:"text,omitempty"`
+	RejectionLevel int          `json:"rejectionLevel,omitempty"`
+	Voice          string       `json:"voice,omitempty"`      // 合成聲音
+	SampleRate     int          `json:"sampleRate,omitempty"` // 音訊取樣率
+	BargeIn        bool         `json:"bargeIn,omitempty"`    // 允許插話中斷播放
+	DTMF           *DTMFGrammar `json:"dtmf,omitempty"`       // 按鍵輸入規則
+	Digit          string       `json:"digit,omitempty"`      // 按鍵
+	Event          *int         `json:"event,omitempty"`      // RFC 4733 事件代碼
+	End            bool         `json:"end,omitempty"`        // RFC 4733 結束旗標
+	Duration       int          `json:"duration,omitempty"`   // 按鍵時長 (timestamp units)
+	Timestamp      uint32       `json:"timestamp,omitempty"`  // RFC 4733 事件時間戳
+	Grammars       []GrammarRef `json:"grammars,omitempty"`   // 辨識語法與熱詞
+	Record         bool         `json:"record,omitempty"`     // 要求錄音
+}
+
+// 按鍵輸入規則 (json)
+type DTMFGrammar struct {
+	MinLength         int    `json:"minLength,omitempty" yaml:"min_length" bson:"min_length,omitempty"`
+	MaxLength         int    `json:"maxLength,omitempty" yaml:"max_length" bson:"max_length,omitempty"`
+	Terminator        string `json:"terminator,omitempty" yaml:"terminator" bson:"terminator,omitempty"`
+	InterDigitTimeout int    `json:"interDigitTimeout,omitempty" yaml:"inter_digit_timeout" bson:"inter_digit_timeout,omitempty"` // 毫秒
+}
+
+// 傳給語音合成的請求 (json)
+type SpeakRequest struct {
+	Text       string `json:"text"`
+	Voice      string `json:"voice,omitempty"`
+	SampleRate int    `json:"sampleRate,omitempty"`
+	Node       string `json:"-"`
+	BargeIn    bool   `json:"-"`
+}
+
+// Grammar 類型
+const (
+	GrammarSRGS    = "srgs"
+	GrammarPhrases = "phrases"
+)
+
+// 辨識語法, 引用已上傳的 ID 或直接帶入內容 (json)
+type GrammarRef struct {
+	ID      string   `json:"id,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	Content string   `json:"content,omitempty"` // SRGS XML
+	Phrases []Phrase `json:"phrases,omitempty"`
+	Weight  float64  `json:"weight,omitempty"`
+}
+
+// 熱詞
+type Phrase struct {
+	Text  string  `json:"text" bson:"text"`
+	Boost float64 `json:"boost,omitempty" bson:"boost,omitempty"`
+}
+
+// 開始辨識時傳給 STT 的設定 (json)
+type RecognizeSetup struct {
+	Domain         string       `json:"domain,omitempty"`
+	Platform       string       `json:"platform,omitempty"`
+	UID            string       `json:"uid,omitempty"`
+	IsGetPartial   bool         `json:"isGetPartial,omitempty"`
+	IsGetWordInfo  bool         `json:"isGetWordInfo,omitempty"`
+	NBestNum       int          `json:"nBestNum,omitempty"`
+	IsDoEPD        bool         `json:"bIsDoEPD"`
+	RejectionLevel int          `json:"rejectionLevel,omitempty"`
+	SampleRate     int          `json:"sampleRate,omitempty"`
+	Grammars       []GrammarRef `json:"grammars,omitempty"`
 }
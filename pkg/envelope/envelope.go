@@ -0,0 +1,508 @@
+//Package envelope encrypts files at rest with AES-256-GCM. Every file gets
+//its own random data key which is stored in the file header, wrapped by a
+//master key of the keyring. Rotating the master key only rewrites headers.
+//
+//A file is the header followed by frames, each frame is a 4 byte big endian
+//length and a sealed chunk of at most ChunkSize bytes. The top bit of the
+//length marks the final frame, an empty one closing the file. Frames are
+//sealed with their index in the nonce and their length and index as
+//additional data, so frames cannot be altered, reordered, dropped or cut
+//off the end without the file failing to open.
+//
+//Writers on a seekable file keep a final frame behind the data after every
+//Flush, the file is complete at any time and more frames can be appended
+//to it later.
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	magic   = "BELLOENV"
+	version = 2
+	// ChunkSize is the most plaintext sealed in one frame
+	ChunkSize = 64 * 1024
+	//finalBit flags the length of the final frame
+	finalBit = 1 << 31
+)
+
+//ErrNotEncrypted is returned for files without an envelope header
+var ErrNotEncrypted = errors.New("envelope: not an encrypted file")
+
+//ErrCorrupt is returned when a header or frame does not authenticate
+var ErrCorrupt = errors.New("envelope: file is corrupt or was tampered with")
+
+//ErrTruncated is returned for files ending before their final frame, they
+//were cut short or their writer stopped in the middle of a frame
+var ErrTruncated = errors.New("envelope: file is truncated")
+
+//ErrClosed is returned when writing to a closed Writer
+var ErrClosed = errors.New("envelope: writer is closed")
+
+//header is the plaintext start of an encrypted file
+type header struct {
+	keyID   string
+	wrapped []byte
+}
+
+func (h *header) marshal() []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(magic)
+	b.WriteByte(version)
+	b.WriteByte(byte(len(h.keyID)))
+	b.WriteString(h.keyID)
+	binary.Write(b, binary.BigEndian, uint16(len(h.wrapped)))
+	b.Write(h.wrapped)
+	return b.Bytes()
+}
+
+func readHeader(r io.Reader) (*header, error) {
+	pre := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(r, pre); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	if string(pre[:len(magic)]) != magic {
+		return nil, ErrNotEncrypted
+	}
+	if pre[len(magic)] != version {
+		return nil, ErrCorrupt
+	}
+	id := make([]byte, pre[len(magic)+1])
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, ErrCorrupt
+	}
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, ErrCorrupt
+	}
+	wrapped := make([]byte, n)
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return nil, ErrCorrupt
+	}
+	return &header{keyID: string(id), wrapped: wrapped}, nil
+}
+
+//additional data binding a wrapped data key to its master key id
+func (h *header) aad() []byte {
+	return append([]byte(magic), h.keyID...)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//wrap seals dataKey with the active master key
+func wrap(kr *Keyring, dataKey []byte) (*header, error) {
+	master, _ := kr.key(kr.active)
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	h := &header{keyID: kr.active}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	h.wrapped = aead.Seal(nonce, nonce, dataKey, h.aad())
+	return h, nil
+}
+
+//unwrap opens the data key of h
+func unwrap(kr *Keyring, h *header) ([]byte, error) {
+	master, err := kr.key(h.keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.wrapped) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+	nonce, sealed := h.wrapped[:aead.NonceSize()], h.wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, h.aad())
+	if err != nil || len(dataKey) != KeySize {
+		return nil, ErrCorrupt
+	}
+	return dataKey, nil
+}
+
+//frameNonce holds the frame index, and a flag keeping the final frame apart
+//from the data frame of the same index
+func frameNonce(aead cipher.AEAD, seq uint64, final bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	if final {
+		nonce[0] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+//frameAAD authenticates the length prefix and index of a frame
+func frameAAD(prefix uint32, seq uint64) []byte {
+	aad := make([]byte, 12)
+	binary.BigEndian.PutUint32(aad, prefix)
+	binary.BigEndian.PutUint64(aad[4:], seq)
+	return aad
+}
+
+//sealFrame returns frame seq with its length prefix, final frames carry no
+//data
+func sealFrame(aead cipher.AEAD, seq uint64, plain []byte, final bool) []byte {
+	prefix := uint32(len(plain) + aead.Overhead())
+	if final {
+		prefix |= finalBit
+	}
+	frame := make([]byte, 4, 4+len(plain)+aead.Overhead())
+	binary.BigEndian.PutUint32(frame, prefix)
+	return aead.Seal(frame, frameNonce(aead, seq, final), plain, frameAAD(prefix, seq))
+}
+
+//openFrame reads and authenticates frame seq from r. It returns io.EOF when
+//r ends right before the frame, ErrCorrupt when the frame is torn or does
+//not authenticate.
+func openFrame(r io.Reader, aead cipher.AEAD, seq uint64) (plain []byte, final bool, size int64, err error) {
+	pre := make([]byte, 4)
+	if _, err := io.ReadFull(r, pre); err != nil {
+		if err == io.EOF {
+			return nil, false, 0, io.EOF
+		}
+		return nil, false, 0, ErrCorrupt
+	}
+	prefix := binary.BigEndian.Uint32(pre)
+	final = prefix&finalBit != 0
+	n := prefix &^ finalBit
+	if n < uint32(aead.Overhead()) || n > ChunkSize+uint32(aead.Overhead()) || (final && n != uint32(aead.Overhead())) {
+		return nil, false, 0, ErrCorrupt
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, false, 0, ErrCorrupt
+	}
+	plain, err = aead.Open(sealed[:0], frameNonce(aead, seq, final), sealed, frameAAD(prefix, seq))
+	if err != nil {
+		return nil, false, 0, ErrCorrupt
+	}
+	return plain, final, int64(4 + n), nil
+}
+
+//Writer encrypts everything written to it, data is sealed a chunk at a time
+//so Flush or Close must be called to write the rest
+type Writer struct {
+	w io.Writer
+	//seeker is w when it can seek, the final frame is then kept after the
+	//data and overwritten by the next frame
+	seeker io.Seeker
+	aead   cipher.AEAD
+	seq    uint64
+	buf    []byte
+	err    error
+	closed bool
+}
+
+func newWriter(w io.Writer, aead cipher.AEAD, seq uint64) *Writer {
+	ew := &Writer{w: w, aead: aead, seq: seq, buf: make([]byte, 0, ChunkSize)}
+	if s, ok := w.(io.Seeker); ok {
+		ew.seeker = s
+	}
+	return ew
+}
+
+//NewWriter writes the header of a new file with a fresh data key to w. A
+//file given as w must not be opened with O_APPEND.
+func NewWriter(w io.Writer, kr *Keyring) (*Writer, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	h, err := wrap(kr, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ew := newWriter(w, aead, 0)
+	if err := ew.write(h.marshal()); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+//Append opens f for adding frames, an empty file gets a new header. f must
+//be opened for reading and writing, without O_APPEND. Every frame is
+//checked first: a file that does not authenticate or lacks its final frame
+//is refused with ErrCorrupt or ErrTruncated and left untouched.
+func Append(f *os.File, kr *Keyring) (*Writer, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return NewWriter(f, kr)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := unwrap(kr, h)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	offset := int64(len(h.marshal()))
+	seq := uint64(0)
+	for {
+		_, final, size, err := openFrame(r, aead, seq)
+		if err == io.EOF {
+			return nil, ErrTruncated
+		}
+		if err != nil {
+			return nil, err
+		}
+		if final {
+			if offset+size != info.Size() {
+				return nil, ErrCorrupt
+			}
+			break
+		}
+		offset += size
+		seq++
+	}
+	//the next frame takes the place of the final one
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return newWriter(f, aead, seq), nil
+}
+
+//Write ...
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, ErrClosed
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+//Flush seals the buffered data as a frame
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return ErrClosed
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	frame := sealFrame(w.aead, w.seq, w.buf, false)
+	w.seq++
+	w.buf = w.buf[:0]
+	return w.write(frame)
+}
+
+//write puts data on w, followed by the final frame when w can seek back
+//over it
+func (w *Writer) write(data []byte) error {
+	if w.seeker != nil {
+		final := sealFrame(w.aead, w.seq, nil, true)
+		data = append(data, final...)
+		if _, err := w.w.Write(data); err != nil {
+			w.err = err
+			return err
+		}
+		if _, err := w.seeker.Seek(-int64(len(final)), io.SeekCurrent); err != nil {
+			w.err = err
+			return err
+		}
+		return nil
+	}
+	if _, err := w.w.Write(data); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+//Close flushes the writer and ends the file with its final frame, it does
+//not close the underlying writer
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	w.closed = true
+	if _, err := w.w.Write(sealFrame(w.aead, w.seq, nil, true)); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+//Reader decrypts a file written by Writer
+type Reader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	seq  uint64
+	buf  []byte
+	done bool
+}
+
+//NewReader reads the header of an encrypted file and opens its data key, it
+//returns ErrNotEncrypted when r does not start with a header
+func NewReader(r io.Reader, kr *Keyring) (*Reader, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := unwrap(kr, h)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: br, aead: aead}, nil
+}
+
+//Read returns ErrTruncated when the file ends before its final frame and
+//ErrCorrupt when a frame does not authenticate or data follows the final
+//frame
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			if _, err := r.r.ReadByte(); err != io.EOF {
+				return 0, ErrCorrupt
+			}
+			return 0, io.EOF
+		}
+		plain, final, _, err := openFrame(r.r, r.aead, r.seq)
+		if err == io.EOF {
+			return 0, ErrTruncated
+		}
+		if err != nil {
+			return 0, err
+		}
+		if final {
+			r.done = true
+			continue
+		}
+		r.seq++
+		r.buf = plain
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+//IsEncrypted tells whether the file at path starts with an envelope header
+func IsEncrypted(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	pre := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, pre); err != nil {
+		return false, nil
+	}
+	return string(pre) == magic, nil
+}
+
+//Rewrap wraps the data key of the file at path with the active master key,
+//the frames are left as they are. It reports whether the file was changed.
+func Rewrap(path string, kr *Keyring) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	h, err := readHeader(r)
+	if err != nil {
+		return false, err
+	}
+	if h.keyID == kr.active {
+		return false, nil
+	}
+	dataKey, err := unwrap(kr, h)
+	if err != nil {
+		return false, err
+	}
+	nh, err := wrap(kr, dataKey)
+	if err != nil {
+		return false, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".rewrap-*")
+	if err != nil {
+		return false, err
+	}
+	_, err = tmp.Write(nh.marshal())
+	if err == nil {
+		_, err = io.Copy(tmp, r)
+	}
+	if err == nil {
+		err = tmp.Chmod(info.Mode())
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	return true, nil
+}
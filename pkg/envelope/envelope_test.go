@@ -0,0 +1,303 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//keyLines generates keyfile lines for ids. The tests of this package cannot
+//import envelopetest, which builds keyrings for every other package.
+func keyLines(t *testing.T, ids ...string) []string {
+	t.Helper()
+	lines := []string{}
+	for _, id := range ids {
+		line, err := GenerateKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+//keyringOf loads a keyfile made of lines, the first one active
+func keyringOf(t *testing.T, lines ...string) *Keyring {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	kr, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func testKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+	return keyringOf(t, keyLines(t, ids...)...)
+}
+
+func random(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readAll(data []byte, kr *Keyring) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), kr)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	kr := testKeyring(t, "k1")
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, 3*ChunkSize + 7} {
+		plain := random(t, size)
+		out := &bytes.Buffer{}
+		w, err := NewWriter(out, kr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plain)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if size >= 16 && bytes.Contains(out.Bytes(), plain) {
+			t.Fatal("plain text found in the file")
+		}
+		got, err := readAll(out.Bytes(), kr)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: got %d bytes, %v", size, len(got), err)
+		}
+		if _, err := w.Write([]byte("x")); err != ErrClosed {
+			t.Fatalf("write after close: %v", err)
+		}
+	}
+}
+
+func TestUnclosedStreamIsTruncated(t *testing.T) {
+	kr := testKeyring(t, "k1")
+	out := &bytes.Buffer{}
+	w, _ := NewWriter(out, kr)
+	w.Write([]byte("hello"))
+	w.Flush()
+	if _, err := readAll(out.Bytes(), kr); err != ErrTruncated {
+		t.Fatalf("got %v, want ErrTruncated", err)
+	}
+}
+
+//frames splits an encrypted file into its header and frames
+func frames(t *testing.T, data []byte) ([]byte, [][]byte) {
+	t.Helper()
+	h, err := readHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := len(h.marshal())
+	out := [][]byte{}
+	for rest := data[head:]; len(rest) > 0; {
+		n := int(binary.BigEndian.Uint32(rest)&^finalBit) + 4
+		out = append(out, rest[:n])
+		rest = rest[n:]
+	}
+	return data[:head], out
+}
+
+func join(head []byte, frames ...[]byte) []byte {
+	return append(append([]byte{}, head...), bytes.Join(frames, nil)...)
+}
+
+func TestTamperingIsDetected(t *testing.T) {
+	kr := testKeyring(t, "k1")
+	out := &bytes.Buffer{}
+	w, _ := NewWriter(out, kr)
+	for _, chunk := range []string{"first", "second", "third"} {
+		w.Write([]byte(chunk))
+		w.Flush()
+	}
+	w.Close()
+	head, f := frames(t, out.Bytes())
+	if len(f) != 4 {
+		t.Fatalf("got %d frames, want 3 and the final one", len(f))
+	}
+
+	flipped := append([]byte{}, f[1]...)
+	flipped[len(flipped)-1] ^= 1
+	markedFinal := append([]byte{}, f[1]...)
+	markedFinal[0] |= 0x80
+	//a length prefix one shorter, the frame then ends a byte early
+	shorter := append([]byte{}, f[1][:len(f[1])-1]...)
+	binary.BigEndian.PutUint32(shorter, binary.BigEndian.Uint32(shorter)-1)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"intact", out.Bytes(), nil},
+		{"flipped bit", join(head, f[0], flipped, f[2], f[3]), ErrCorrupt},
+		{"length prefix changed", join(head, f[0], shorter, f[2], f[3]), ErrCorrupt},
+		{"data frame marked final", join(head, f[0], markedFinal, f[2], f[3]), ErrCorrupt},
+		{"reordered", join(head, f[1], f[0], f[2], f[3]), ErrCorrupt},
+		{"frame dropped", join(head, f[0], f[2], f[3]), ErrCorrupt},
+		{"final frame dropped", join(head, f[0], f[1], f[2]), ErrTruncated},
+		{"last frames cut", join(head, f[0], f[1]), ErrTruncated},
+		{"final frame moved up", join(head, f[0], f[3]), ErrCorrupt},
+		{"torn frame", join(head, f[0], f[1][:5]), ErrCorrupt},
+		{"data after the end", join(head, f[0], f[1], f[2], f[3], f[2]), ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readAll(tt.data, kr)
+			if err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && string(got) != "firstsecondthird" {
+				t.Fatalf("got %q", got)
+			}
+		})
+	}
+}
+
+func TestFileIsCompleteAfterEveryFlush(t *testing.T) {
+	kr := testKeyring(t, "k1")
+	f, err := os.Create(filepath.Join(t.TempDir(), "audit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := NewWriter(f, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ""
+	for _, line := range []string{"a\n", "bb\n", "ccc\n"} {
+		w.Write([]byte(line))
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		want += line
+		data, _ := ioutil.ReadFile(f.Name())
+		if got, err := readAll(data, kr); err != nil || string(got) != want {
+			t.Fatalf("after a flush: got %q, %v", got, err)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	kr := testKeyring(t, "k1")
+	path := filepath.Join(t.TempDir(), "audit")
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := Append(f, kr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(line))
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		//a writer that never closes leaves a complete file as well
+		f.Close()
+	}
+	data, _ := ioutil.ReadFile(path)
+	if got, err := readAll(data, kr); err != nil || string(got) != "one\ntwo\nthree\n" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestAppendRefusesDamagedFiles(t *testing.T) {
+	kr := testKeyring(t, "k1")
+	out := &bytes.Buffer{}
+	w, _ := NewWriter(out, kr)
+	w.Write([]byte("record"))
+	w.Close()
+	head, f := frames(t, out.Bytes())
+	flipped := append([]byte{}, f[0]...)
+	flipped[4] ^= 1
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"final frame cut", join(head, f[0]), ErrTruncated},
+		{"torn frame", join(head, f[0], f[1][:3]), ErrCorrupt},
+		{"tampered frame", join(head, flipped, f[1]), ErrCorrupt},
+		{"data after the end", join(head, f[0], f[1], []byte("junk")), ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit")
+			ioutil.WriteFile(path, tt.data, 0600)
+			f, err := os.OpenFile(path, os.O_RDWR, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := Append(f, kr); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if after, _ := ioutil.ReadFile(path); !bytes.Equal(after, tt.data) {
+				t.Fatal("a refused file was changed")
+			}
+		})
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	out := &bytes.Buffer{}
+	w, _ := NewWriter(out, testKeyring(t, "k1"))
+	w.Close()
+	if _, err := readAll(out.Bytes(), testKeyring(t, "k2")); err != ErrUnknownKey {
+		t.Fatalf("unknown key id: got %v", err)
+	}
+	if _, err := readAll(out.Bytes(), testKeyring(t, "k1")); err != ErrCorrupt {
+		t.Fatalf("another key with the same id: got %v", err)
+	}
+	if _, err := readAll([]byte("plain text"), testKeyring(t, "k1")); err != ErrNotEncrypted {
+		t.Fatalf("plain file: got %v", err)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	oldKey, newKey := keyLines(t, "old")[0], keyLines(t, "new")[0]
+	path := filepath.Join(t.TempDir(), "rec.wav.enc")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := NewWriter(f, keyringOf(t, oldKey))
+	w.Write([]byte("audio"))
+	w.Close()
+	f.Close()
+
+	//the old key stays in the keyring until every file is rewrapped
+	kr := keyringOf(t, newKey, oldKey)
+	if changed, err := Rewrap(path, kr); err != nil || !changed {
+		t.Fatalf("rewrap: %v, %v", changed, err)
+	}
+	if changed, err := Rewrap(path, kr); err != nil || changed {
+		t.Fatalf("second rewrap: %v, %v", changed, err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if got, err := readAll(data, keyringOf(t, newKey)); err != nil || string(got) != "audio" {
+		t.Fatalf("got %q, %v with the new key alone", got, err)
+	}
+}
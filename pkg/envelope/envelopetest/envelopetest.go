@@ -0,0 +1,54 @@
+//Package envelopetest builds keyfiles and keyrings for the tests of
+//packages encrypting with envelope
+package envelopetest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/envelope"
+)
+
+//KeyLines generates a keyfile line holding a new master key for each of ids
+func KeyLines(t testing.TB, ids ...string) []string {
+	t.Helper()
+	lines := []string{}
+	for _, id := range ids {
+		line, err := envelope.GenerateKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+//Keyfile writes lines, the first one active, to a keyfile in a directory
+//removed with the test and returns its path
+func Keyfile(t testing.TB, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+//Load loads the keyfile at path
+func Load(t testing.TB, path string) *envelope.Keyring {
+	t.Helper()
+	kr, err := envelope.LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+//Keyring returns a keyring of new master keys named ids, the first one
+//active
+func Keyring(t testing.TB, ids ...string) *envelope.Keyring {
+	t.Helper()
+	return Load(t, Keyfile(t, KeyLines(t, ids...)...))
+}
@@ -0,0 +1,95 @@
+package envelope
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of master and data keys, AES-256
+const KeySize = 32
+
+//ErrUnknownKey is returned for files wrapped by a master key that is not in
+//the keyring
+var ErrUnknownKey = errors.New("envelope: master key not in keyring")
+
+//Keyring holds the master keys that wrap data keys. The first key of the
+//keyfile wraps new data keys, the others are kept to open older files until
+//they are rewrapped.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+//LoadKeyring reads a keyfile, one "<id> <base64 key>" per line. Blank lines
+//and lines starting with # are ignored.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	kr := &Keyring{keys: map[string][]byte{}}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"<id> <base64 key>\"", path, n)
+		}
+		id := fields[0]
+		if len(id) > 255 {
+			return nil, fmt.Errorf("%s:%d: key id longer than 255 bytes", path, n)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("%s:%d: key %s is not %d base64 encoded bytes", path, n, id, KeySize)
+		}
+		if _, ok := kr.keys[id]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key id %s", path, n, id)
+		}
+		if kr.active == "" {
+			kr.active = id
+		}
+		kr.keys[id] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if kr.active == "" {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return kr, nil
+}
+
+//Active is the id of the key that wraps new data keys
+func (kr *Keyring) Active() string {
+	return kr.active
+}
+
+func (kr *Keyring) key(id string) ([]byte, error) {
+	key, ok := kr.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+//GenerateKey returns a keyfile line holding a new random key
+func GenerateKey(id string) (string, error) {
+	if id == "" || len(id) > 255 || strings.ContainsAny(id, " \t\n#") {
+		return "", fmt.Errorf("envelope: invalid key id %q", id)
+	}
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return id + " " + base64.StdEncoding.EncodeToString(key), nil
+}
@@ -0,0 +1,215 @@
+package grammar
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+)
+
+// Limits keeping the session setup small enough for a NATS message
+const (
+	MaxContentSize = 64 * 1024
+	MaxPhrases     = 1000
+	MaxBoost       = 20.0
+	srgsNamespace  = "http://www.w3.org/2001/06/grammar"
+)
+
+//ErrNotFound returned when a referenced grammar was never uploaded
+var ErrNotFound = errors.New("grammar not found")
+
+//Grammar is a stored SRGS document or phrase list
+type Grammar struct {
+	ID        string          `json:"id" bson:"_id"`
+	Type      string          `json:"type" bson:"type"`
+	Content   string          `json:"content,omitempty" bson:"content,omitempty"`
+	Phrases   []entity.Phrase `json:"phrases,omitempty" bson:"phrases,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at" bson:"updated_at"`
+}
+
+//Parse validates an uploaded document of the given type
+func Parse(id, typ string, data []byte) (*Grammar, error) {
+	if id == "" {
+		return nil, errors.New("grammar id is required")
+	}
+	if len(data) > MaxContentSize {
+		return nil, fmt.Errorf("grammar is larger than %d bytes", MaxContentSize)
+	}
+	g := &Grammar{ID: id, Type: typ}
+	switch typ {
+	case entity.GrammarSRGS:
+		if err := ValidateSRGS(data); err != nil {
+			return nil, err
+		}
+		g.Content = string(data)
+	case entity.GrammarPhrases:
+		phrases, err := ParsePhrases(data)
+		if err != nil {
+			return nil, err
+		}
+		g.Phrases = phrases
+	default:
+		return nil, fmt.Errorf("unknown grammar type %q", typ)
+	}
+	return g, nil
+}
+
+//Ref returns the grammar in the form forwarded to STT workers
+func (g *Grammar) Ref() entity.GrammarRef {
+	return entity.GrammarRef{
+		ID:      g.ID,
+		Type:    g.Type,
+		Content: g.Content,
+		Phrases: g.Phrases,
+	}
+}
+
+//ValidateInline checks a grammar sent inside a start action
+func ValidateInline(ref *entity.GrammarRef) error {
+	switch ref.Type {
+	case entity.GrammarSRGS:
+		if len(ref.Content) > MaxContentSize {
+			return fmt.Errorf("grammar is larger than %d bytes", MaxContentSize)
+		}
+		return ValidateSRGS([]byte(ref.Content))
+	case entity.GrammarPhrases:
+		return validatePhrases(ref.Phrases)
+	case "":
+		return errors.New("inline grammar needs a type")
+	default:
+		return fmt.Errorf("unknown grammar type %q", ref.Type)
+	}
+}
+
+//ParsePhrases accepts either a JSON array or {"phrases": [...]}, entries are
+//plain strings or {"text": ..., "boost": ...} objects
+func ParsePhrases(data []byte) ([]entity.Phrase, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		wrapper := struct {
+			Phrases []json.RawMessage `json:"phrases"`
+		}{}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return nil, errors.New("phrase list must be a JSON array or an object with phrases")
+		}
+		raw = wrapper.Phrases
+	}
+	phrases := make([]entity.Phrase, 0, len(raw))
+	for i, item := range raw {
+		var text string
+		if err := json.Unmarshal(item, &text); err == nil {
+			phrases = append(phrases, entity.Phrase{Text: text})
+			continue
+		}
+		p := entity.Phrase{}
+		if err := json.Unmarshal(item, &p); err != nil {
+			return nil, fmt.Errorf("phrase #%d is neither a string nor an object", i)
+		}
+		phrases = append(phrases, p)
+	}
+	if err := validatePhrases(phrases); err != nil {
+		return nil, err
+	}
+	return phrases, nil
+}
+
+func validatePhrases(phrases []entity.Phrase) error {
+	if len(phrases) == 0 {
+		return errors.New("phrase list is empty")
+	}
+	if len(phrases) > MaxPhrases {
+		return fmt.Errorf("phrase list has more than %d entries", MaxPhrases)
+	}
+	for i, p := range phrases {
+		if strings.TrimSpace(p.Text) == "" {
+			return fmt.Errorf("phrase #%d has no text", i)
+		}
+		if p.Boost < 0 || p.Boost > MaxBoost {
+			return fmt.Errorf("phrase #%d boost must be between 0 and %.0f", i, MaxBoost)
+		}
+	}
+	return nil
+}
+
+//ValidateSRGS checks that data is a well formed SRGS 1.0 XML voice grammar
+//whose root and local rule references point at defined rules
+func ValidateSRGS(data []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	rules := make(map[string]bool)
+	var refs []string
+	root := ""
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid SRGS XML: %s", err.Error())
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				if t.Name.Local != "grammar" {
+					return errors.New("SRGS root element must be grammar")
+				}
+				if t.Name.Space != "" && t.Name.Space != srgsNamespace {
+					return fmt.Errorf("unexpected SRGS namespace %q", t.Name.Space)
+				}
+				if attr(t, "version") != "1.0" {
+					return errors.New("SRGS version must be 1.0")
+				}
+				if mode := attr(t, "mode"); mode != "" && mode != "voice" {
+					return fmt.Errorf("SRGS mode %q is not supported for recognition", mode)
+				}
+				root = attr(t, "root")
+				continue
+			}
+			switch t.Name.Local {
+			case "rule":
+				id := attr(t, "id")
+				if id == "" {
+					return errors.New("SRGS rule without id")
+				}
+				if rules[id] {
+					return fmt.Errorf("SRGS rule %q is defined twice", id)
+				}
+				rules[id] = true
+			case "ruleref":
+				if uri := attr(t, "uri"); strings.HasPrefix(uri, "#") {
+					refs = append(refs, uri[1:])
+				}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if len(rules) == 0 {
+		return errors.New("SRGS grammar defines no rule")
+	}
+	if root != "" && !rules[root] {
+		return fmt.Errorf("SRGS root rule %q is not defined", root)
+	}
+	for _, ref := range refs {
+		if !rules[ref] {
+			return fmt.Errorf("SRGS ruleref #%s is not defined", ref)
+		}
+	}
+	return nil
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/entity"
+)
+
+const yesNo = `<grammar xmlns="http://www.w3.org/2001/06/grammar" version="1.0" root="answer" mode="voice">
+  <rule id="answer"><one-of><item><ruleref uri="#yes"/></item><item>no</item></one-of></rule>
+  <rule id="yes"><one-of><item>yes</item><item>sure</item></one-of></rule>
+</grammar>`
+
+func TestValidateSRGS(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		problem string
+	}{
+		{"valid", yesNo, ""},
+		{"malformed xml", `<grammar version="1.0"><rule id="a">`, "invalid SRGS XML"},
+		{"plain text", "yes or no", "defines no rule"},
+		{"wrong root", `<rules version="1.0"><rule id="a"/></rules>`, "root element must be grammar"},
+		{"wrong namespace", `<grammar xmlns="urn:other" version="1.0"><rule id="a"/></grammar>`, "unexpected SRGS namespace"},
+		{"no version", `<grammar><rule id="a"/></grammar>`, "version must be 1.0"},
+		{"dtmf mode", `<grammar version="1.0" mode="dtmf"><rule id="a"/></grammar>`, "not supported"},
+		{"no rule", `<grammar version="1.0"></grammar>`, "defines no rule"},
+		{"rule without id", `<grammar version="1.0"><rule/></grammar>`, "rule without id"},
+		{"duplicate rule", `<grammar version="1.0"><rule id="a"/><rule id="a"/></grammar>`, "defined twice"},
+		{"unknown root", `<grammar version="1.0" root="b"><rule id="a"/></grammar>`, `root rule "b"`},
+		{"unknown ruleref", `<grammar version="1.0"><rule id="a"><ruleref uri="#b"/></rule></grammar>`, "ruleref #b"},
+		{"external ruleref", `<grammar version="1.0"><rule id="a"><ruleref uri="http://x/g.grxml"/></rule></grammar>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSRGS([]byte(tt.doc))
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("valid grammar refused: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Fatalf("got %v, want it to mention %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestParsePhrases(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		want  []entity.Phrase
+		error bool
+	}{
+		{"strings", `["balance", "agent"]`, []entity.Phrase{{Text: "balance"}, {Text: "agent"}}, false},
+		{"objects", `[{"text": "agent", "boost": 5}]`, []entity.Phrase{{Text: "agent", Boost: 5}}, false},
+		{"wrapped", `{"phrases": ["balance", {"text": "agent", "boost": 2}]}`, []entity.Phrase{{Text: "balance"}, {Text: "agent", Boost: 2}}, false},
+		{"empty", `[]`, nil, true},
+		{"blank text", `[" "]`, nil, true},
+		{"negative boost", `[{"text": "a", "boost": -1}]`, nil, true},
+		{"boost too high", `[{"text": "a", "boost": 21}]`, nil, true},
+		{"number", `[1]`, nil, true},
+		{"not json", `balance`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePhrases([]byte(tt.data))
+			if tt.error {
+				if err == nil {
+					t.Fatalf("accepted %s as %v", tt.data, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Text != tt.want[i].Text || got[i].Boost != tt.want[i].Boost {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := Parse("", entity.GrammarSRGS, []byte(yesNo)); err == nil {
+		t.Fatal("a grammar without id was accepted")
+	}
+	if _, err := Parse("g", "abnf", []byte(yesNo)); err == nil {
+		t.Fatal("an unknown type was accepted")
+	}
+	big := make([]byte, MaxContentSize+1)
+	if _, err := Parse("g", entity.GrammarSRGS, big); err == nil {
+		t.Fatal("an oversized grammar was accepted")
+	}
+	g, err := Parse("g", entity.GrammarSRGS, []byte(yesNo))
+	if err != nil || g.Content != yesNo {
+		t.Fatalf("got %+v, %v", g, err)
+	}
+}
@@ -0,0 +1,76 @@
+package grammar
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const mongoTimeout = 5 * time.Second
+
+type mongoRepository struct {
+	grammars *mongo.Collection
+}
+
+//NewMongoRepository stores grammars in the grammars collection of db
+func NewMongoRepository(db *mongo.Database) Repository {
+	return &mongoRepository{
+		grammars: db.Collection("grammars"),
+	}
+}
+
+func (r *mongoRepository) Put(g *Grammar) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	_, err := r.grammars.ReplaceOne(ctx, bson.M{"_id": g.ID}, g, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *mongoRepository) Get(id string) (*Grammar, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	g := &Grammar{}
+	err := r.grammars.FindOne(ctx, bson.M{"_id": id}).Decode(g)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (r *mongoRepository) List() ([]*Grammar, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	cur, err := r.grammars.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var out []*Grammar
+	for cur.Next(ctx) {
+		g := &Grammar{}
+		if err := cur.Decode(g); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, cur.Err()
+}
+
+func (r *mongoRepository) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoTimeout)
+	defer cancel()
+	res, err := r.grammars.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package grammar
+
+import (
+	"sort"
+	"sync"
+)
+
+//Repository stores grammars by id
+type Repository interface {
+	Put(g *Grammar) error
+	Get(id string) (*Grammar, error)
+	List() ([]*Grammar, error)
+	Delete(id string) error
+}
+
+type memoryRepository struct {
+	mu       sync.RWMutex
+	grammars map[string]*Grammar
+}
+
+//NewMemoryRepository keeps grammars in process memory
+func NewMemoryRepository() Repository {
+	return &memoryRepository{
+		grammars: make(map[string]*Grammar),
+	}
+}
+
+func (r *memoryRepository) Put(g *Grammar) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.grammars[g.ID] = g
+	return nil
+}
+
+func (r *memoryRepository) Get(id string) (*Grammar, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	g, ok := r.grammars[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return g, nil
+}
+
+func (r *memoryRepository) List() ([]*Grammar, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]*Grammar, 0, len(r.grammars))
+	for _, g := range r.grammars {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (r *memoryRepository) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.grammars[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.grammars, id)
+	return nil
+}
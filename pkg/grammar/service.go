@@ -0,0 +1,106 @@
+package grammar
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+// MaxPerSession bounds how many grammars a single start action may carry
+const MaxPerSession = 16
+
+//ValidationError is returned by Put and Resolve when the client sent a bad
+//grammar, other errors come from the repository
+type ValidationError struct {
+	Problem string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Problem
+}
+
+func invalid(format string, a ...interface{}) error {
+	return &ValidationError{Problem: fmt.Sprintf(format, a...)}
+}
+
+//Service stores uploaded grammars and resolves the ones a session asks for
+type Service struct {
+	repo   Repository
+	logger logger.Logger
+}
+
+//NewService ...
+func NewService(repo Repository, log logger.Logger) *Service {
+	return &Service{
+		repo:   repo,
+		logger: log,
+	}
+}
+
+//Put validates and stores a grammar, replacing an older one with the same id
+func (s *Service) Put(id, typ string, data []byte) (*Grammar, error) {
+	g, err := Parse(id, typ, data)
+	if err != nil {
+		return nil, &ValidationError{Problem: err.Error()}
+	}
+	g.UpdatedAt = time.Now().UTC()
+	if err := s.repo.Put(g); err != nil {
+		return nil, err
+	}
+	s.logger.Info("N", logger.Trace(), "grammar "+id+" stored")
+	return g, nil
+}
+
+//Get ...
+func (s *Service) Get(id string) (*Grammar, error) {
+	return s.repo.Get(id)
+}
+
+//List ...
+func (s *Service) List() ([]*Grammar, error) {
+	return s.repo.List()
+}
+
+//Delete ...
+func (s *Service) Delete(id string) error {
+	return s.repo.Delete(id)
+}
+
+//Resolve validates the grammars of a start action and replaces references
+//by the stored content so workers never have to look them up
+func (s *Service) Resolve(refs []entity.GrammarRef) ([]entity.GrammarRef, error) {
+	if len(refs) > MaxPerSession {
+		return nil, invalid("at most %d grammars per session", MaxPerSession)
+	}
+	out := make([]entity.GrammarRef, 0, len(refs))
+	for i := range refs {
+		ref := refs[i]
+		if ref.Weight < 0 {
+			return nil, invalid("grammar weight must not be negative")
+		}
+		inline := ref.Content != "" || len(ref.Phrases) > 0
+		if !inline {
+			if ref.ID == "" {
+				return nil, invalid("grammar #%d has neither id nor content", i)
+			}
+			g, err := s.repo.Get(ref.ID)
+			if err == ErrNotFound {
+				return nil, invalid("grammar %q does not exist", ref.ID)
+			}
+			if err != nil {
+				return nil, err
+			}
+			resolved := g.Ref()
+			resolved.Weight = ref.Weight
+			out = append(out, resolved)
+			continue
+		}
+		if err := ValidateInline(&ref); err != nil {
+			return nil, invalid("grammar #%d: %s", i, err.Error())
+		}
+		out = append(out, ref)
+	}
+	return out, nil
+}
@@ -0,0 +1,81 @@
+package grammar
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+func newTestService(t *testing.T, repo Repository) *Service {
+	t.Helper()
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(repo, log)
+}
+
+// brokenRepository fails like an unreachable database
+type brokenRepository struct {
+	Repository
+}
+
+var errStorage = errors.New("storage down")
+
+func (brokenRepository) Put(*Grammar) error           { return errStorage }
+func (brokenRepository) Get(string) (*Grammar, error) { return nil, errStorage }
+
+func TestPutErrors(t *testing.T) {
+	s := newTestService(t, brokenRepository{NewMemoryRepository()})
+	_, err := s.Put("g", entity.GrammarSRGS, []byte("<grammar"))
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("bad grammar: got %v, want a validation error", err)
+	}
+	_, err = s.Put("g", entity.GrammarSRGS, []byte(yesNo))
+	if err != errStorage {
+		t.Fatalf("storage failure: got %v, want it passed through", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	s := newTestService(t, NewMemoryRepository())
+	if _, err := s.Put("yesno", entity.GrammarSRGS, []byte(yesNo)); err != nil {
+		t.Fatal(err)
+	}
+	refs, err := s.Resolve([]entity.GrammarRef{
+		{ID: "yesno", Weight: 2},
+		{Type: entity.GrammarPhrases, Phrases: []entity.Phrase{{Text: "agent"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs[0].Content != yesNo || refs[0].Weight != 2 || refs[1].Phrases[0].Text != "agent" {
+		t.Fatalf("resolved %+v", refs)
+	}
+
+	bad := [][]entity.GrammarRef{
+		{{ID: "missing"}},
+		{{}},
+		{{ID: "yesno", Weight: -1}},
+		{{Type: entity.GrammarSRGS, Content: "<grammar"}},
+		{{Content: yesNo}},
+		make([]entity.GrammarRef, MaxPerSession+1),
+	}
+	for i, refs := range bad {
+		if _, err := s.Resolve(refs); err == nil {
+			t.Fatalf("case %d was resolved", i)
+		} else if _, ok := err.(*ValidationError); !ok {
+			t.Fatalf("case %d: got %v, want a validation error", i, err)
+		}
+	}
+}
+
+func TestResolveStorageError(t *testing.T) {
+	s := newTestService(t, brokenRepository{NewMemoryRepository()})
+	_, err := s.Resolve([]entity.GrammarRef{{ID: "yesno"}})
+	if err != errStorage {
+		t.Fatalf("got %v, want the storage error", err)
+	}
+}
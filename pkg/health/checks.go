@@ -0,0 +1,108 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+//NATS checks that a connection of the pool reaches the server
+func NATS(p *stream.Pool) Check {
+	return func(ctx context.Context) error {
+		nc, err := p.Get()
+		if err != nil {
+			return err
+		}
+		defer p.Put(nc)
+		if !nc.IsConnected() {
+			return errors.New("nats connection is " + statusName(nc.Status()))
+		}
+		return nc.FlushWithContext(ctx)
+	}
+}
+
+//STTWorkers checks that a speech worker answers a request on subject.
+//Workers subscribe to it without a queue group, the first answer is enough.
+func STTWorkers(p *stream.Pool, subject string) Check {
+	return func(ctx context.Context) error {
+		nc, err := p.Get()
+		if err != nil {
+			return err
+		}
+		defer p.Put(nc)
+		_, err = nc.RequestWithContext(ctx, subject, nil)
+		if err == nats.ErrNoResponders || err == context.DeadlineExceeded {
+			return errors.New("no STT worker answered on " + subject)
+		}
+		return err
+	}
+}
+
+//Subjects checks that a session can still check out a subject
+func Subjects(m *stream.Manager) Check {
+	return func(ctx context.Context) error {
+		if m.Available() == 0 {
+			return fmt.Errorf("all %d subjects are checked out", m.Size())
+		}
+		return nil
+	}
+}
+
+//Mongo checks that the primary answers
+func Mongo(client *mongo.Client) Check {
+	return func(ctx context.Context) error {
+		return client.Ping(ctx, readpref.Primary())
+	}
+}
+
+//Redis checks that the server at addr answers PING
+func Redis(addr string) Check {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		} else {
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+		}
+		if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+			return err
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line = strings.TrimSpace(line); line != "+PONG" {
+			return errors.New("redis answered " + strconv.Quote(line))
+		}
+		return nil
+	}
+}
+
+func statusName(s nats.Status) string {
+	switch s {
+	case nats.DISCONNECTED:
+		return "disconnected"
+	case nats.CLOSED:
+		return "closed"
+	case nats.RECONNECTING:
+		return "reconnecting"
+	case nats.CONNECTING:
+		return "connecting"
+	}
+	return "not connected"
+}
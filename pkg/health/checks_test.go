@@ -0,0 +1,145 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/utils/logger"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func runNATS(t *testing.T) *natsserver.Server {
+	t.Helper()
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+func probe(check Check) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	return check(ctx)
+}
+
+func TestNATSChecks(t *testing.T) {
+	srv := runNATS(t)
+	pool, err := stream.NewPoolCustom(srv.ClientURL(), 1, func(url string, options ...nats.Option) (*nats.Conn, error) {
+		return nats.Connect(url, append(options, nats.MaxReconnects(-1), nats.ReconnectWait(10*time.Millisecond))...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	if err := probe(NATS(pool)); err != nil {
+		t.Fatal(err)
+	}
+	if err := probe(STTWorkers(pool, "stt.ping")); err == nil || !strings.Contains(err.Error(), "no STT worker") {
+		t.Fatalf("no worker got %v", err)
+	}
+
+	worker, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer worker.Close()
+	worker.Subscribe("stt.ping", func(msg *nats.Msg) { msg.Respond(nil) })
+	worker.Flush()
+	if err := probe(STTWorkers(pool, "stt.ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.Shutdown()
+	if err := probe(NATS(pool)); err == nil {
+		t.Fatal("a stopped server passed")
+	}
+	//the connection goes back to the pool for the next probe
+	if pool.Avail() != 1 {
+		t.Fatalf("%d idle connections", pool.Avail())
+	}
+}
+
+func TestSubjects(t *testing.T) {
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := stream.NewManager("stt", 1, log)
+	if err := probe(Subjects(m)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Checkout(); err != nil {
+		t.Fatal(err)
+	}
+	if err := probe(Subjects(m)); err == nil {
+		t.Fatal("no subject left passed")
+	}
+}
+
+//runRedis answers every line with reply, or nothing when reply is empty
+func runRedis(t *testing.T, reply string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+					if reply != "" {
+						conn.Write([]byte(reply))
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedis(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	tests := []struct {
+		name string
+		addr string
+		ok   bool
+	}{
+		{"pong", runRedis(t, "+PONG\r\n"), true},
+		{"auth required", runRedis(t, "-NOAUTH Authentication required.\r\n"), false},
+		{"silent", runRedis(t, ""), false},
+		{"refused", closed.Addr().String(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := probe(Redis(tt.addr)); (err == nil) != tt.ok {
+				t.Fatalf("got %v", err)
+			}
+		})
+	}
+}
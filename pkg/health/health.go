@@ -0,0 +1,114 @@
+//Package health tells whether the controller can take calls: its
+//dependencies answer, a speech worker is listening and a subject is free.
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check and readiness states
+const (
+	StatusOK       = "ok"
+	StatusFailed   = "failed"
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+	StatusDraining = "draining"
+)
+
+//Check returns nil when the dependency it looks at is usable
+type Check func(ctx context.Context) error
+
+//Result is the outcome of one check
+type Result struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+//Report is the outcome of every check, Status is ready only when all of
+//them passed and the controller is not shutting down
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+//Ready tells whether calls should be sent here
+func (r Report) Ready() bool {
+	return r.Status == StatusReady
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+//Readiness runs the checks deciding whether the controller takes calls
+type Readiness struct {
+	draining int32
+	mu       sync.RWMutex
+	checks   []namedCheck
+}
+
+//NewReadiness ...
+func NewReadiness() *Readiness {
+	return &Readiness{}
+}
+
+//Add registers a check under name
+func (r *Readiness) Add(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, namedCheck{name: name, check: check})
+	sort.Slice(r.checks, func(i, j int) bool { return r.checks[i].name < r.checks[j].name })
+}
+
+//Drain reports not ready from now on so load balancers stop sending calls
+//while the live ones finish
+func (r *Readiness) Drain() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+//Draining tells whether Drain was called
+func (r *Readiness) Draining() bool {
+	return atomic.LoadInt32(&r.draining) == 1
+}
+
+//Run runs every check at once, each one bounded by ctx
+func (r *Readiness) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := r.checks
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			results[i] = Result{Status: StatusOK, DurationMS: float64(time.Since(start)) / float64(time.Millisecond)}
+			if err != nil {
+				results[i].Status = StatusFailed
+				results[i].Error = err.Error()
+			}
+		}(i, c.check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Checks: make(map[string]Result, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusNotReady
+		}
+	}
+	if r.Draining() {
+		report.Status = StatusDraining
+	}
+	return report
+}
@@ -0,0 +1,87 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+	//a hung dependency fails once the probe gives up on it
+	hung := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		drain  bool
+		want   string
+		failed []string
+	}{
+		{"no checks", nil, false, StatusReady, nil},
+		{"all pass", map[string]Check{"nats": ok, "subjects": ok}, false, StatusReady, nil},
+		{"one fails", map[string]Check{"nats": ok, "redis": down}, false, StatusNotReady, []string{"redis"}},
+		{"hung", map[string]Check{"mongo": hung, "nats": ok}, false, StatusNotReady, []string{"mongo"}},
+		{"draining", map[string]Check{"nats": ok}, true, StatusDraining, nil},
+		{"draining wins", map[string]Check{"redis": down}, true, StatusDraining, []string{"redis"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReadiness()
+			for name, check := range tt.checks {
+				r.Add(name, check)
+			}
+			if tt.drain {
+				r.Drain()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			report := r.Run(ctx)
+			if report.Status != tt.want || report.Ready() != (tt.want == StatusReady) {
+				t.Fatalf("status %s, want %s", report.Status, tt.want)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Fatalf("%d checks reported, want %d", len(report.Checks), len(tt.checks))
+			}
+			failed := map[string]bool{}
+			for _, name := range tt.failed {
+				failed[name] = true
+			}
+			for name, res := range report.Checks {
+				if failed[name] != (res.Status == StatusFailed) || failed[name] != (res.Error != "") {
+					t.Fatalf("check %s: %+v", name, res)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessRunsChecksAtOnce(t *testing.T) {
+	r := NewReadiness()
+	started := make(chan struct{})
+	for _, name := range []string{"a", "b", "c"} {
+		r.Add(name, func(ctx context.Context) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil
+		})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan Report)
+	go func() { done <- r.Run(ctx) }()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("checks run one after the other")
+		}
+	}
+	cancel()
+	if report := <-done; !report.Ready() {
+		t.Fatalf("got %+v", report)
+	}
+}
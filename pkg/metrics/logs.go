@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var logDroppedDesc = prometheus.NewDesc(namespace+"_log_dropped_total",
+	"Log lines a sink could not write or had no room to buffer.", []string{"sink"}, nil)
+
+//logCollector reads the drop counters of the log sinks at scrape time
+type logCollector struct {
+	dropped func() map[string]uint64
+}
+
+//RegisterLogSinks exports the lines dropped by each log sink
+func RegisterLogSinks(dropped func() map[string]uint64) error {
+	return prometheus.Register(&logCollector{dropped: dropped})
+}
+
+func (c *logCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- logDroppedDesc
+}
+
+func (c *logCollector) Collect(ch chan<- prometheus.Metric) {
+	for sink, n := range c.dropped() {
+		ch <- prometheus.MustNewConstMetric(logDroppedDesc, prometheus.CounterValue, float64(n), sink)
+	}
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "bello"
+
+var (
+	//AuthSuccesses counts accepted stream credentials by method
+	AuthSuccesses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_success_total",
+		Help:      "Accepted WebSocket client credentials.",
+	}, []string{"method"})
+
+	//AuthFailures counts refused stream credentials by method and reason
+	AuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Refused WebSocket client credentials.",
+	}, []string{"method", "reason"})
+
+	//QuotaRejections counts sessions refused or cut by client limits
+	QuotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_rejections_total",
+		Help:      "Sessions refused or closed because a client limit was reached.",
+	}, []string{"reason"})
+
+	//AdminAuthFailures counts refused admin API credentials
+	AdminAuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admin_auth_failures_total",
+		Help:      "Refused admin API credentials.",
+	}, []string{"method", "reason"})
+
+	//Redactions counts personal data replaced in logs and audit records
+	Redactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redactions_total",
+		Help:      "Personal data matches replaced before logging or auditing.",
+	}, []string{"rule"})
+
+	//RecordingFailures counts recordings that could not be made or stored
+	RecordingFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recording_failures_total",
+		Help:      "Session recordings that could not be started or stored.",
+	})
+
+	//ConfigReloads counts configuration reloads by result: applied, partial
+	//when some changes need a restart, rejected when nothing could be
+	//applied and invalid when the new file does not validate
+	ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Configuration reloads by result.",
+	}, []string{"result"})
+
+	//SessionsActive is the number of open WebSocket sessions
+	SessionsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sessions_active",
+		Help:      "Open WebSocket sessions.",
+	})
+
+	//SessionsClosed counts ended sessions by cause: client, operator,
+	//idle_timeout, request_done, call_flow, call_flow_error, quota, shutdown,
+	//unresponsive, audio_overflow, slow_client or error
+	SessionsClosed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_closed_total",
+		Help:      "Ended WebSocket sessions by close cause.",
+	}, []string{"cause"})
+
+	//AudioBytes counts audio relayed, in from callers to STT and out from
+	//TTS to callers
+	AudioBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audio_bytes_total",
+		Help:      "Audio bytes relayed by direction.",
+	}, []string{"direction"})
+
+	//AudioSeconds counts the duration of the audio relayed
+	AudioSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audio_seconds_total",
+		Help:      "Seconds of audio relayed by direction.",
+	}, []string{"direction"})
+
+	//FirstResultLatency is the time from an accepted start, or from the
+	//previous final result, to the first result of an utterance
+	FirstResultLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "first_result_latency_seconds",
+		Help:      "Time to the first partial or final result of an utterance.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	//FinalResultLatency is the time from an accepted start, or from the
+	//previous final result, to the final result of an utterance
+	FinalResultLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "final_result_latency_seconds",
+		Help:      "Time to the final result of an utterance.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 10),
+	})
+
+	//STTErrors counts STT responses carrying an error by err_code
+	STTErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stt_errors_total",
+		Help:      "STT responses with a non zero err_code.",
+	}, []string{"code"})
+
+	//FramesDropped counts frames a session threw away by queue, audio_in or
+	//results_out, and reason
+	FramesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "frames_dropped_total",
+		Help:      "Audio frames or STT messages a session dropped.",
+	}, []string{"queue", "reason"})
+)
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queueDepthDesc = prometheus.NewDesc(namespace+"_queue_depth",
+		"Frames waiting in the queues of all sessions.", []string{"queue"}, nil)
+	queueMaxDesc = prometheus.NewDesc(namespace+"_queue_depth_max",
+		"Frames waiting in the fullest queue of a session.", []string{"queue"}, nil)
+)
+
+//QueueDepth sums a queue over the live sessions
+type QueueDepth struct {
+	Total int
+	Max   int
+}
+
+//queueCollector reads the session queues at scrape time
+type queueCollector struct {
+	depths func() map[string]QueueDepth
+}
+
+//RegisterQueues exports the depth of the session queues
+func RegisterQueues(depths func() map[string]QueueDepth) error {
+	return prometheus.Register(&queueCollector{depths: depths})
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- queueMaxDesc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for queue, d := range c.depths() {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(d.Total), queue)
+		ch <- prometheus.MustNewConstMetric(queueMaxDesc, prometheus.GaugeValue, float64(d.Max), queue)
+	}
+}
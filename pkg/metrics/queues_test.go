@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueueCollector(t *testing.T) {
+	depths := map[string]QueueDepth{"audio_in": {Total: 8, Max: 5}, "results_out": {}}
+	c := &queueCollector{depths: func() map[string]QueueDepth { return depths }}
+	want := `
+# HELP bello_queue_depth Frames waiting in the queues of all sessions.
+# TYPE bello_queue_depth gauge
+bello_queue_depth{queue="audio_in"} 8
+bello_queue_depth{queue="results_out"} 0
+# HELP bello_queue_depth_max Frames waiting in the fullest queue of a session.
+# TYPE bello_queue_depth_max gauge
+bello_queue_depth_max{queue="audio_in"} 5
+bello_queue_depth_max{queue="results_out"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	subjectsDesc = prometheus.NewDesc(namespace+"_stt_subjects",
+		"STT subjects by state, available or in_use.", []string{"state"}, nil)
+	connectionsDesc = prometheus.NewDesc(namespace+"_nats_connections",
+		"NATS connections by state, idle in the pool or in_use by sessions.", []string{"state"}, nil)
+)
+
+//streamCollector reads the subject manager and the NATS pool at scrape time
+type streamCollector struct {
+	manager *stream.Manager
+	pool    *stream.Pool
+}
+
+//RegisterStream exports the subjects of m and the connections of p
+func RegisterStream(m *stream.Manager, p *stream.Pool) error {
+	return prometheus.Register(&streamCollector{manager: m, pool: p})
+}
+
+func (c *streamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- subjectsDesc
+	ch <- connectionsDesc
+}
+
+func (c *streamCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(subjectsDesc, prometheus.GaugeValue, float64(c.manager.Available()), "available")
+	ch <- prometheus.MustNewConstMetric(subjectsDesc, prometheus.GaugeValue, float64(c.manager.InUse()), "in_use")
+	ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(c.pool.Avail()), "idle")
+	ch <- prometheus.MustNewConstMetric(connectionsDesc, prometheus.GaugeValue, float64(c.pool.InUse()), "in_use")
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/stream"
+	"github.com/4406arthur/bello/utils/logger"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStreamCollector(t *testing.T) {
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	defer srv.Shutdown()
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := stream.NewPool(srv.ClientURL(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	manager := stream.NewManager("stt", 4, log)
+	//the values are read at scrape time, not when the collector is made
+	c := &streamCollector{manager: manager, pool: pool}
+	if _, err := manager.Checkout(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Put(conn)
+
+	want := `
+# HELP bello_nats_connections NATS connections by state, idle in the pool or in_use by sessions.
+# TYPE bello_nats_connections gauge
+bello_nats_connections{state="idle"} 2
+bello_nats_connections{state="in_use"} 1
+# HELP bello_stt_subjects STT subjects by state, available or in_use.
+# TYPE bello_stt_subjects gauge
+bello_stt_subjects{state="available"} 3
+bello_stt_subjects{state="in_use"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,246 @@
+package quota
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultKey names the limits applied to clients without a rule of their own
+const DefaultKey = "default"
+
+// Errors returned when a client is over one of its limits
+var (
+	ErrSessionRate = errors.New("new session rate exceeded")
+	ErrConcurrent  = errors.New("too many concurrent sessions")
+	ErrAudio       = errors.New("daily audio quota exhausted")
+)
+
+//Limits bounds what one client may use, a zero field means unlimited
+type Limits struct {
+	//SessionsPerMinute and SessionBurst shape how fast sessions are opened
+	SessionsPerMinute  float64 `json:"sessions_per_minute" mapstructure:"sessions_per_minute"`
+	SessionBurst       int     `json:"session_burst" mapstructure:"session_burst"`
+	MaxConcurrent      int     `json:"max_concurrent" mapstructure:"max_concurrent"`
+	AudioSecondsPerDay int64   `json:"audio_seconds_per_day" mapstructure:"audio_seconds_per_day"`
+}
+
+//Rule gives a client its own limits, Key is what Key() returns for it
+type Rule struct {
+	Key    string `json:"key" mapstructure:"key"`
+	Limits `mapstructure:",squash"`
+}
+
+//Usage is what a client currently holds
+type Usage struct {
+	Active       int     `json:"active"`
+	AudioSeconds float64 `json:"audio_seconds_today"`
+}
+
+type client struct {
+	bucket *rate.Limiter
+	//limits the bucket was made for, it is rebuilt when they change
+	shaped Limits
+	active int
+	day    string
+	audio  time.Duration
+}
+
+//Limiter tracks sessions and audio of every client in memory. Counters are
+//per instance, each controller enforces its own share.
+type Limiter struct {
+	mu       sync.Mutex
+	defaults Limits
+	rules    map[string]Limits
+	clients  map[string]*client
+	today    string
+	now      func() time.Time
+}
+
+//NewLimiter ...
+func NewLimiter(defaults Limits, rules []Rule) *Limiter {
+	l := &Limiter{
+		defaults: defaults,
+		rules:    make(map[string]Limits, len(rules)),
+		clients:  make(map[string]*client),
+		now:      time.Now,
+	}
+	for _, r := range rules {
+		l.rules[r.Key] = r.Limits
+	}
+	return l
+}
+
+//Key identifies a client by its authenticated subject, or by its remote IP
+//when it is anonymous. The uid a client reports is never trusted since
+//changing it would reset the limits.
+func Key(subject, ip string) string {
+	if subject != "" {
+		return "client:" + subject
+	}
+	return "ip:" + ip
+}
+
+//Acquire admits a new session for key. The lease must be released when the
+//session ends.
+func (l *Limiter) Acquire(key string) (*Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limits := l.limitsOf(key)
+	c := l.client(key)
+
+	if limits.AudioSecondsPerDay > 0 && c.audio >= time.Duration(limits.AudioSecondsPerDay)*time.Second {
+		return nil, ErrAudio
+	}
+	if limits.MaxConcurrent > 0 && c.active >= limits.MaxConcurrent {
+		return nil, ErrConcurrent
+	}
+	if limits.SessionsPerMinute > 0 {
+		if c.bucket == nil || c.shaped != limits {
+			burst := limits.SessionBurst
+			if burst <= 0 {
+				burst = 1
+			}
+			c.bucket = rate.NewLimiter(rate.Limit(limits.SessionsPerMinute/60), burst)
+			c.shaped = limits
+		}
+		if !c.bucket.AllowN(l.now(), 1) {
+			return nil, ErrSessionRate
+		}
+	}
+	c.active++
+	return &Lease{limiter: l, key: key}, nil
+}
+
+//limitsOf must be called with mu held
+func (l *Limiter) limitsOf(key string) Limits {
+	if limits, ok := l.rules[key]; ok {
+		return limits
+	}
+	return l.defaults
+}
+
+//client returns the counters of key for today, must be called with mu held
+func (l *Limiter) client(key string) *client {
+	day := l.day()
+	if day != l.today {
+		//forget idle clients once a day so the map does not keep every IP
+		for k, c := range l.clients {
+			if c.active == 0 {
+				delete(l.clients, k)
+			}
+		}
+		l.today = day
+	}
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{day: day}
+		l.clients[key] = c
+	}
+	if c.day != day {
+		c.day = day
+		c.audio = 0
+	}
+	return c
+}
+
+//day is the UTC date audio quotas are counted for
+func (l *Limiter) day() string {
+	return l.now().UTC().Format("2006-01-02")
+}
+
+//Defaults ...
+func (l *Limiter) Defaults() Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.defaults
+}
+
+//Rules returns the per client limits
+func (l *Limiter) Rules() []Rule {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rules := make([]Rule, 0, len(l.rules))
+	for key, limits := range l.rules {
+		rules = append(rules, Rule{Key: key, Limits: limits})
+	}
+	return rules
+}
+
+//Set changes the limits of key, DefaultKey changes the defaults
+func (l *Limiter) Set(key string, limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if key == DefaultKey {
+		l.defaults = limits
+		return
+	}
+	l.rules[key] = limits
+}
+
+//Delete drops the rule of key so the defaults apply again
+func (l *Limiter) Delete(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.rules[key]
+	delete(l.rules, key)
+	return ok
+}
+
+//Limits returns what applies to key and what it currently uses. Looking a
+//key up does not start tracking it.
+func (l *Limiter) Limits(key string) (Limits, Usage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	usage := Usage{}
+	if c, ok := l.clients[key]; ok {
+		usage.Active = c.active
+		if c.day == l.day() {
+			usage.AudioSeconds = c.audio.Seconds()
+		}
+	}
+	return l.limitsOf(key), usage
+}
+
+//Lease is held by an admitted session. A nil lease means the session is
+//not limited.
+type Lease struct {
+	limiter  *Limiter
+	key      string
+	released bool
+}
+
+//Consume adds streamed audio to the daily total and returns ErrAudio once
+//it goes over the quota
+func (l *Lease) Consume(d time.Duration) error {
+	if l == nil {
+		return nil
+	}
+	lm := l.limiter
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	c := lm.client(l.key)
+	c.audio += d
+	limits := lm.limitsOf(l.key)
+	if limits.AudioSecondsPerDay > 0 && c.audio > time.Duration(limits.AudioSecondsPerDay)*time.Second {
+		return ErrAudio
+	}
+	return nil
+}
+
+//Release frees the concurrent session slot, it is safe to call twice
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+	lm := l.limiter
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if l.released {
+		return
+	}
+	l.released = true
+	lm.client(l.key).active--
+}
@@ -0,0 +1,152 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLimiter(defaults Limits, rules ...Rule) (*Limiter, *time.Time) {
+	l := NewLimiter(defaults, rules)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		subject, ip, want string
+	}{
+		{"acme", "10.0.0.1", "client:acme"},
+		{"", "10.0.0.1", "ip:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := Key(tt.subject, tt.ip); got != tt.want {
+			t.Fatalf("Key(%q, %q) = %q, want %q", tt.subject, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	l, _ := newTestLimiter(Limits{MaxConcurrent: 2})
+	a, err := l.Acquire("ip:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire("ip:a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire("ip:a"); err != ErrConcurrent {
+		t.Fatalf("third session: got %v, want ErrConcurrent", err)
+	}
+	if _, err := l.Acquire("ip:b"); err != nil {
+		t.Fatalf("another client was limited: %v", err)
+	}
+	a.Release()
+	a.Release()
+	if _, usage := l.Limits("ip:a"); usage.Active != 1 {
+		t.Fatalf("%d active after a double release, want 1", usage.Active)
+	}
+	if _, err := l.Acquire("ip:a"); err != nil {
+		t.Fatalf("released slot not reused: %v", err)
+	}
+}
+
+func TestSessionRate(t *testing.T) {
+	l, now := newTestLimiter(Limits{SessionsPerMinute: 60, SessionBurst: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := l.Acquire("ip:a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Acquire("ip:a"); err != ErrSessionRate {
+		t.Fatalf("over the burst: got %v, want ErrSessionRate", err)
+	}
+	*now = now.Add(time.Second)
+	if _, err := l.Acquire("ip:a"); err != nil {
+		t.Fatalf("a token was not refilled after a second: %v", err)
+	}
+}
+
+func TestAudioQuota(t *testing.T) {
+	l, now := newTestLimiter(Limits{AudioSecondsPerDay: 60})
+	lease, err := l.Acquire("ip:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lease.Consume(59 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := lease.Consume(2 * time.Second); err != ErrAudio {
+		t.Fatalf("over the quota: got %v, want ErrAudio", err)
+	}
+	lease.Release()
+	if _, err := l.Acquire("ip:a"); err != ErrAudio {
+		t.Fatalf("new session with the quota used: got %v, want ErrAudio", err)
+	}
+	*now = now.Add(24 * time.Hour)
+	if _, usage := l.Limits("ip:a"); usage.AudioSeconds != 0 {
+		t.Fatalf("%v seconds counted the next day", usage.AudioSeconds)
+	}
+	if _, err := l.Acquire("ip:a"); err != nil {
+		t.Fatalf("quota not reset the next day: %v", err)
+	}
+}
+
+func TestRules(t *testing.T) {
+	l, _ := newTestLimiter(Limits{MaxConcurrent: 1}, Rule{Key: "client:big", Limits: Limits{MaxConcurrent: 3}})
+	for i := 0; i < 3; i++ {
+		if _, err := l.Acquire("client:big"); err != nil {
+			t.Fatalf("session %d of a client with a rule: %v", i, err)
+		}
+	}
+	l.Set("client:big", Limits{MaxConcurrent: 3, AudioSecondsPerDay: 10})
+	if limits, _ := l.Limits("client:big"); limits.AudioSecondsPerDay != 10 {
+		t.Fatalf("rule not changed: %+v", limits)
+	}
+	if !l.Delete("client:big") || l.Delete("client:big") {
+		t.Fatal("Delete did not report the rule once")
+	}
+	l.Set(DefaultKey, Limits{MaxConcurrent: 3})
+	if l.Defaults().MaxConcurrent != 3 || len(l.Rules()) != 0 {
+		t.Fatalf("defaults %+v, rules %v", l.Defaults(), l.Rules())
+	}
+	if _, err := l.Acquire("client:big"); err != ErrConcurrent {
+		t.Fatalf("defaults not applied once the rule is gone: %v", err)
+	}
+}
+
+func TestLimitsDoesNotTrack(t *testing.T) {
+	l, _ := newTestLimiter(Limits{})
+	for _, key := range []string{"ip:1", "ip:2", "client:x"} {
+		if _, usage := l.Limits(key); usage != (Usage{}) {
+			t.Fatalf("unseen %s uses %+v", key, usage)
+		}
+	}
+	if len(l.clients) != 0 {
+		t.Fatalf("looking keys up tracked %d clients", len(l.clients))
+	}
+}
+
+func TestIdleClientsForgotten(t *testing.T) {
+	l, now := newTestLimiter(Limits{})
+	held, _ := l.Acquire("ip:held")
+	idle, _ := l.Acquire("ip:idle")
+	idle.Release()
+	*now = now.Add(24 * time.Hour)
+	l.Acquire("ip:new")
+	if _, ok := l.clients["ip:idle"]; ok {
+		t.Fatal("idle client kept past the day")
+	}
+	if _, ok := l.clients["ip:held"]; !ok {
+		t.Fatal("client with an active session forgotten")
+	}
+	held.Release()
+}
+
+func TestNilLease(t *testing.T) {
+	var l *Lease
+	if err := l.Consume(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	l.Release()
+}
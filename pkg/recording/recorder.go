@@ -0,0 +1,242 @@
+package recording
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/audio"
+	"github.com/4406arthur/bello/pkg/envelope"
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+//tempPrefix starts the names of recordings in progress
+const tempPrefix = "bello-rec-"
+
+//staleTemp is how long a recording in progress can go unwritten before it
+//is taken for the leftover of a crashed session
+const staleTemp = time.Hour
+
+//Recorder makes WAV recordings of sessions and hands them to a store
+type Recorder struct {
+	store Store
+	//tmpDir holds recordings in progress, the system default when empty
+	tmpDir    string
+	retention time.Duration
+	//keys encrypts recordings, they are plain WAV files when nil
+	keys   *envelope.Keyring
+	logger logger.Logger
+}
+
+//NewRecorder ...
+func NewRecorder(store Store, tmpDir string, retention time.Duration, keys *envelope.Keyring, log logger.Logger) *Recorder {
+	return &Recorder{
+		store:     store,
+		tmpDir:    tmpDir,
+		retention: retention,
+		keys:      keys,
+		logger:    log,
+	}
+}
+
+//Start opens the recording of a session, audio is 16 bit mono PCM at
+//sampleRate
+func (r *Recorder) Start(sessionID string, sampleRate int) (*Recording, error) {
+	if sampleRate <= 0 {
+		sampleRate = audio.DefaultSampleRate
+	}
+	f, err := ioutil.TempFile(r.tmpDir, tempPrefix+"*.wav")
+	if err != nil {
+		return nil, err
+	}
+	rec := &Recording{
+		recorder:   r,
+		name:       time.Now().UTC().Format("2006/01/02/") + sessionID + ".wav",
+		file:       f,
+		sampleRate: sampleRate,
+	}
+	var w io.Writer = f
+	if r.keys != nil {
+		//audio never reaches the disk in the clear
+		if rec.sealer, err = envelope.NewWriter(f, r.keys); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+		rec.name += ".enc"
+		w = rec.sealer
+	}
+	rec.buf = bufio.NewWriter(w)
+	//placeholder header, sizes are filled in by Finish
+	if _, err := rec.buf.Write(wavHeader(sampleRate, 0)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return rec, nil
+}
+
+//Prune applies the retention every interval until ctx is done. It also
+//removes the recordings in progress a crashed process left behind.
+func (r *Recorder) Prune(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n := r.pruneTemp(time.Now().Add(-staleTemp)); n > 0 {
+			r.logger.Info("NA", logger.Trace(), "removed "+strconv.Itoa(n)+" abandoned recordings")
+		}
+		if r.retention > 0 {
+			n, err := r.store.Prune(time.Now().Add(-r.retention))
+			if err != nil {
+				r.logger.Error("NA", logger.Trace(), "recording retention: "+err.Error())
+			}
+			if n > 0 {
+				r.logger.Info("NA", logger.Trace(), "recording retention removed old recordings")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//pruneTemp removes the recordings in progress not written to since before
+func (r *Recorder) pruneTemp(before time.Time) int {
+	dir := r.tmpDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, tempPrefix+"*"))
+	n := 0
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || !info.ModTime().Before(before) {
+			continue
+		}
+		if os.Remove(path) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+//Recording is the audio of one session, it is safe for concurrent use
+type Recording struct {
+	recorder   *Recorder
+	name       string
+	sampleRate int
+
+	mu     sync.Mutex
+	file   *os.File
+	sealer *envelope.Writer
+	buf    *bufio.Writer
+	size   uint32
+	closed bool
+	err    error
+}
+
+//Write appends a frame of caller audio, frames after Finish are dropped. A
+//nil recording ignores them.
+func (rec *Recording) Write(frame []byte) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed || rec.err != nil {
+		return
+	}
+	if _, err := rec.buf.Write(frame); err != nil {
+		rec.err = err
+		return
+	}
+	rec.size += uint32(len(frame))
+}
+
+//Finish completes the WAV file and stores it, it returns the URI of the
+//recording. The upload is abandoned when ctx is done.
+func (rec *Recording) Finish(ctx context.Context) (string, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return "", os.ErrClosed
+	}
+	rec.closed = true
+	path := rec.file.Name()
+	err := rec.err
+	if err == nil {
+		err = rec.buf.Flush()
+	}
+	if err == nil && rec.sealer != nil {
+		err = rec.sealer.Close()
+	}
+	if err == nil && rec.sealer == nil {
+		err = writeWAVHeader(rec.file, rec.sampleRate, rec.size)
+	}
+	if cerr := rec.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && rec.sealer != nil {
+		var sealed string
+		sealed, err = rec.reseal(path)
+		os.Remove(path)
+		path = sealed
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	uri, err := rec.recorder.store.Save(ctx, rec.name, path)
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return uri, nil
+}
+
+//reseal copies the encrypted recording at path under a new data key with
+//the final WAV header, an encrypted file cannot be patched in place
+func (rec *Recording) reseal(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	r, err := envelope.NewReader(in, rec.recorder.keys)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, wavHeaderSize); err != nil {
+		return "", err
+	}
+	out, err := ioutil.TempFile(rec.recorder.tmpDir, tempPrefix+"*.wav.enc")
+	if err != nil {
+		return "", err
+	}
+	w, err := envelope.NewWriter(out, rec.recorder.keys)
+	if err == nil {
+		_, err = w.Write(wavHeader(rec.sampleRate, rec.size))
+	}
+	if err == nil {
+		_, err = io.Copy(w, r)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
@@ -0,0 +1,116 @@
+package recording
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+func testRecorder(t *testing.T, store Store, tmpDir string) *Recorder {
+	t.Helper()
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRecorder(store, tmpDir, 0, nil, log)
+}
+
+func TestFinishStoresRecording(t *testing.T) {
+	tmp, dir := t.TempDir(), t.TempDir()
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := testRecorder(t, store, tmp)
+	rec, err := r.Start("s1", 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Write(make([]byte, 320))
+	uri, err := rec.Finish(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := strings.TrimPrefix(uri, "file://")
+	if info, err := os.Stat(path); err != nil || info.Size() != wavHeaderSize+320 {
+		t.Fatalf("stored %s: %v", uri, err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(tmp, "*")); len(left) != 0 {
+		t.Fatalf("temporary files left: %v", left)
+	}
+	if _, err := rec.Finish(context.Background()); err != os.ErrClosed {
+		t.Fatalf("second finish: %v", err)
+	}
+}
+
+func TestFinishGivesUpWithContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	store, err := NewS3Store(S3Config{Endpoint: srv.URL, Bucket: "rec"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	rec, err := testRecorder(t, store, tmp).Start("s1", 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := rec.Finish(ctx); err == nil {
+		t.Fatal("a stalled upload did not give up")
+	}
+	if left, _ := filepath.Glob(filepath.Join(tmp, "*")); len(left) != 0 {
+		t.Fatalf("temporary files left: %v", left)
+	}
+}
+
+func TestPruneTemp(t *testing.T) {
+	tmp := t.TempDir()
+	old := time.Now().Add(-2 * staleTemp)
+	for _, name := range []string{"bello-rec-1.wav", "bello-rec-2.wav.enc", "bello-rec-3.wav", "other.wav"} {
+		path := filepath.Join(tmp, name)
+		if err := ioutil.WriteFile(path, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if name != "bello-rec-3.wav" {
+			os.Chtimes(path, old, old)
+		}
+	}
+	r := testRecorder(t, nil, tmp)
+	if n := r.pruneTemp(time.Now().Add(-staleTemp)); n != 2 {
+		t.Fatalf("removed %d files, want 2", n)
+	}
+	left, _ := filepath.Glob(filepath.Join(tmp, "*"))
+	if len(left) != 2 || filepath.Base(left[0]) != "bello-rec-3.wav" || filepath.Base(left[1]) != "other.wav" {
+		t.Fatalf("left %v", left)
+	}
+}
+
+func TestPruneStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	r := testRecorder(t, nil, t.TempDir())
+	go func() {
+		r.Prune(ctx, time.Millisecond)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Prune kept running")
+	}
+}
@@ -0,0 +1,85 @@
+package recording
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//Store keeps finished recordings
+type Store interface {
+	//Save stores the file at path under name and returns its URI, it gives
+	//up when ctx is done
+	Save(ctx context.Context, name, path string) (string, error)
+	//Prune deletes recordings made before the given time
+	Prune(before time.Time) (int, error)
+}
+
+type localStore struct {
+	dir string
+}
+
+//NewLocalStore keeps recordings under dir
+func NewLocalStore(dir string) (Store, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0700); err != nil {
+		return nil, err
+	}
+	return &localStore{dir: abs}, nil
+}
+
+func (s *localStore) Save(ctx context.Context, name, path string) (string, error) {
+	dest := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return "", err
+	}
+	//a rename is enough when the temporary file is on the same device
+	if err := os.Rename(path, dest); err != nil {
+		if err := copyFile(path, dest); err != nil {
+			return "", err
+		}
+		os.Remove(path)
+	}
+	return "file://" + filepath.ToSlash(dest), nil
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	return out.Close()
+}
+
+func (s *localStore) Prune(before time.Time) (int, error) {
+	n := 0
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !info.ModTime().Before(before) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
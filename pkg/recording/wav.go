@@ -0,0 +1,36 @@
+package recording
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// wavHeaderSize is the size of a canonical RIFF/WAVE header for PCM
+const wavHeaderSize = 44
+
+//wavHeader describes dataSize bytes of 16 bit mono little endian PCM
+func wavHeader(sampleRate int, dataSize uint32) []byte {
+	const channels, bits = 1, 16
+	h := make([]byte, wavHeaderSize)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 36+dataSize)
+	copy(h[8:], "WAVE")
+	copy(h[12:], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(h[22:], channels)
+	binary.LittleEndian.PutUint32(h[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(h[28:], uint32(sampleRate*channels*bits/8))
+	binary.LittleEndian.PutUint16(h[32:], channels*bits/8)
+	binary.LittleEndian.PutUint16(h[34:], bits)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], dataSize)
+	return h
+}
+
+//writeWAVHeader rewrites the header at the start of w once the data size
+//is known
+func writeWAVHeader(w io.WriterAt, sampleRate int, dataSize uint32) error {
+	_, err := w.WriteAt(wavHeader(sampleRate, dataSize), 0)
+	return err
+}
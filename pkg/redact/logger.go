@@ -0,0 +1,79 @@
+package redact
+
+import (
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/sirupsen/logrus"
+)
+
+//Logger redacts every message and context field before the wrapped logger writes it to files
+//or Elasticsearch. Redaction happens in the underlying logrus logger, so
+//lines written to GetLogger directly, like the request log, are covered too.
+type Logger struct {
+	next logger.Logger
+}
+
+//NewLogger installs r on the logrus logger of next
+func NewLogger(next logger.Logger, r *Redactor) *Logger {
+	Install(next.GetLogger(), r)
+	return &Logger{next: next}
+}
+
+//Debug ...
+func (l *Logger) Debug(direction string, i *logger.LogInfo, msg string) {
+	l.next.Debug(direction, i, msg)
+}
+
+//Info ...
+func (l *Logger) Info(direction string, i *logger.LogInfo, msg string) {
+	l.next.Info(direction, i, msg)
+}
+
+//Error ...
+func (l *Logger) Error(direction string, i *logger.LogInfo, msg string) {
+	l.next.Error(direction, i, msg)
+}
+
+//Fatal ...
+func (l *Logger) Fatal(direction string, i *logger.LogInfo, msg string) {
+	l.next.Fatal(direction, i, msg)
+}
+
+//GetLogger returns the underlying logrus logger, which redacts as well
+func (l *Logger) GetLogger() *logrus.Logger {
+	return l.next.GetLogger()
+}
+
+//Install puts a hook redacting the message and string fields of every entry
+//ahead of the hooks of l, so neither the sinks nor the formatter ever see
+//the original text. It must be called before l is used concurrently.
+func Install(l *logrus.Logger, r *Redactor) {
+	hooks := logrus.LevelHooks{}
+	hooks.Add(hook{redactor: r})
+	for level, next := range l.Hooks {
+		hooks[level] = append(hooks[level], next...)
+	}
+	l.ReplaceHooks(hooks)
+}
+
+type hook struct {
+	redactor *Redactor
+}
+
+func (h hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+//Fire replaces the fields rather than changing them, the map may be shared
+//with an entry other goroutines log through
+func (h hook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.redactor.String(entry.Message)
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		if s, ok := v.(string); ok {
+			v = h.redactor.String(s)
+		}
+		data[k] = v
+	}
+	entry.Data = data
+	return nil
+}
@@ -0,0 +1,179 @@
+package redact
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Built-in rule names
+const (
+	NationalID = "tw_national_id"
+	CreditCard = "credit_card"
+	Phone      = "phone"
+	Account    = "account"
+)
+
+//ID labels matches shaped like a national ID whose checksum is wrong
+const ID = "id"
+
+//Rule replaces every match of Pattern
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	//Label names what a match is when the shape alone cannot tell, nil
+	//labels every match Name. It never keeps a match from being replaced,
+	//mistyped numbers are as personal as valid ones.
+	Label func(match string) string
+	//Replacement defaults to [REDACTED:<label>]
+	Replacement string
+}
+
+//Pattern is a rule defined in the configuration
+type Pattern struct {
+	Name        string `json:"name" mapstructure:"name"`
+	Regex       string `json:"regex" mapstructure:"regex"`
+	Replacement string `json:"replacement" mapstructure:"replacement"`
+}
+
+//Builtins returns the rules shipped with bello. Order matters: card numbers
+//are checked before the generic account number rule can claim them, those
+//failing the Luhn check are labelled accounts.
+func Builtins() []Rule {
+	return []Rule{
+		{
+			Name:    NationalID,
+			Pattern: regexp.MustCompile(`\b[A-Za-z][1289]\d{8}\b`),
+			Label:   labelIf(validNationalID, NationalID, ID),
+		},
+		{
+			Name:    CreditCard,
+			Pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+			Label:   labelIf(luhn, CreditCard, Account),
+		},
+		{
+			Name:    Phone,
+			Pattern: regexp.MustCompile(`(?:\+886[ -]?|\b0)(?:9\d{2}[ -]?\d{3}[ -]?\d{3}|[2-8]\d?[ -]?\d{3,4}[ -]?\d{4})\b`),
+		},
+		{
+			Name:    Account,
+			Pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){9,15}\b`),
+		},
+	}
+}
+
+//Redactor applies rules in order
+type Redactor struct {
+	rules []Rule
+	//Hit is called with the rule name of every replaced match
+	Hit func(rule string)
+}
+
+//New picks the named built-in rules, all of them when names is empty, and
+//appends the configured patterns
+func New(names []string, patterns []Pattern) (*Redactor, error) {
+	r := &Redactor{}
+	builtins := Builtins()
+	if len(names) == 0 {
+		r.rules = builtins
+	}
+	for _, name := range names {
+		found := false
+		for _, b := range builtins {
+			if b.Name == name {
+				r.rules = append(r.rules, b)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("unknown redaction rule " + name)
+		}
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, errors.New("redaction pattern " + p.Name + ": " + err.Error())
+		}
+		r.rules = append(r.rules, Rule{Name: p.Name, Pattern: re, Replacement: p.Replacement})
+	}
+	return r, nil
+}
+
+//String returns s with every sensitive match replaced
+func (r *Redactor) String(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+	for _, rule := range r.rules {
+		s = rule.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+			label := rule.Name
+			if rule.Label != nil {
+				label = rule.Label(match)
+			}
+			if r.Hit != nil {
+				r.Hit(label)
+			}
+			if rule.Replacement != "" {
+				return rule.Replacement
+			}
+			return "[REDACTED:" + label + "]"
+		})
+	}
+	return s
+}
+
+//labelIf labels matches passing check valid, the others invalid
+func labelIf(check func(string) bool, valid, invalid string) func(string) string {
+	return func(match string) string {
+		if check(match) {
+			return valid
+		}
+		return invalid
+	}
+}
+
+// nationalIDLetters maps the leading letter to its two digit area code
+var nationalIDLetters = map[byte]int{
+	'A': 10, 'B': 11, 'C': 12, 'D': 13, 'E': 14, 'F': 15, 'G': 16, 'H': 17,
+	'I': 34, 'J': 18, 'K': 19, 'L': 20, 'M': 21, 'N': 22, 'O': 35, 'P': 23,
+	'Q': 24, 'R': 25, 'S': 26, 'T': 27, 'U': 28, 'V': 29, 'W': 32, 'X': 30,
+	'Y': 31, 'Z': 33,
+}
+
+//validNationalID checks the checksum of a Taiwanese national ID or resident
+//certificate number
+func validNationalID(id string) bool {
+	id = strings.ToUpper(id)
+	code, ok := nationalIDLetters[id[0]]
+	if !ok || len(id) != 10 {
+		return false
+	}
+	sum := code/10 + (code%10)*9
+	weights := []int{8, 7, 6, 5, 4, 3, 2, 1, 1}
+	for i, w := range weights {
+		sum += int(id[i+1]-'0') * w
+	}
+	return sum%10 == 0
+}
+
+//luhn checks the card number checksum, separators are ignored
+func luhn(number string) bool {
+	sum, double, digits := 0, false, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
@@ -0,0 +1,115 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/4406arthur/bello/utils/logger"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestBuiltins(t *testing.T) {
+	r, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, in, want string
+	}{
+		{"national id", "id A123456789 ok", "id [REDACTED:tw_national_id] ok"},
+		{"lower case national id", "a123456789", "[REDACTED:tw_national_id]"},
+		{"resident certificate", "A800000014", "[REDACTED:tw_national_id]"},
+		{"national id with a bad checksum", "A123456788", "[REDACTED:id]"},
+		{"card", "card 4111111111111111.", "card [REDACTED:credit_card]."},
+		{"card with separators", "4111 1111 1111 1111", "[REDACTED:credit_card]"},
+		{"card with dashes", "5500-0000-0000-0004", "[REDACTED:credit_card]"},
+		{"card failing luhn is an account", "4111111111111112", "[REDACTED:account]"},
+		{"mobile", "call 0912-345-678", "call [REDACTED:phone]"},
+		{"mobile without separators", "0912345678", "[REDACTED:phone]"},
+		{"international mobile", "+886 912 345 678", "[REDACTED:phone]"},
+		{"landline", "02-2345-6789", "[REDACTED:phone]"},
+		{"account", "account 1234567890123", "account [REDACTED:account]"},
+		{"short account", "account 1234567890", "account [REDACTED:account]"},
+		{"short numbers stay", "order 12345 at 10:30", "order 12345 at 10:30"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.String(tt.in); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabels(t *testing.T) {
+	r, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits := []string{}
+	r.Hit = func(rule string) { hits = append(hits, rule) }
+	r.String("A123456788 A123456789 4111111111111112 4111111111111111")
+	if strings.Join(hits, ",") != "id,tw_national_id,account,credit_card" {
+		t.Fatalf("hits %v", hits)
+	}
+}
+
+func TestNew(t *testing.T) {
+	r, err := New([]string{Phone}, []Pattern{{Name: "member", Regex: `M\d{6}`, Replacement: "[MEMBER]"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits := []string{}
+	r.Hit = func(rule string) { hits = append(hits, rule) }
+	got := r.String("M123456 at 0912345678 paid with 4111111111111111")
+	if got != "[MEMBER] at [REDACTED:phone] paid with 4111111111111111" {
+		t.Fatalf("got %q", got)
+	}
+	if strings.Join(hits, ",") != "phone,member" {
+		t.Fatalf("hits %v", hits)
+	}
+
+	if _, err := New([]string{"passport"}, nil); err == nil {
+		t.Fatal("an unknown rule was accepted")
+	}
+	if _, err := New(nil, []Pattern{{Name: "broken", Regex: "("}}); err == nil {
+		t.Fatal("a broken pattern was accepted")
+	}
+	var none *Redactor
+	if none.String("A123456789") != "A123456789" {
+		t.Fatal("a nil redactor changed the text")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	r, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := test.NewLocal(base.GetLogger())
+	log := logger.With(NewLogger(base, r), logger.Fields{"uid": "A123456789", "session": "s1"})
+
+	log.Info("N", logger.Trace(), "caller said 0912345678")
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("nothing logged")
+	}
+	if entry.Message != "caller said [REDACTED:phone]" {
+		t.Fatalf("message %q", entry.Message)
+	}
+	if entry.Data["uid"] != "[REDACTED:tw_national_id]" || entry.Data["session"] != "s1" {
+		t.Fatalf("fields %v", entry.Data)
+	}
+
+	//the request log writes to the logrus logger directly
+	base.GetLogger().WithField("path", "/users/A123456789").Info("GET /users/A123456789")
+	entry = hook.LastEntry()
+	if entry.Message != "GET /users/[REDACTED:tw_national_id]" || entry.Data["path"] != "/users/[REDACTED:tw_national_id]" {
+		t.Fatalf("request log %q, %v", entry.Message, entry.Data)
+	}
+}
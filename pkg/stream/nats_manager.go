@@ -4,52 +4,112 @@ import (
 	"errors"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/4406arthur/bello/utils/logger"
 )
 
 type Manager struct {
-	prefix   string
-	subjects chan string
-	logger   logger.Logger
+	prefix string
+	logger logger.Logger
+
+	mu   sync.Mutex
+	size int
+	free []string
+	busy map[string]bool
 }
 
 func NewManager(pre string, size int, log logger.Logger) *Manager {
-	subs := make([]string, 0, size)
-	for i := 0; i < size; i++ {
-		subs = append(subs, pre+"-"+strconv.Itoa(i))
-	}
-	log.Debug("N", logger.Trace(), "all subjects: "+strings.Join(subs, ", "))
 	m := Manager{
-		prefix:   pre,
-		subjects: make(chan string, size),
-		logger:   log,
+		prefix: pre,
+		logger: log,
+		busy:   map[string]bool{},
 	}
-
-	for i := range subs {
-		m.subjects <- subs[i]
-	}
-
+	m.Resize(size)
+	log.Debug("N", logger.Trace(), "all subjects: "+strings.Join(m.free, ", "))
 	return &m
 }
 
 func (m *Manager) Checkout() (string, error) {
-	select {
-	case sub := <-m.subjects:
-		m.logger.Debug("N", logger.Trace(), "get subject: "+sub)
-		return sub, nil
-	default:
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.free) == 0 {
 		m.logger.Error("N", logger.Trace(), "cannot get any availabe subject")
 		return "", errors.New("busy")
 	}
+	sub := m.free[0]
+	m.free = m.free[1:]
+	m.busy[sub] = true
+	m.logger.Debug("N", logger.Trace(), "get subject: "+sub)
+	return sub, nil
 }
 
 func (m *Manager) Checkin(subject string) bool {
-	select {
-	case m.subjects <- subject:
-		m.logger.Debug("N", logger.Trace(), "put back subject: "+subject)
-		return true
-	default:
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.busy[subject] {
 		return false
 	}
+	delete(m.busy, subject)
+	//subjects dropped by a shrink retire when their session ends
+	if m.index(subject) >= m.size {
+		return false
+	}
+	m.free = append(m.free, subject)
+	m.logger.Debug("N", logger.Trace(), "put back subject: "+subject)
+	return true
+}
+
+//Resize changes the number of subjects. New subjects are available right
+//away, removed ones are no longer handed out and sessions holding one keep
+//it until they end.
+func (m *Manager) Resize(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := m.size; i < size; i++ {
+		//a subject still busy since a shrink comes back on checkin
+		if sub := m.prefix + "-" + strconv.Itoa(i); !m.busy[sub] {
+			m.free = append(m.free, sub)
+		}
+	}
+	if size < m.size {
+		kept := m.free[:0]
+		for _, sub := range m.free {
+			if m.index(sub) < size {
+				kept = append(kept, sub)
+			}
+		}
+		m.free = kept
+	}
+	m.size = size
+}
+
+//Available is the number of subjects waiting for a session
+func (m *Manager) Available() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.free)
+}
+
+//InUse is the number of subjects held by sessions, subjects retired by a
+//shrink included until their session ends
+func (m *Manager) InUse() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.busy)
+}
+
+//Size is the number of subjects, busy ones included
+func (m *Manager) Size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+func (m *Manager) index(subject string) int {
+	i, err := strconv.Atoi(strings.TrimPrefix(subject, m.prefix+"-"))
+	if err != nil {
+		return m.size
+	}
+	return i
 }
@@ -2,6 +2,7 @@ package stream
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/nats-io/nats.go"
 )
@@ -11,8 +12,16 @@ import (
 // created on demand. If a connection is Put back and the pool is full it will
 // be closed.
 type Pool struct {
-	pool chan *nats.Conn
-	df   DialFunc
+	// inUse counts connections handed out by Get and not Put back yet, it
+	// comes first to be 64 bit aligned for atomic access
+	inUse int64
+
+	// mu guards the pool channel which Resize replaces, resize keeps two
+	// Resize calls from dialing for the same room
+	mu     sync.RWMutex
+	resize sync.Mutex
+	pool   chan *nats.Conn
+	df     DialFunc
 
 	stopOnce sync.Once
 
@@ -70,18 +79,32 @@ func NewPool(addr string, size int) (*Pool, error) {
 // Get retrieves an available nats connections. If there are none available it will
 // create a new one on the fly
 func (p *Pool) Get() (*nats.Conn, error) {
+	p.mu.RLock()
 	select {
 	case conn := <-p.pool:
+		p.mu.RUnlock()
+		atomic.AddInt64(&p.inUse, 1)
 		return conn, nil
 	default:
-		return p.df(p.Addr)
 	}
+	p.mu.RUnlock()
+	conn, err := p.df(p.Addr)
+	if err == nil {
+		atomic.AddInt64(&p.inUse, 1)
+	}
+	return conn, err
 }
 
 // Put returns a client back to the pool. If the pool is full the client is
 // closed instead. If the client is already closed (due to connection failure or
 // what-have-you) it will not be put back in the pool
 func (p *Pool) Put(conn *nats.Conn) {
+	if conn == nil {
+		return
+	}
+	atomic.AddInt64(&p.inUse, -1)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	select {
 	case p.pool <- conn:
 	default:
@@ -93,6 +116,8 @@ func (p *Pool) Put(conn *nats.Conn) {
 // Assuming there are no other connections waiting to be Put back this method
 // effectively closes and cleans up the pool.
 func (p *Pool) Empty() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	var conn *nats.Conn
 	for {
 		select {
@@ -108,5 +133,66 @@ func (p *Pool) Empty() {
 // the NatsPool using Get. If the number is zero then subsequent calls to Get will
 // be creating new connections on the fly
 func (p *Pool) Avail() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return len(p.pool)
 }
+
+// Resize changes the number of idle connections kept. Growing dials the new
+// connections right away, shrinking closes idle ones beyond size. Connections
+// in use are not touched, Put closes them if there is no room when they come
+// back. Get and Put only wait for the swap of the pool channel, neither for
+// the dials nor for connections being closed.
+func (p *Pool) Resize(size int) error {
+	if size < 0 {
+		size = 0
+	}
+	p.resize.Lock()
+	defer p.resize.Unlock()
+
+	var dialed []*nats.Conn
+	var err error
+	for need := size - p.Avail(); len(dialed) < need; {
+		var conn *nats.Conn
+		if conn, err = p.df(p.Addr); err != nil {
+			break
+		}
+		dialed = append(dialed, conn)
+	}
+
+	var removed []*nats.Conn
+	pool := make(chan *nats.Conn, size)
+	keep := func(conn *nats.Conn) {
+		select {
+		case pool <- conn:
+		default:
+			removed = append(removed, conn)
+		}
+	}
+	p.mu.Lock()
+	for len(p.pool) > 0 {
+		keep(<-p.pool)
+	}
+	for _, conn := range dialed {
+		keep(conn)
+	}
+	p.pool = pool
+	p.mu.Unlock()
+
+	for _, conn := range removed {
+		conn.Close()
+	}
+	return err
+}
+
+// InUse returns the number of connections gotten and not put back yet
+func (p *Pool) InUse() int {
+	return int(atomic.LoadInt64(&p.inUse))
+}
+
+// Size is the number of idle connections the pool keeps
+func (p *Pool) Size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return cap(p.pool)
+}
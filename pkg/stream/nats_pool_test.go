@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func runNATS(t *testing.T) string {
+	t.Helper()
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv.ClientURL()
+}
+
+//idle takes every idle connection out of p
+func idle(p *Pool) []*nats.Conn {
+	conns := []*nats.Conn{}
+	for p.Avail() > 0 {
+		conn, _ := p.Get()
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func TestPoolResize(t *testing.T) {
+	p, err := NewPool(runNATS(t), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Empty()
+	busy, _ := p.Get()
+
+	if err := p.Resize(5); err != nil {
+		t.Fatal(err)
+	}
+	if p.Avail() != 5 || p.Size() != 5 {
+		t.Fatalf("grown to %d idle of %d", p.Avail(), p.Size())
+	}
+	conns := idle(p)
+	for _, conn := range conns {
+		p.Put(conn)
+	}
+
+	if err := p.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	if p.Avail() != 1 || p.Size() != 1 {
+		t.Fatalf("shrunk to %d idle of %d", p.Avail(), p.Size())
+	}
+	closed := 0
+	for _, conn := range conns {
+		if conn.IsClosed() {
+			closed++
+		}
+	}
+	if closed != 4 {
+		t.Fatalf("%d connections closed by the shrink, want 4", closed)
+	}
+	//connections in use come back only while there is room
+	if busy.IsClosed() {
+		t.Fatal("a connection in use was closed")
+	}
+	p.Put(busy)
+	if !busy.IsClosed() || p.InUse() != 0 {
+		t.Fatalf("put back into a full pool: closed %v, in use %d", busy.IsClosed(), p.InUse())
+	}
+}
+
+func TestPoolResizeDialsUnlocked(t *testing.T) {
+	url := runNATS(t)
+	dialing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slow := func(url string, options ...nats.Option) (*nats.Conn, error) {
+		dialing <- struct{}{}
+		<-release
+		return nats.Connect(url, options...)
+	}
+	p, err := NewPool(url, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Empty()
+	p.df = slow
+
+	resized := make(chan error, 1)
+	go func() { resized <- p.Resize(3) }()
+	<-dialing
+	//the pool keeps serving while the new connections are dialed
+	done := make(chan struct{})
+	go func() {
+		conn, err := p.Get()
+		if err == nil {
+			p.Put(conn)
+		}
+		p.Avail()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get and Put waited for Resize to dial")
+	}
+	close(release)
+	for {
+		select {
+		case err := <-resized:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Avail() != 3 {
+				t.Fatalf("%d idle connections, want 3", p.Avail())
+			}
+			return
+		case <-dialing:
+		}
+	}
+}
+
+func TestPoolResizeDialFails(t *testing.T) {
+	url := runNATS(t)
+	p, err := NewPool(url, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Empty()
+	refused := errors.New("refused")
+	dials := 0
+	p.df = func(url string, options ...nats.Option) (*nats.Conn, error) {
+		if dials++; dials > 1 {
+			return nil, refused
+		}
+		return nats.Connect(url, options...)
+	}
+	if err := p.Resize(4); err != refused {
+		t.Fatalf("got %v", err)
+	}
+	//what could be dialed is kept and the pool still grew
+	if p.Avail() != 2 || p.Size() != 4 {
+		t.Fatalf("%d idle of %d", p.Avail(), p.Size())
+	}
+}
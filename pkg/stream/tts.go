@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/4406arthur/bello/pkg/tracing"
+	"github.com/nats-io/nats.go"
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+//ErrSpeakTimeout returned when the TTS worker stops sending audio
+var ErrSpeakTimeout = errors.New("tts worker did not answer in time")
+
+//chunks of a prompt are held for a slow client up to these limits, the
+//prompt fails rather than playing with a gap once one is dropped
+const (
+	speakPendingChunks = 1024
+	speakPendingBytes  = 16 * 1024 * 1024
+)
+
+//Speak publishes a synthesis request on subject and hands every audio chunk
+//the worker replies with to out. The worker ends the stream with an empty
+//message. Speak returns early when ctx is cancelled, and fails with
+//nats.ErrSlowConsumer when out falls so far behind that chunks were dropped.
+func Speak(ctx context.Context, nc *nats.Conn, subject string, req *entity.SpeakRequest, out chan<- []byte, chunkTimeout time.Duration) error {
+	payload, err := ffjson.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	replyTo := nats.NewInbox()
+	sub, err := nc.SubscribeSync(replyTo)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	if err := sub.SetPendingLimits(speakPendingChunks, speakPendingBytes); err != nil {
+		return err
+	}
+
+	if err := nc.PublishMsg(tracing.NewMsg(ctx, nc, subject, replyTo, payload)); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := nextChunk(ctx, sub, chunkTimeout)
+		if err != nil {
+			return err
+		}
+		if len(msg.Data) == 0 {
+			return nil
+		}
+		select {
+		case out <- msg.Data:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//nextChunk waits up to timeout for the next reply on sub
+func nextChunk(ctx context.Context, sub *nats.Subscription, timeout time.Duration) (*nats.Msg, error) {
+	wait, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	msg, err := sub.NextMsgWithContext(wait)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return nil, ErrSpeakTimeout
+	}
+	return msg, err
+}
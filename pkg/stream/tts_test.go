@@ -0,0 +1,126 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/pkg/entity"
+	"github.com/nats-io/nats.go"
+)
+
+//runTTS answers synthesis requests on "tts" with the number of chunks the
+//request text asks for, followed by the end of the stream unless the text
+//is "stall"
+func runTTS(t *testing.T, url string) {
+	t.Helper()
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	_, err = nc.Subscribe("tts", func(msg *nats.Msg) {
+		req := entity.SpeakRequest{}
+		json.Unmarshal(msg.Data, &req)
+		if req.Text == "stall" {
+			return
+		}
+		n, _ := strconv.Atoi(req.Text)
+		for i := 0; i < n; i++ {
+			nc.Publish(msg.Reply, []byte(strconv.Itoa(i)))
+		}
+		nc.Publish(msg.Reply, nil)
+		nc.Flush()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.Flush()
+}
+
+func speakConn(t *testing.T) *nats.Conn {
+	t.Helper()
+	url := runNATS(t)
+	runTTS(t, url)
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestSpeak(t *testing.T) {
+	nc := speakConn(t)
+	out := make(chan []byte, 10)
+	err := Speak(context.Background(), nc, "tts", &entity.SpeakRequest{Text: "3"}, out, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	i := 0
+	for chunk := range out {
+		if string(chunk) != strconv.Itoa(i) {
+			t.Fatalf("chunk %d is %q", i, chunk)
+		}
+		i++
+	}
+	if i != 3 {
+		t.Fatalf("got %d chunks", i)
+	}
+}
+
+func TestSpeakFails(t *testing.T) {
+	nc := speakConn(t)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		text string
+		want error
+	}{
+		{"worker stalls", context.Background(), "stall", ErrSpeakTimeout},
+		{"cancelled", cancelled, "stall", context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Speak(tt.ctx, nc, "tts", &entity.SpeakRequest{Text: tt.text}, make(chan []byte), 100*time.Millisecond)
+			if err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpeakSlowConsumer(t *testing.T) {
+	nc := speakConn(t)
+	out := make(chan []byte)
+	result := make(chan error, 1)
+	chunks := 2 * speakPendingChunks
+	go func() {
+		result <- Speak(context.Background(), nc, "tts", &entity.SpeakRequest{Text: strconv.Itoa(chunks)}, out, 5*time.Second)
+	}()
+	//nobody reads out until every chunk reached the connection
+	deadline := time.Now().Add(5 * time.Second)
+	for nc.Stats().InMsgs < uint64(chunks+1) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d chunks arrived", nc.Stats().InMsgs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	read := 0
+	for {
+		select {
+		case <-out:
+			read++
+		case err := <-result:
+			if err != nats.ErrSlowConsumer {
+				t.Fatalf("got %v after %d chunks", err, read)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package tlsconf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+// DefaultReloadInterval is how often certificate files are checked for changes
+const DefaultReloadInterval = 30 * time.Second
+
+//Config describes the TLS side of the listener
+type Config struct {
+	CertFile string
+	KeyFile  string
+	//ClientCAFile enables client certificate verification against the
+	//bundle, RequireClientCert refuses clients that present none
+	ClientCAFile      string
+	RequireClientCert bool
+	//MinVersion is "1.2" (default) or "1.3"
+	MinVersion string
+	//CipherSuites names the TLS 1.2 suites to offer, Go defaults when empty.
+	//TLS 1.3 suites are not configurable.
+	CipherSuites   []string
+	ReloadInterval time.Duration
+}
+
+//Reloader serves the current certificate and client CA bundle and picks up
+//new files without a restart. Handshakes made before a reload keep their
+//connection, only new ones see the new files.
+type Reloader struct {
+	cfg    Config
+	base   *tls.Config
+	logger logger.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	clients *x509.CertPool
+	modTime time.Time
+}
+
+//New loads the files once, a broken setup fails here rather than at the
+//first handshake
+func New(cfg Config, log logger.Logger) (*Reloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tls requires both cert and key")
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = DefaultReloadInterval
+	}
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+	}
+	switch cfg.MinVersion {
+	case "", "1.2":
+	case "1.3":
+		base.MinVersion = tls.VersionTLS13
+	default:
+		return nil, errors.New("unsupported tls min version " + cfg.MinVersion)
+	}
+	suites, err := CipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	base.CipherSuites = suites
+	if cfg.ClientCAFile != "" {
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.RequireClientCert {
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	r := &Reloader{cfg: cfg, base: base, logger: log}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//CipherSuites maps names such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 to ids,
+//suites Go considers insecure are refused
+func CipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, errors.New("unknown or insecure cipher suite " + name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+//TLSConfig is meant for a tls.Listener, every handshake asks the reloader
+//for the current files
+func (r *Reloader) TLSConfig() *tls.Config {
+	cfg := r.base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		c := r.base.Clone()
+		c.Certificates = []tls.Certificate{*r.cert}
+		c.ClientCAs = r.clients
+		return c, nil
+	}
+	return cfg
+}
+
+//Watch checks the files every ReloadInterval until ctx is done
+func (r *Reloader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				break
+			}
+			if err := r.load(); err != nil {
+				//keep serving the previous certificate until the files are fixed
+				r.logger.Error("NA", logger.Trace(), "tls reload failed: "+err.Error())
+				break
+			}
+			r.logger.Info("NA", logger.Trace(), "tls certificate reloaded")
+		}
+	}
+}
+
+//changed compares the newest modification time of the files with the one
+//seen at the last load
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return latestModTime(r.files()).After(r.modTime)
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func latestModTime(files []string) time.Time {
+	var latest time.Time
+	for _, f := range files {
+		//os.Stat follows the symlinks mounted secrets are swapped through
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+func (r *Reloader) load() error {
+	modTime := latestModTime(r.files())
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clients *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clients = x509.NewCertPool()
+		if !clients.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", r.cfg.ClientCAFile)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clients = clients
+	r.modTime = modTime
+	return nil
+}
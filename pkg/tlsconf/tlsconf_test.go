@@ -0,0 +1,303 @@
+package tlsconf
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4406arthur/bello/utils/logger"
+)
+
+//testCA signs server and client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key := newKey(t)
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+//issue returns the PEM certificate and key of a new leaf named name
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key := newKey(t)
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+//files are the PEM files a Reloader watches
+type files struct {
+	cert, key, cas string
+	modTime        time.Time
+}
+
+func newFiles(t *testing.T) *files {
+	dir := t.TempDir()
+	return &files{
+		cert:    filepath.Join(dir, "cert.pem"),
+		key:     filepath.Join(dir, "key.pem"),
+		cas:     filepath.Join(dir, "clients.pem"),
+		modTime: time.Now().Add(-time.Minute),
+	}
+}
+
+//write replaces the file at path with a modification time later than that
+//of every earlier write
+func (f *files) write(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f.modTime = f.modTime.Add(time.Second)
+	if err := os.Chtimes(path, f.modTime, f.modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *files) serve(t *testing.T, ca *testCA, name string) {
+	t.Helper()
+	cert, key := ca.issue(t, name, x509.ExtKeyUsageServerAuth)
+	f.write(t, f.cert, cert)
+	f.write(t, f.key, key)
+}
+
+func newReloader(t *testing.T, cfg Config) *Reloader {
+	t.Helper()
+	log, err := logger.NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(cfg, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+//handshake connects a client presenting client, if any, and returns the
+//name on the server certificate, or the error of the server side
+func handshake(t *testing.T, r *Reloader, roots *x509.CertPool, client *tls.Certificate) (string, error) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	clientCfg := &tls.Config{RootCAs: roots, ServerName: "server"}
+	if client != nil {
+		clientCfg.Certificates = []tls.Certificate{*client}
+	}
+	c := tls.Client(clientConn, clientCfg)
+	go func() {
+		c.Handshake()
+		//the server reads the client certificate after the client is done
+		c.Read(make([]byte, 1))
+	}()
+	s := tls.Server(serverConn, r.TLSConfig())
+	serverConn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := s.Handshake(); err != nil {
+		return "", err
+	}
+	chain := c.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return "", nil
+	}
+	return chain[0].Subject.CommonName, nil
+}
+
+func TestReloadAfterChange(t *testing.T) {
+	ca := newCA(t, "ca")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	f := newFiles(t)
+	f.serve(t, ca, "server")
+	r := newReloader(t, Config{CertFile: f.cert, KeyFile: f.key, ReloadInterval: 10 * time.Millisecond})
+	if name, err := handshake(t, r, roots, nil); err != nil || name != "server" {
+		t.Fatalf("served %q, %v", name, err)
+	}
+	served := func() []byte {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.cert.Certificate[0]
+	}
+	before := served()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx)
+	f.serve(t, ca, "server")
+	deadline := time.Now().Add(5 * time.Second)
+	for string(served()) == string(before) {
+		if time.Now().After(deadline) {
+			t.Fatal("new certificate not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if name, err := handshake(t, r, roots, nil); err != nil || name != "server" {
+		t.Fatalf("served %q, %v after the reload", name, err)
+	}
+}
+
+func TestBrokenFileKeepsCertificate(t *testing.T) {
+	ca := newCA(t, "ca")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	f := newFiles(t)
+	f.serve(t, ca, "server")
+	r := newReloader(t, Config{CertFile: f.cert, KeyFile: f.key})
+
+	//a key written before its certificate does not match for a moment
+	_, otherKey := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	tests := []struct {
+		name string
+		path string
+		data []byte
+	}{
+		{"garbage certificate", f.cert, []byte("not a certificate")},
+		{"key of another certificate", f.key, otherKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, _ := ioutil.ReadFile(f.cert)
+			key, _ := ioutil.ReadFile(f.key)
+			defer f.write(t, f.cert, cert)
+			defer f.write(t, f.key, key)
+			f.write(t, tt.path, tt.data)
+			if !r.changed() {
+				t.Fatal("change not noticed")
+			}
+			if err := r.load(); err == nil {
+				t.Fatal("broken files loaded")
+			}
+			if name, err := handshake(t, r, roots, nil); err != nil || name != "server" {
+				t.Fatalf("served %q, %v after a failed reload", name, err)
+			}
+			//the files are looked at again until they are fixed
+			if !r.changed() {
+				t.Fatal("a failed reload is not retried")
+			}
+		})
+	}
+}
+
+func TestClientCASwap(t *testing.T) {
+	serverCA, oldCA, nextCA := newCA(t, "server ca"), newCA(t, "old clients"), newCA(t, "new clients")
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+	client := func(ca *testCA) *tls.Certificate {
+		certPEM, keyPEM := ca.issue(t, "gateway", x509.ExtKeyUsageClientAuth)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &cert
+	}
+	oldClient, newClient := client(oldCA), client(nextCA)
+
+	f := newFiles(t)
+	f.serve(t, serverCA, "server")
+	f.write(t, f.cas, oldCA.pem)
+	r := newReloader(t, Config{CertFile: f.cert, KeyFile: f.key, ClientCAFile: f.cas, RequireClientCert: true})
+	if _, err := handshake(t, r, roots, oldClient); err != nil {
+		t.Fatalf("client of the bundle refused: %v", err)
+	}
+	if _, err := handshake(t, r, roots, newClient); err == nil {
+		t.Fatal("client of another CA accepted")
+	}
+	if _, err := handshake(t, r, roots, nil); err == nil {
+		t.Fatal("client without certificate accepted")
+	}
+
+	f.write(t, f.cas, nextCA.pem)
+	if !r.changed() {
+		t.Fatal("new bundle not noticed")
+	}
+	if err := r.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, r, roots, newClient); err != nil {
+		t.Fatalf("client of the new bundle refused: %v", err)
+	}
+	if _, err := handshake(t, r, roots, oldClient); err == nil {
+		t.Fatal("client of the retired CA accepted")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	ca := newCA(t, "ca")
+	f := newFiles(t)
+	f.serve(t, ca, "server")
+	r := newReloader(t, Config{CertFile: f.cert, KeyFile: f.key, MinVersion: "1.3"})
+	cfg := r.TLSConfig()
+	if cfg.GetCertificate != nil || len(cfg.Certificates) != 0 {
+		t.Fatal("certificates bypass GetConfigForClient")
+	}
+	c, err := cfg.GetConfigForClient(nil)
+	if err != nil || c.MinVersion != tls.VersionTLS13 || len(c.Certificates) != 1 {
+		t.Fatalf("handshake config %+v, %v", c, err)
+	}
+	if _, err := New(Config{CertFile: f.cert, KeyFile: f.key, MinVersion: "1.1"}, nil); err == nil {
+		t.Fatal("TLS 1.1 accepted")
+	}
+	if _, err := CipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Fatal("an insecure cipher suite accepted")
+	}
+}
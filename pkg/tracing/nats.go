@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+)
+
+//headerCarrier adapts NATS headers to the propagation API. NATS header keys
+//are case sensitive so keys are kept as the propagator writes them.
+type headerCarrier nats.Header
+
+func (h headerCarrier) Get(key string) string {
+	if values := h[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h[key] = []string{value}
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+//NewMsg builds a message to subject carrying the trace context of ctx in its
+//headers. Servers older than NATS 2.2 have no headers, they get the plain
+//message and workers start traces of their own.
+func NewMsg(ctx context.Context, nc *nats.Conn, subject, reply string, data []byte) *nats.Msg {
+	msg := &nats.Msg{Subject: subject, Reply: reply, Data: data}
+	if nc.HeadersSupported() {
+		msg.Header = nats.Header{}
+		Inject(ctx, msg)
+	}
+	return msg
+}
+
+//Inject writes the trace context of ctx to the headers of msg, msg.Header
+//must not be nil
+func Inject(ctx context.Context, msg *nats.Msg) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(msg.Header))
+}
+
+//Extract returns ctx with the trace context carried by msg, if any
+func Extract(ctx context.Context, msg *nats.Msg) context.Context {
+	if len(msg.Header) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Header))
+}
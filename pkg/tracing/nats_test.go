@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//remote is a sampled span context as a caller would send it
+func remote(t *testing.T) trace.SpanContext {
+	t.Helper()
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
+
+func TestInjectExtract(t *testing.T) {
+	if err := Setup(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	sc := remote(t)
+	msg := &nats.Msg{Subject: "stt", Header: nats.Header{}}
+	Inject(trace.ContextWithSpanContext(context.Background(), sc), msg)
+	if got := msg.Header["traceparent"]; len(got) != 1 || got[0] != "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01" {
+		t.Fatalf("traceparent %v", got)
+	}
+	if got := trace.SpanContextFromContext(Extract(context.Background(), msg)); !got.Equal(sc) {
+		t.Fatalf("extracted %+v, want %+v", got, sc)
+	}
+
+	//workers on old servers send no headers at all
+	if got := trace.SpanContextFromContext(Extract(context.Background(), &nats.Msg{Subject: "stt"})); got.IsValid() {
+		t.Fatalf("extracted %+v from a message without headers", got)
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewMsgWithoutHeaders(t *testing.T) {
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	if nc.HeadersSupported() {
+		t.Skip("the test server supports headers")
+	}
+	msg := NewMsg(trace.ContextWithSpanContext(context.Background(), remote(t)), nc, "stt", "inbox", []byte("audio"))
+	if msg.Header != nil || msg.Subject != "stt" || msg.Reply != "inbox" || string(msg.Data) != "audio" {
+		t.Fatalf("got %+v", msg)
+	}
+	//a message with headers would be refused by the server
+	if err := nc.PublishMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+//Package tracing exports OpenTelemetry spans of sessions and carries the
+//trace context to the speech workers in NATS message headers, so a slow
+//recognition can be followed from the WebSocket to the STT worker and back.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+//instrumentation is the name spans are reported under
+const instrumentation = "github.com/4406arthur/bello"
+
+//Config is where spans go. Endpoint is the host:port of an OTLP/HTTP
+//collector, SampleRatio the share of new traces kept, traces started by a
+//caller keep the caller's decision.
+type Config struct {
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	SampleRatio float64
+}
+
+var provider *sdktrace.TracerProvider
+
+//Setup installs the global tracer provider and the W3C trace context
+//propagator. Spans are not recorded when no exporter is configured but
+//incoming trace context is still passed on.
+func Setup(cfg Config) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+//Shutdown exports the spans still buffered, it does nothing when Setup
+//installed no exporter
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+//Tracer returns the tracer of the controller
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+//FieldLogger adds its fields to every line written through it, such as the
+//id, client and subject of a WebSocket session. Fields can be set while
+//goroutines are logging.
+type FieldLogger struct {
+	next   Logger
+	mu     sync.RWMutex
+	fields Fields
+	//nil when debug lines follow the logger level only
+	rules *DebugRules
+}
+
+//With returns a logger adding fields to every line written to next, empty
+//fields are left out
+func With(next Logger, fields Fields) *FieldLogger {
+	l := &FieldLogger{next: next, fields: Fields{}}
+	for k, v := range fields {
+		l.Set(k, v)
+	}
+	return l
+}
+
+//Set adds or replaces a field, an empty value removes it
+func (l *FieldLogger) Set(key, value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if value == "" {
+		delete(l.fields, key)
+		return
+	}
+	l.fields[key] = value
+}
+
+//DebugWhen writes the debug lines of the logger whatever the logger level
+//while one of rules matches its fields
+func (l *FieldLogger) DebugWhen(rules *DebugRules) *FieldLogger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rules = rules
+	return l
+}
+
+//Get returns the value of a field
+func (l *FieldLogger) Get(key string) string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.fields[key]
+}
+
+func (l *FieldLogger) info(i *LogInfo) *LogInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return i.withFields(l.fields)
+}
+
+//Debug ...
+func (l *FieldLogger) Debug(direction string, i *LogInfo, msg string) {
+	l.mu.RLock()
+	info := i.withFields(l.fields)
+	info.force = info.force || (l.rules != nil && l.rules.Match(l.fields))
+	l.mu.RUnlock()
+	l.next.Debug(direction, info, msg)
+}
+
+//Info ...
+func (l *FieldLogger) Info(direction string, i *LogInfo, msg string) {
+	l.next.Info(direction, l.info(i), msg)
+}
+
+//Error ...
+func (l *FieldLogger) Error(direction string, i *LogInfo, msg string) {
+	l.next.Error(direction, l.info(i), msg)
+}
+
+//Fatal ...
+func (l *FieldLogger) Fatal(direction string, i *LogInfo, msg string) {
+	l.next.Fatal(direction, l.info(i), msg)
+}
+
+//GetLogger returns the underlying logrus logger, lines written to it
+//directly carry no fields
+func (l *FieldLogger) GetLogger() *logrus.Logger {
+	return l.next.GetLogger()
+}
@@ -0,0 +1,113 @@
+package logger
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func captured(t *testing.T) (*CLogger, *test.Hook) {
+	t.Helper()
+	l, err := NewLogger(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, test.NewLocal(l.Log)
+}
+
+func TestFieldLogger(t *testing.T) {
+	l, hook := captured(t)
+	log := With(l, Fields{"session": "s1", "client": ""})
+	if _, ok := log.fields["client"]; ok {
+		t.Fatal("an empty field was kept")
+	}
+	log.Info("N", Trace(), "opened")
+	log.Set("subject", "stt-1")
+	log.Set(FieldClientIP, "10.0.0.1")
+	log.Error("N", Trace(), "failed")
+	log.Set("subject", "")
+	log.Debug("N", Trace(), "closed")
+
+	tests := []struct {
+		msg     string
+		level   logrus.Level
+		subject interface{}
+		ip      interface{}
+	}{
+		{"opened", logrus.InfoLevel, nil, ""},
+		{"failed", logrus.ErrorLevel, "stt-1", "10.0.0.1"},
+		{"closed", logrus.DebugLevel, nil, "10.0.0.1"},
+	}
+	entries := hook.AllEntries()
+	if len(entries) != len(tests) {
+		t.Fatalf("%d lines", len(entries))
+	}
+	for i, tt := range tests {
+		e := entries[i]
+		if e.Message != tt.msg || e.Level != tt.level || e.Data["session"] != "s1" {
+			t.Fatalf("line %d: %s %q %v", i, e.Level, e.Message, e.Data)
+		}
+		if e.Data["subject"] != tt.subject {
+			t.Fatalf("line %d: subject %v, want %v", i, e.Data["subject"], tt.subject)
+		}
+		//a context field replaces the empty request field of the same name
+		if e.Data["clientIP"] != tt.ip {
+			t.Fatalf("line %d: clientIP %v, want %v", i, e.Data["clientIP"], tt.ip)
+		}
+		if e.Data["func"] == "" || e.Data["line"] == 0 {
+			t.Fatalf("line %d: no caller in %v", i, e.Data)
+		}
+	}
+	if log.Get("session") != "s1" || log.Get("subject") != "" {
+		t.Fatalf("fields %v", log.fields)
+	}
+}
+
+func TestFieldLoggerNested(t *testing.T) {
+	l, hook := captured(t)
+	inner := With(l, Fields{"session": "s1", "subject": "stt-1"})
+	outer := With(inner, Fields{"subject": "stt-2", "uid": "u1"})
+	outer.Info("N", Trace(), "line")
+	inner.Info("N", Trace(), "line")
+	entries := hook.AllEntries()
+	//the logger closest to the caller wins
+	if d := entries[0].Data; d["session"] != "s1" || d["subject"] != "stt-2" || d["uid"] != "u1" {
+		t.Fatalf("outer line %v", d)
+	}
+	if d := entries[1].Data; d["subject"] != "stt-1" || d["uid"] != nil {
+		t.Fatalf("inner line %v", d)
+	}
+	//lines written to logrus directly carry no fields
+	outer.GetLogger().Info("raw")
+	if d := hook.LastEntry().Data; len(d) != 0 {
+		t.Fatalf("raw line %v", d)
+	}
+}
+
+func TestFieldLoggerConcurrent(t *testing.T) {
+	l, hook := captured(t)
+	log := With(l, Fields{"session": "s1"})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				log.Set("uid", strconv.Itoa(i))
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				log.Info("N", Trace(), "line")
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(hook.AllEntries()); n != 400 {
+		t.Fatalf("%d lines", n)
+	}
+}
@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/4406arthur/bello/utils/rand"
+)
+
+//Session fields debug rules match on
+const (
+	FieldUID      = "uid"
+	FieldClientIP = "clientIP"
+	FieldPlatform = "platform"
+)
+
+//ErrEmptyRule is returned for a debug rule matching every session
+var ErrEmptyRule = errors.New("a debug rule needs a uid, client_ip or platform")
+
+//DebugRule turns on debug lines for the sessions matching every criterion
+//set, until it expires
+type DebugRule struct {
+	ID        string    `json:"id"`
+	UID       string    `json:"uid,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	Platform  string    `json:"platform,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (r *DebugRule) matches(fields Fields, now time.Time) bool {
+	if !now.Before(r.ExpiresAt) {
+		return false
+	}
+	return (r.UID == "" || r.UID == fields[FieldUID]) &&
+		(r.ClientIP == "" || r.ClientIP == fields[FieldClientIP]) &&
+		(r.Platform == "" || r.Platform == fields[FieldPlatform])
+}
+
+//DebugRules are the active debug rules, expired ones are dropped as they
+//are found
+type DebugRules struct {
+	mu    sync.RWMutex
+	rules map[string]*DebugRule
+}
+
+//NewDebugRules ...
+func NewDebugRules() *DebugRules {
+	return &DebugRules{rules: map[string]*DebugRule{}}
+}
+
+//Add activates rule for ttl and returns it with its id
+func (d *DebugRules) Add(rule DebugRule, ttl time.Duration) (DebugRule, error) {
+	if rule.UID == "" && rule.ClientIP == "" && rule.Platform == "" {
+		return rule, ErrEmptyRule
+	}
+	id, err := rand.GenerateRandomString(12)
+	if err != nil {
+		return rule, err
+	}
+	rule.ID = id
+	rule.ExpiresAt = time.Now().Add(ttl).UTC()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rules[id] = &rule
+	return rule, nil
+}
+
+//Remove drops a rule, it reports whether it was active
+func (d *DebugRules) Remove(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rule, ok := d.rules[id]
+	delete(d.rules, id)
+	return ok && time.Now().Before(rule.ExpiresAt)
+}
+
+//List returns the active rules, the first to expire first
+func (d *DebugRules) List() []DebugRule {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	out := []DebugRule{}
+	for id, rule := range d.rules {
+		if !now.Before(rule.ExpiresAt) {
+			delete(d.rules, id)
+			continue
+		}
+		out = append(out, *rule)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ExpiresAt.Before(out[j].ExpiresAt) })
+	return out
+}
+
+//Match tells whether an active rule matches fields
+func (d *DebugRules) Match(fields Fields) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.rules) == 0 {
+		return false
+	}
+	now := time.Now()
+	for _, rule := range d.rules {
+		if rule.matches(fields, now) {
+			return true
+		}
+	}
+	return false
+}
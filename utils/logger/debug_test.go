@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDebugRules(t *testing.T) {
+	d := NewDebugRules()
+	if _, err := d.Add(DebugRule{}, time.Minute); err != ErrEmptyRule {
+		t.Fatalf("a rule matching every session got %v", err)
+	}
+	caller, err := d.Add(DebugRule{UID: "u1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ivr, err := d.Add(DebugRule{Platform: "ivr", ClientIP: "10.0.0.1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Add(DebugRule{UID: "gone"}, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		fields Fields
+		want   bool
+	}{
+		{"uid", Fields{FieldUID: "u1", FieldPlatform: "web"}, true},
+		{"every criterion", Fields{FieldPlatform: "ivr", FieldClientIP: "10.0.0.1"}, true},
+		{"some criteria", Fields{FieldPlatform: "ivr", FieldClientIP: "10.0.0.2"}, false},
+		{"expired", Fields{FieldUID: "gone"}, false},
+		{"no fields", Fields{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.Match(tt.fields); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	//the expired rule is dropped, the others come first to expire first
+	rules := d.List()
+	if len(rules) != 2 || rules[0].ID != ivr.ID || rules[1].ID != caller.ID {
+		t.Fatalf("listed %+v", rules)
+	}
+	if !d.Remove(ivr.ID) || d.Remove(ivr.ID) || d.Remove("unknown") {
+		t.Fatal("Remove reports the wrong rules")
+	}
+	if d.Match(Fields{FieldPlatform: "ivr", FieldClientIP: "10.0.0.1"}) {
+		t.Fatal("a removed rule still matches")
+	}
+}
+
+func TestDebugWhen(t *testing.T) {
+	l, hook := captured(t)
+	l.Log.SetLevel(logrus.InfoLevel)
+	rules := NewDebugRules()
+	if _, err := rules.Add(DebugRule{UID: "u1"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	watched := With(l, Fields{"session": "s1"}).DebugWhen(rules)
+	other := With(l, Fields{"session": "s2", FieldUID: "u2"}).DebugWhen(rules)
+
+	watched.Debug("N", Trace(), "before the start")
+	watched.Set(FieldUID, "u1")
+	watched.Debug("N", Trace(), "after the start")
+	other.Debug("N", Trace(), "another caller")
+	With(l, Fields{FieldUID: "u1"}).Debug("N", Trace(), "no rules")
+
+	entries := hook.AllEntries()
+	if len(entries) != 1 || entries[0].Message != "after the start" || entries[0].Level != logrus.DebugLevel {
+		t.Fatalf("logged %v", entries)
+	}
+	if entries[0].Data["session"] != "s1" {
+		t.Fatalf("forced line has %v", entries[0].Data)
+	}
+	//at debug level every session logs its debug lines
+	l.Log.SetLevel(logrus.DebugLevel)
+	other.Debug("N", Trace(), "another caller")
+	if len(hook.AllEntries()) != 2 {
+		t.Fatal("debug line dropped at debug level")
+	}
+}
@@ -0,0 +1,132 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const (
+	elasticTimeout       = 5 * time.Second
+	defaultElasticIndex  = "ionian_api"
+	defaultBufferSize    = 10000
+	defaultBatchSize     = 500
+	defaultFlushInterval = time.Second
+)
+
+//elasticSink ships lines to Elasticsearch in bulk requests. The server may be
+//down at startup and at any time after, lines are dropped while it is.
+type elasticSink struct {
+	*queue
+	client        *elastic.Client
+	index         string
+	batchSize     int
+	flushInterval time.Duration
+}
+
+func newElasticSink(l level, cfg SinkConfig) (Sink, error) {
+	//no health check, an unreachable server must not hold up startup
+	client, err := elastic.NewClient(
+		elastic.SetURL(cfg.Endpoint),
+		elastic.SetSniff(false),
+		elastic.SetHealthcheck(false),
+	)
+	if err != nil {
+		return nil, err
+	}
+	hostName, _ := os.Hostname()
+	l.formatter = &hostFormatter{
+		host: hostName,
+		next: &logrus.JSONFormatter{FieldMap: logrus.FieldMap{logrus.FieldKeyTime: "@timestamp"}},
+	}
+	s := &elasticSink{
+		client:        client,
+		index:         cfg.Index,
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval,
+	}
+	if s.index == "" {
+		s.index = defaultElasticIndex
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = defaultBatchSize
+	}
+	if s.flushInterval <= 0 {
+		s.flushInterval = defaultFlushInterval
+	}
+	size := cfg.BufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	s.queue = newQueue(l, size)
+	go s.ship()
+	return s, nil
+}
+
+func (s *elasticSink) ship() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	batch := make([]json.RawMessage, 0, s.batchSize)
+	for {
+		select {
+		case q, ok := <-s.lines:
+			if !ok {
+				s.send(batch)
+				return
+			}
+			batch = append(batch, json.RawMessage(q.line))
+			if len(batch) < s.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		s.send(batch)
+		batch = batch[:0]
+	}
+}
+
+//send indexes a batch, lines the server refuses or never sees are dropped
+func (s *elasticSink) send(batch []json.RawMessage) {
+	if len(batch) == 0 {
+		return
+	}
+	bulk := s.client.Bulk().Index(s.index).Type("log")
+	for _, doc := range batch {
+		bulk.Add(elastic.NewBulkIndexRequest().Doc(doc))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), elasticTimeout)
+	defer cancel()
+	res, err := bulk.Do(ctx)
+	if err != nil {
+		s.drop(len(batch))
+		return
+	}
+	s.drop(len(res.Failed()))
+}
+
+func (s *elasticSink) Close() error {
+	s.queue.close()
+	s.client.Stop()
+	return nil
+}
+
+//hostFormatter adds the host name to every document, as the elogrus hook did
+type hostFormatter struct {
+	host string
+	next logrus.Formatter
+}
+
+func (f *hostFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	e := *entry
+	e.Data = make(logrus.Fields, len(entry.Data)+1)
+	for k, v := range entry.Data {
+		e.Data[k] = v
+	}
+	e.Data["host"] = f.host
+	return f.next.Format(&e)
+}
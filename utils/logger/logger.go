@@ -2,18 +2,19 @@ package logger
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/olivere/elastic.v5"
-	"gopkg.in/sohlich/elogrus.v2"
 )
 
 //CLogger defined
 type CLogger struct {
-	Log *logrus.Logger
+	Log   *logrus.Logger
+	sinks []Sink
 }
 
 type LogInfo struct {
@@ -24,6 +25,44 @@ type LogInfo struct {
 	dataLength int
 	function   string
 	line       int
+	fields     Fields
+	//write the line at debug level even when the logger is above it
+	force bool
+}
+
+//Fields are context added to log lines, see With
+type Fields map[string]string
+
+//withFields returns a copy of i carrying fields as well, fields of i win
+func (i *LogInfo) withFields(fields Fields) *LogInfo {
+	out := *i
+	out.fields = make(Fields, len(fields)+len(i.fields))
+	for k, v := range fields {
+		out.fields[k] = v
+	}
+	for k, v := range i.fields {
+		out.fields[k] = v
+	}
+	return &out
+}
+
+//logrusFields are the fields of a line, context fields replace the empty
+//request fields of the same name
+func (i *LogInfo) logrusFields(direction string) logrus.Fields {
+	fields := logrus.Fields{
+		"method":     i.method,
+		"path":       i.path,
+		"direction":  direction,
+		"clientIP":   i.clientIP,
+		"userAgent":  i.userAgent,
+		"dataLength": i.dataLength,
+		"func":       i.function,
+		"line":       i.line,
+	}
+	for k, v := range i.fields {
+		fields[k] = v
+	}
+	return fields
 }
 
 //Logger interface
@@ -35,31 +74,50 @@ type Logger interface {
 	GetLogger() *logrus.Logger
 }
 
-//InitLogger used to get Logger componnet
-func InitLogger(fileName, elasticDBEndpoint string) *CLogger {
+//NewLogger writes every line to each of sinks, the sinks buffer lines and
+//drop them rather than hold up the caller
+func NewLogger(sinks []SinkConfig) (*CLogger, error) {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 
 	log := logrus.New()
 	log.Level = logrus.DebugLevel
-
-	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
-	if err == nil {
-		log.Out = file
-	} else {
-		log.Info("Failed to log to file, using default stderr")
+	//lines only go to the sinks
+	log.Out = ioutil.Discard
+
+	opened := []Sink{}
+	for _, cfg := range uniqueNames(sinks) {
+		sink, err := NewSink(cfg)
+		if err != nil {
+			for _, s := range opened {
+				s.Close()
+			}
+			return nil, fmt.Errorf("log sink %s: %v", cfg.Name, err)
+		}
+		log.Hooks.Add(sink)
+		opened = append(opened, sink)
 	}
 
-	client, err := elastic.NewClient(elastic.SetURL(elasticDBEndpoint))
-	if err != nil {
-		log.Info("Failed to connect elasticsearch server: " + elasticDBEndpoint)
-	} else {
-		hostName, _ := os.Hostname()
-		hook, _ := elogrus.NewElasticHook(client, hostName, logrus.InfoLevel, "ionian_api")
-		log.Hooks.Add(hook)
+	return &CLogger{Log: log, sinks: opened}, nil
+}
+
+//Dropped counts the lines each sink could not write, by sink name
+func (logger *CLogger) Dropped() map[string]uint64 {
+	out := make(map[string]uint64, len(logger.sinks))
+	for _, s := range logger.sinks {
+		out[s.Name()] = s.Dropped()
 	}
+	return out
+}
 
-	return &CLogger{
-		log}
+//Close writes what the sinks still buffer
+func (logger *CLogger) Close() error {
+	var first error
+	for _, s := range logger.sinks {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
 }
 
 func (logger *CLogger) GetLogger() *logrus.Logger {
@@ -67,55 +125,36 @@ func (logger *CLogger) GetLogger() *logrus.Logger {
 }
 
 func (logger *CLogger) Debug(direction string, i *LogInfo, msg string) {
-	logger.Log.WithFields(logrus.Fields{
-		"method":     i.method,
-		"path":       i.path,
-		"direction":  direction,
-		"clientIP":   i.clientIP,
-		"userAgent":  i.userAgent,
-		"dataLength": i.dataLength,
-		"func":       i.function,
-		"line":       i.line,
-	}).Debug(msg)
+	if i.force && !logger.Log.IsLevelEnabled(logrus.DebugLevel) {
+		logger.forceDebug(direction, i, msg)
+		return
+	}
+	logger.Log.WithFields(i.logrusFields(direction)).Debug(msg)
+}
+
+//forceDebug hands a debug line to the sinks taking debug lines while the
+//logger level is above debug. It fires the hooks without the logrus lock,
+//every sink queues lines and is safe for concurrent use.
+func (logger *CLogger) forceDebug(direction string, i *LogInfo, msg string) {
+	entry := logger.Log.WithFields(i.logrusFields(direction))
+	entry.Time = time.Now()
+	entry.Level = logrus.DebugLevel
+	entry.Message = msg
+	if err := logger.Log.Hooks.Fire(logrus.DebugLevel, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to fire hook: %v\n", err)
+	}
 }
 
 func (logger *CLogger) Info(direction string, i *LogInfo, msg string) {
-	logger.Log.WithFields(logrus.Fields{
-		"method":     i.method,
-		"path":       i.path,
-		"direction":  direction,
-		"clientIP":   i.clientIP,
-		"userAgent":  i.userAgent,
-		"dataLength": i.dataLength,
-		"func":       i.function,
-		"line":       i.line,
-	}).Info(msg)
+	logger.Log.WithFields(i.logrusFields(direction)).Info(msg)
 }
 
 func (logger *CLogger) Error(direction string, i *LogInfo, msg string) {
-	logger.Log.WithFields(logrus.Fields{
-		"method":     i.method,
-		"path":       i.path,
-		"direction":  direction,
-		"clientIP":   i.clientIP,
-		"userAgent":  i.userAgent,
-		"dataLength": i.dataLength,
-		"func":       i.function,
-		"line":       i.line,
-	}).Error(msg)
+	logger.Log.WithFields(i.logrusFields(direction)).Error(msg)
 }
 
 func (logger *CLogger) Fatal(direction string, i *LogInfo, msg string) {
-	logger.Log.WithFields(logrus.Fields{
-		"method":     i.method,
-		"path":       i.path,
-		"direction":  direction,
-		"clientIP":   i.clientIP,
-		"userAgent":  i.userAgent,
-		"dataLength": i.dataLength,
-		"func":       i.function,
-		"line":       i.line,
-	}).Fatal(msg)
+	logger.Log.WithFields(i.logrusFields(direction)).Fatal(msg)
 }
 
 func Log(logger Logger, level string, direction string, i *LogInfo, msg string) {
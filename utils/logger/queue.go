@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+//queued is a formatted line waiting to be shipped
+type queued struct {
+	level logrus.Level
+	line  []byte
+}
+
+//queue hands lines to a shipping goroutine so a slow or unreachable server
+//never holds up the caller. Lines that do not fit are dropped and counted,
+//as are lines coming after close.
+type queue struct {
+	level
+	lines chan queued
+	done  chan struct{}
+	//mu keeps Fire from sending on lines while close closes it
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newQueue(l level, size int) *queue {
+	return &queue{level: l, lines: make(chan queued, size), done: make(chan struct{})}
+}
+
+func (q *queue) Fire(entry *logrus.Entry) error {
+	line, err := q.formatter.Format(entry)
+	if err != nil {
+		q.drop(1)
+		return err
+	}
+	fatal := entry.Level <= logrus.FatalLevel
+	q.mu.RLock()
+	switch {
+	case q.closed:
+		q.drop(1)
+	case fatal:
+		//the process exits after this line, it has to get out
+		q.lines <- queued{level: entry.Level, line: line}
+	default:
+		select {
+		case q.lines <- queued{level: entry.Level, line: line}:
+		default:
+			q.drop(1)
+		}
+	}
+	q.mu.RUnlock()
+	if fatal {
+		q.close()
+	}
+	return nil
+}
+
+//close stops taking lines and waits for the shipper to send what is left,
+//the shipper closes done once the channel is drained
+func (q *queue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.lines)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
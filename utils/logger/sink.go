@@ -0,0 +1,219 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Sink types
+const (
+	SinkStdout        = "stdout"
+	SinkStderr        = "stderr"
+	SinkFile          = "file"
+	SinkElasticsearch = "elasticsearch"
+	SinkSyslog        = "syslog"
+)
+
+// Sink formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+//SinkConfig is one destination of log lines. Level is the lowest level the
+//sink writes, lines below the level of the logger never reach any sink.
+type SinkConfig struct {
+	Type   string `json:"type" mapstructure:"type"`
+	Name   string `json:"name" mapstructure:"name"`
+	Level  string `json:"level" mapstructure:"level"`
+	Format string `json:"format" mapstructure:"format"`
+
+	//file, rotated once MaxSizeMB is reached (100 when zero), rotated files
+	//are deleted after MaxAgeDays or beyond MaxBackups, zero keeps them
+	Path       string `json:"path" mapstructure:"path"`
+	MaxSizeMB  int    `json:"max_size_mb" mapstructure:"max_size_mb"`
+	MaxAgeDays int    `json:"max_age_days" mapstructure:"max_age_days"`
+	MaxBackups int    `json:"max_backups" mapstructure:"max_backups"`
+	Compress   bool   `json:"compress" mapstructure:"compress"`
+
+	//elasticsearch, lines wait in a buffer of BufferSize and are sent in
+	//bulk requests of BatchSize or every FlushInterval. Syslog buffers lines
+	//as well.
+	Endpoint      string        `json:"endpoint" mapstructure:"endpoint"`
+	Index         string        `json:"index" mapstructure:"index"`
+	BufferSize    int           `json:"buffer_size" mapstructure:"buffer_size"`
+	BatchSize     int           `json:"batch_size" mapstructure:"batch_size"`
+	FlushInterval time.Duration `json:"flush_interval" mapstructure:"flush_interval"`
+
+	//syslog, the local daemon when Network is empty
+	Network string `json:"network" mapstructure:"network"`
+	Address string `json:"address" mapstructure:"address"`
+	Tag     string `json:"tag" mapstructure:"tag"`
+}
+
+//LegacySinks are the sinks of server_config.log_path and
+//elasticsearch_endpoint: a text file, stderr when the file cannot be
+//opened, and Elasticsearch from info up
+func LegacySinks(path, elasticsearchEndpoint string) []SinkConfig {
+	sinks := []SinkConfig{{Type: SinkFile, Format: FormatText, Path: path}}
+	if elasticsearchEndpoint != "" {
+		sinks = append(sinks, SinkConfig{Type: SinkElasticsearch, Level: "info", Endpoint: elasticsearchEndpoint})
+	}
+	return sinks
+}
+
+//Sink is a hook writing log lines somewhere
+type Sink interface {
+	logrus.Hook
+	//Name labels the sink in metrics
+	Name() string
+	//Dropped counts lines the sink could not write
+	Dropped() uint64
+	//Close writes what is buffered
+	Close() error
+}
+
+//level is the part every sink shares: its levels, format and drop counter
+type level struct {
+	//first to be 64 bit aligned for atomic access
+	dropped   uint64
+	name      string
+	levels    []logrus.Level
+	formatter logrus.Formatter
+}
+
+func newLevel(cfg SinkConfig) (level, error) {
+	l := level{name: cfg.Name, levels: logrus.AllLevels}
+	if cfg.Level != "" {
+		lvl, err := logrus.ParseLevel(cfg.Level)
+		if err != nil {
+			return l, err
+		}
+		l.levels = logrus.AllLevels[:lvl+1]
+	}
+	switch cfg.Format {
+	case "", FormatJSON:
+		l.formatter = &logrus.JSONFormatter{}
+	case FormatText:
+		l.formatter = &logrus.TextFormatter{DisableColors: true}
+	default:
+		return l, errors.New("unknown log format " + cfg.Format)
+	}
+	return l, nil
+}
+
+func (l *level) Levels() []logrus.Level {
+	return l.levels
+}
+
+func (l *level) Name() string {
+	return l.name
+}
+
+func (l *level) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+func (l *level) drop(n int) {
+	atomic.AddUint64(&l.dropped, uint64(n))
+}
+
+//writerBufferSize is how many lines wait for the console or a local file
+const writerBufferSize = 10000
+
+//writerSink writes lines to the console or a local file, a full disk or a
+//blocked terminal drops lines rather than hold up the caller
+type writerSink struct {
+	*queue
+	w io.Writer
+}
+
+func newWriterSink(l level, w io.Writer) Sink {
+	s := &writerSink{queue: newQueue(l, writerBufferSize), w: w}
+	go s.ship()
+	return s
+}
+
+func (s *writerSink) ship() {
+	defer close(s.done)
+	for q := range s.lines {
+		if _, err := s.w.Write(q.line); err != nil {
+			s.drop(1)
+		}
+	}
+}
+
+func (s *writerSink) Close() error {
+	s.queue.close()
+	if c, ok := s.w.(io.Closer); ok && s.w != os.Stdout && s.w != os.Stderr {
+		return c.Close()
+	}
+	return nil
+}
+
+//NewSink opens the sink described by cfg
+func NewSink(cfg SinkConfig) (Sink, error) {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+	l, err := newLevel(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Type {
+	case SinkStdout:
+		return newWriterSink(l, os.Stdout), nil
+	case SinkStderr:
+		return newWriterSink(l, os.Stderr), nil
+	case SinkFile:
+		return newFileSink(l, cfg), nil
+	case SinkElasticsearch:
+		return newElasticSink(l, cfg)
+	case SinkSyslog:
+		return newSyslogSink(l, cfg), nil
+	}
+	return nil, errors.New("unknown log sink " + cfg.Type)
+}
+
+//newFileSink rotates the file at cfg.Path, lines go to stderr when the file
+//cannot be opened
+func newFileSink(l level, cfg SinkConfig) Sink {
+	f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to log to file, using default stderr")
+		return newWriterSink(l, os.Stderr)
+	}
+	f.Close()
+	return newWriterSink(l, &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxAge:     cfg.MaxAgeDays,
+		MaxBackups: cfg.MaxBackups,
+		Compress:   cfg.Compress,
+	})
+}
+
+//uniqueNames gives sinks sharing a name a number, metrics need them apart
+func uniqueNames(sinks []SinkConfig) []SinkConfig {
+	out := make([]SinkConfig, len(sinks))
+	seen := map[string]int{}
+	for i, cfg := range sinks {
+		if cfg.Name == "" {
+			cfg.Name = cfg.Type
+		}
+		seen[cfg.Name]++
+		if n := seen[cfg.Name]; n > 1 {
+			cfg.Name += "-" + strconv.Itoa(n)
+		}
+		out[i] = cfg
+	}
+	return out
+}
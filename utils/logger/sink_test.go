@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+//blockedWriter holds every write until release is closed
+type blockedWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	lines   int
+}
+
+func (w *blockedWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lines++
+	return len(p), nil
+}
+
+func testLevel(t *testing.T) level {
+	t.Helper()
+	l, err := newLevel(SinkConfig{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestQueueDropsWhenFull(t *testing.T) {
+	w := &blockedWriter{release: make(chan struct{})}
+	s := &writerSink{queue: newQueue(testLevel(t), 4), w: w}
+	go s.ship()
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(s)
+
+	const lines = 50
+	for i := 0; i < lines; i++ {
+		log.Info("line")
+	}
+	//the shipper holds one line, the queue four
+	if s.Dropped() < lines-5 {
+		t.Fatalf("dropped %d lines, want at least %d", s.Dropped(), lines-5)
+	}
+	close(w.release)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.lines+int(s.Dropped()) != lines {
+		t.Fatalf("%d written and %d dropped of %d", w.lines, s.Dropped(), lines)
+	}
+}
+
+func TestLoggingAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bello.log")
+	log, err := NewLogger([]SinkConfig{{Type: SinkFile, Path: path}, {Type: SinkStderr, Level: "error"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Info("N", Trace(), "before close")
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(data), "before close") {
+		t.Fatalf("line lost on close: %q", data)
+	}
+
+	//sessions still ending log their teardown once the sinks are closed
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Info("N", Trace(), "after close")
+			log.Debug("N", (&LogInfo{force: true}), "forced after close")
+		}()
+	}
+	wg.Wait()
+	if got := log.Dropped()[SinkFile]; got != 20 {
+		t.Fatalf("file sink dropped %d lines, want 20", got)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+}
+
+func TestUniqueNames(t *testing.T) {
+	got := uniqueNames([]SinkConfig{{Type: SinkFile}, {Type: SinkFile}, {Type: SinkStdout, Name: "console"}})
+	if got[0].Name != "file" || got[1].Name != "file-2" || got[2].Name != "console" {
+		t.Fatalf("got %+v", got)
+	}
+}
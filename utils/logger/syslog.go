@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"log/syslog"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	syslogBufferSize = 10000
+	defaultSyslogTag = "bello"
+	//syslogRetry is how long lines are dropped after the daemon could not
+	//be reached
+	syslogRetry = 5 * time.Second
+)
+
+//syslogSink sends lines to a syslog daemon, connecting again whenever the
+//connection is lost
+type syslogSink struct {
+	*queue
+	network string
+	address string
+	tag     string
+}
+
+func newSyslogSink(l level, cfg SinkConfig) Sink {
+	s := &syslogSink{network: cfg.Network, address: cfg.Address, tag: cfg.Tag}
+	if s.tag == "" {
+		s.tag = defaultSyslogTag
+	}
+	if cfg.Format == "" {
+		l.formatter = &logrus.TextFormatter{DisableColors: true, DisableTimestamp: true}
+	}
+	size := cfg.BufferSize
+	if size <= 0 {
+		size = syslogBufferSize
+	}
+	s.queue = newQueue(l, size)
+	go s.ship()
+	return s
+}
+
+func (s *syslogSink) ship() {
+	defer close(s.done)
+	var w *syslog.Writer
+	var retry time.Time
+	for q := range s.lines {
+		if w == nil && time.Now().After(retry) {
+			var err error
+			if w, err = syslog.Dial(s.network, s.address, syslog.LOG_INFO|syslog.LOG_DAEMON, s.tag); err != nil {
+				w, retry = nil, time.Now().Add(syslogRetry)
+			}
+		}
+		if w == nil {
+			s.drop(1)
+			continue
+		}
+		if err := write(w, q); err != nil {
+			s.drop(1)
+			w.Close()
+			w = nil
+		}
+	}
+	if w != nil {
+		w.Close()
+	}
+}
+
+//write sends a line with the severity of its level
+func write(w *syslog.Writer, q queued) error {
+	line := string(q.line)
+	switch q.level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return w.Crit(line)
+	case logrus.ErrorLevel:
+		return w.Err(line)
+	case logrus.WarnLevel:
+		return w.Warning(line)
+	case logrus.InfoLevel:
+		return w.Info(line)
+	}
+	return w.Debug(line)
+}
+
+func (s *syslogSink) Close() error {
+	s.queue.close()
+	return nil
+}
@@ -0,0 +1,29 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestThrottle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	//one request a second after a burst of three, shared by every client
+	r.Use(Throttle(1, 3))
+	r.GET("/", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	want := []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, code := range want {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0." + string(rune('1'+i)) + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Fatalf("request %d: got %d, want %d", i, w.Code, code)
+		}
+	}
+}